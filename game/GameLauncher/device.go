@@ -0,0 +1,109 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// deviceAuthorization is the response of the frontend api to starting a device sign in
+type deviceAuthorization struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationUri         string `json:"verificationUri"`
+	VerificationUriComplete string `json:"verificationUriComplete"`
+	ExpiresIn               int64  `json:"expiresIn"`
+	Interval                int64  `json:"interval"`
+}
+
+// errDeviceSignInFailed is returned by pollDeviceToken when the player declined, or took too long, to sign in
+var errDeviceSignInFailed = errors.New("sign in was declined or expired, please try again")
+
+// startDeviceSignIn starts a device sign in. The player signs in by entering the user code on the verification page,
+// in any browser, while the launcher polls for the tokens
+func startDeviceSignIn() (*deviceAuthorization, error) {
+	response, err := http.Post(iniCfg.Section("").Key("frontend_api").String()+"/device/code", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to start sign in, status code: %d", response.StatusCode)
+	}
+
+	var auth deviceAuthorization
+	if err := json.NewDecoder(response.Body).Decode(&auth); err != nil {
+		return nil, fmt.Errorf("unable to decode device authorization: %w", err)
+	}
+	return &auth, nil
+}
+
+// pollDeviceToken polls for the tokens of the device sign in until the player signed in, or cancel is closed
+func pollDeviceToken(auth *deviceAuthorization, cancel <-chan struct{}) (tokenPair, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	body, err := json.Marshal(map[string]string{"deviceCode": auth.DeviceCode})
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-cancel:
+			return tokenPair{}, errors.New("sign in cancelled")
+		case <-time.After(interval):
+		}
+
+		response, err := http.Post(iniCfg.Section("").Key("frontend_api").String()+"/device/token", "application/json", bytes.NewReader(body))
+		if err != nil {
+			// Keep polling through network hiccups
+			continue
+		}
+
+		var tokens tokenPair
+		var result struct {
+			Error string `json:"error"`
+		}
+		if response.StatusCode == http.StatusOK {
+			err = json.NewDecoder(response.Body).Decode(&tokens)
+		} else {
+			err = json.NewDecoder(response.Body).Decode(&result)
+		}
+		response.Body.Close()
+		if err != nil {
+			return tokenPair{}, fmt.Errorf("unable to decode response: %w", err)
+		}
+		if response.StatusCode == http.StatusOK {
+			return tokens, nil
+		}
+
+		switch result.Error {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied", "expired_token":
+			return tokenPair{}, errDeviceSignInFailed
+		default:
+			return tokenPair{}, fmt.Errorf("unable to sign in, status code: %d: %s", response.StatusCode, result.Error)
+		}
+	}
+	return tokenPair{}, errDeviceSignInFailed
+}
@@ -16,7 +16,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
@@ -24,7 +26,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
-	"time"
+	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -37,7 +39,6 @@ import (
 )
 
 var (
-	myToken  string
 	myApp    fyne.App
 	myWindow fyne.Window
 	iniCfg   *ini.File
@@ -52,10 +53,9 @@ func main() {
 		os.Exit(1)
 	}
 
-	myToken = ""
-
 	// Callback handling from the frontend api
 	http.HandleFunc("/callback", handleGoogleCallback)
+	http.HandleFunc("/link", handleLinkPage)
 	go func() {
 		log.Printf("Google for Games Launcher is listening for callbacks on :%s", iniCfg.Section("").Key("callback_listen_port").String())
 		log.Println(http.ListenAndServe(":"+iniCfg.Section("").Key("callback_listen_port").String(), nil))
@@ -68,37 +68,102 @@ func main() {
 	myWindow.Resize(fyne.NewSize(320, 480))
 	myWindow.CenterOnScreen()
 
+	signInUI()
+
+	// If we have a valid token, let's use it and update the UI right away
+	if mySession.load() {
+		playerName := getPlayerName()
+		updateUI(playerName)
+	}
+
+	myWindow.ShowAndRun()
+}
+
+func signInUI() {
 	buttonSignIn := widget.NewButtonWithIcon("Sign-in with Google", theme.HomeIcon(), func() {
+		// Signing in with a code works on any machine, even without a browser. The callback needs a free local port
+		if iniCfg.Section("").Key("sign_in_flow").MustString("callback") == "device" {
+			deviceSignInUI()
+			return
+		}
 		openBrowser(iniCfg.Section("").Key("frontend_api").String() + "/login")
 	})
 
+	buttonGuest := widget.NewButtonWithIcon("Play as guest", theme.AccountIcon(), func() {
+		log.Println("Tapped play as guest")
+		if err := mySession.signInAsGuest(); err != nil {
+			log.Printf("Unable to sign in as guest: %s", err)
+			return
+		}
+		updateUI(getPlayerName())
+	})
+
 	buttonExit := widget.NewButtonWithIcon("Exit", theme.CancelIcon(), func() {
 		log.Println("Tapped exit")
 		myApp.Quit()
 	})
 
-	subGrid := container.New(layout.NewGridLayout(1), layout.NewSpacer(), buttonSignIn, buttonExit)
+	subGrid := container.New(layout.NewGridLayout(1), layout.NewSpacer(), buttonSignIn, buttonGuest, buttonExit)
 	grid := container.New(layout.NewVBoxLayout(), headerImage(), subGrid)
 
 	myWindow.SetContent(grid)
+}
 
-	// If we have a valid token, let's use it and update the UI right away
-	if loadToken() {
-		playerName := getPlayerName()
-		updateUI(playerName)
+// deviceSignInUI shows the code to sign in with in a browser, and waits for the player to sign in
+func deviceSignInUI() {
+	auth, err := startDeviceSignIn()
+	if err != nil {
+		log.Printf("Unable to start sign in: %s", err)
+		return
 	}
 
-	myWindow.ShowAndRun()
+	cancel := make(chan struct{})
+	label1 := widget.NewLabel(fmt.Sprintf("Go to %s and enter the code:", auth.VerificationUri))
+	label1.Alignment = fyne.TextAlignCenter
+	label1.Wrapping = fyne.TextWrapWord
+	code := widget.NewLabelWithStyle(auth.UserCode, fyne.TextAlignCenter, fyne.TextStyle{Bold: true, Monospace: true})
+
+	buttonBrowser := widget.NewButtonWithIcon("Open browser", theme.ComputerIcon(), func() {
+		openBrowser(auth.VerificationUriComplete)
+	})
+	buttonCancel := widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), func() {
+		close(cancel)
+		signInUI()
+	})
+
+	subGrid := container.New(layout.NewGridLayout(1), label1, code, buttonBrowser, buttonCancel)
+	grid := container.New(layout.NewVBoxLayout(), headerImage(), subGrid)
+	myWindow.SetContent(grid)
+
+	go func() {
+		tokens, err := pollDeviceToken(auth, cancel)
+		if err != nil {
+			log.Printf("Unable to sign in: %s", err)
+			select {
+			case <-cancel:
+			default:
+				signInUI()
+			}
+			return
+		}
+
+		mySession.set(tokens)
+		updateUI(getPlayerName())
+	}()
 }
 
 func handleGoogleCallback(rw http.ResponseWriter, req *http.Request) {
-	// Save my token
-	myToken = req.FormValue("token")
-	if len(myToken) == 0 {
-		log.Fatal("No token received!")
+	// The frontend api redirects with a one-time code, which we exchange for our tokens
+	code := req.FormValue("code")
+	if len(code) == 0 {
+		http.Error(rw, "No login code received!", http.StatusBadRequest)
+		return
+	}
+	if err := mySession.exchangeCode(code); err != nil {
+		log.Printf("Unable to sign in: %s", err)
+		http.Error(rw, "Unable to sign in, please try again.", http.StatusBadGateway)
+		return
 	}
-
-	saveToken(myToken)
 
 	// Update UI with profile info and launch game button
 	playerName := getPlayerName()
@@ -152,13 +217,30 @@ func updateUI(playerName string) {
 		handlePlay(windowed.Checked, instances.SelectedIndex()+1, resolutions.Selected)
 	})
 
+	buttonSignOut := widget.NewButtonWithIcon("Sign out", theme.LogoutIcon(), func() {
+		log.Println("Tapped sign out")
+		mySession.logout()
+		signInUI()
+	})
+
 	buttonExit := widget.NewButtonWithIcon("Exit", theme.CancelIcon(), func() {
 		log.Println("Tapped exit")
 		myApp.Quit()
 	})
 
 	infoGrid := container.New(layout.NewGridLayout(1), label1, label2)
-	subGrid := container.New(layout.NewGridLayout(1), infoGrid, buttonPlay, buttonExit)
+	subGrid := container.New(layout.NewGridLayout(1), infoGrid, buttonPlay)
+
+	// Guests keep their progress by linking a Google account, which signs them in with it
+	if mySession.guest() {
+		buttonLink := widget.NewButtonWithIcon("Save progress with Google", theme.HomeIcon(), func() {
+			log.Println("Tapped link account")
+			handleLinkAccount()
+		})
+		subGrid.Add(buttonLink)
+	}
+	subGrid.Add(buttonSignOut)
+	subGrid.Add(buttonExit)
 	grid := container.New(layout.NewVBoxLayout(), headerImage(), subGrid, clientLayout)
 	myWindow.SetContent(grid)
 }
@@ -173,7 +255,22 @@ func headerImage() *canvas.Image {
 }
 
 func handlePlay(windowed bool, instances int, res string) {
-	params := []string{fmt.Sprintf("-token=%s", myToken), fmt.Sprintf("-frontend_api=%s", iniCfg.Section("").Key("frontend_api").String())}
+	// The game keeps the token it is launched with, so launch it with a fresh one
+	if err := mySession.refresh(); err != nil {
+		log.Printf("Unable to refresh token: %s", err)
+		if errors.Is(err, errSignedOut) {
+			signInUI()
+			return
+		}
+	}
+	token, err := mySession.token()
+	if err != nil {
+		log.Printf("Unable to get token: %s", err)
+		signInUI()
+		return
+	}
+
+	params := []string{fmt.Sprintf("-token=%s", token), fmt.Sprintf("-frontend_api=%s", iniCfg.Section("").Key("frontend_api").String())}
 
 	if windowed {
 		params = append(params, "-WINDOWED")
@@ -200,19 +297,75 @@ func handlePlay(windowed bool, instances int, res string) {
 
 }
 
-func getPlayerName() string {
-	log.Printf("Getting player info")
+// linkPage posts the link ticket to the frontend api from the browser, so the ticket is never in a URL
+var linkPage = template.Must(template.New("link").Parse(`<form id="link" method="POST" action="{{.URL}}">
+	<input type="hidden" name="link" value="{{.Ticket}}">
+	<noscript><button type="submit">Continue</button></noscript>
+</form>
+<script>document.getElementById("link").submit()</script>`))
 
-	req, err := http.NewRequest("GET", iniCfg.Section("").Key("frontend_api").String()+"/profile", nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", myToken))
+// pendingLink is the link ticket the link page posts, and the URL it posts it to
+var (
+	pendingLinkMu sync.Mutex
+	pendingLink   *linkRequest
+)
+
+type linkRequest struct {
+	URL    string `json:"url"`
+	Ticket string `json:"ticket"`
+}
+
+// Serves the link page of the pending link, once
+func handleLinkPage(rw http.ResponseWriter, req *http.Request) {
+	pendingLinkMu.Lock()
+	link := pendingLink
+	pendingLink = nil
+	pendingLinkMu.Unlock()
+
+	if link == nil {
+		http.Error(rw, "No account link in progress, please start again from the launcher.", http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := linkPage.Execute(rw, link); err != nil {
+		log.Printf("Unable to write link page: %s", err)
+	}
+}
 
+// Opens the browser to link a Google account to the guest. Once linked, the frontend api calls back with a login
+// code for the upgraded player
+func handleLinkAccount() {
+	response, err := mySession.authorizedRequest("POST", "/link/google")
 	if err != nil {
-		log.Fatal("Unable to initiate request to game api. Connection issues?")
+		log.Printf("Unable to link account: %s", err)
+		return
 	}
+	defer response.Body.Close()
 
-	client := &http.Client{}
-	response, err := client.Do(req)
+	if response.StatusCode != 200 {
+		log.Printf("Unable to link account, status code: %d", response.StatusCode)
+		return
+	}
 
+	var link linkRequest
+	if err := json.NewDecoder(response.Body).Decode(&link); err != nil {
+		log.Printf("Unable to decode json: %s", err)
+		return
+	}
+	link.URL = iniCfg.Section("").Key("frontend_api").String() + link.URL
+
+	// The browser gets the ticket from our link page, which posts it to the frontend api
+	pendingLinkMu.Lock()
+	pendingLink = &link
+	pendingLinkMu.Unlock()
+	openBrowser("http://localhost:" + iniCfg.Section("").Key("callback_listen_port").String() + "/link")
+}
+
+func getPlayerName() string {
+	log.Printf("Getting player info")
+
+	response, err := mySession.authorizedRequest("GET", "/profile")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -251,49 +404,3 @@ func openBrowser(url string) {
 		log.Fatal(err)
 	}
 }
-
-func saveToken(token string) {
-	dirname, err := os.UserHomeDir()
-	filename := dirname + "/droidshooter.jwt"
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = os.WriteFile(filename, []byte(token), 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func loadToken() bool {
-	dirname, err := os.UserHomeDir()
-	filename := dirname + "/droidshooter.jwt"
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return false
-	}
-
-	file, err := os.Stat(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	modifiedtime := file.ModTime()
-	in30Days := time.Now().Add(24 * time.Hour * 30)
-
-	if modifiedtime.After(in30Days) {
-		log.Printf("Token is old. Deleting.")
-		os.Remove(filename)
-		return false
-	}
-
-	myToken = string(data)
-	log.Printf("Token loaded from file")
-	return true
-}
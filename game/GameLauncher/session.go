@@ -0,0 +1,366 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Refresh the access token this long before it expires, so it is always valid when used
+const refreshMargin = time.Minute
+
+// errSignedOut is returned when the session has ended, and the player has to sign in again
+var errSignedOut = errors.New("signed out, please sign in again")
+
+// session holds the player's access token and the refresh token it is renewed with
+type session struct {
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expires      time.Time
+	timer        *time.Timer
+}
+
+var mySession = &session{}
+
+// tokenPair is the response of the frontend api to a refresh
+type tokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// set replaces the tokens, saves them, and schedules the next refresh
+func (s *session) set(tokens tokenPair) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessToken = tokens.AccessToken
+	s.refreshToken = tokens.RefreshToken
+	s.expires = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	saveTokens(s.accessToken, s.refreshToken)
+
+	// Keep the token fresh in the background, so it's valid whenever the player launches the game
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(time.Until(s.expires.Add(-refreshMargin)), func() {
+		if err := s.refresh(); err != nil {
+			log.Printf("Unable to refresh token: %s", err)
+		}
+	})
+}
+
+// token returns a valid access token, refreshing it first if it is about to expire
+func (s *session) token() (string, error) {
+	s.mu.Lock()
+	token, expires := s.accessToken, s.expires
+	s.mu.Unlock()
+
+	if token == "" {
+		return "", errSignedOut
+	}
+	if time.Until(expires) > refreshMargin {
+		return token, nil
+	}
+	if err := s.refresh(); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accessToken, nil
+}
+
+// refresh exchanges the refresh token for new tokens. Refresh tokens can only be used once, so refreshes don't
+// overlap. If the session has ended, the tokens are cleared and errSignedOut is returned
+func (s *session) refresh() error {
+	s.mu.Lock()
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+	if refreshToken == "" {
+		return errSignedOut
+	}
+
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	// Another refresh may have happened while waiting
+	s.mu.Lock()
+	if s.refreshToken != refreshToken {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	response, err := postRefreshToken("/token/refresh", refreshToken)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		s.clear()
+		return errSignedOut
+	default:
+		return fmt.Errorf("unable to refresh token, status code: %d", response.StatusCode)
+	}
+
+	var tokens tokenPair
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("unable to decode tokens: %w", err)
+	}
+	s.set(tokens)
+	log.Printf("Token refreshed")
+	return nil
+}
+
+// exchangeCode exchanges the one-time code the frontend api redirected the browser with for the tokens of the new
+// session
+func (s *session) exchangeCode(code string) error {
+	body, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return err
+	}
+	response, err := http.Post(iniCfg.Section("").Key("frontend_api").String()+"/token/exchange", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to exchange login code, status code: %d", response.StatusCode)
+	}
+
+	var tokens tokenPair
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("unable to decode tokens: %w", err)
+	}
+	s.set(tokens)
+	return nil
+}
+
+// refreshMu keeps refreshes from overlapping
+var refreshMu sync.Mutex
+
+// guest reports whether the player is signed in as a guest, per the claims of the access token
+func (s *session) guest() bool {
+	s.mu.Lock()
+	token := s.accessToken
+	s.mu.Unlock()
+
+	// The launcher doesn't verify tokens, it only reads their claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Guest bool `json:"guest"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	return claims.Guest
+}
+
+// signInAsGuest signs in as the guest of this device. Returns errGuestUpgraded if the guest linked an identity
+// provider, and has to sign in with it instead
+func (s *session) signInAsGuest() error {
+	body, err := json.Marshal(map[string]string{"deviceId": deviceID()})
+	if err != nil {
+		return err
+	}
+	response, err := http.Post(iniCfg.Section("").Key("frontend_api").String()+"/guest", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return errGuestUpgraded
+	default:
+		return fmt.Errorf("unable to sign in as guest, status code: %d", response.StatusCode)
+	}
+
+	var tokens tokenPair
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("unable to decode tokens: %w", err)
+	}
+	s.set(tokens)
+	return nil
+}
+
+// errGuestUpgraded is returned by signInAsGuest when the guest of this device linked an identity provider
+var errGuestUpgraded = errors.New("this guest account was linked, please sign in")
+
+// logout ends the session on the frontend api, and forgets the tokens
+func (s *session) logout() {
+	s.mu.Lock()
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+
+	if refreshToken != "" {
+		response, err := postRefreshToken("/logout", refreshToken)
+		if err != nil {
+			log.Printf("Unable to log out: %s", err)
+		} else {
+			response.Body.Close()
+		}
+	}
+	s.clear()
+}
+
+// clear forgets the tokens, deleting them from disk
+func (s *session) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessToken, s.refreshToken, s.expires = "", "", time.Time{}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	os.Remove(tokenFile(accessTokenFile))
+	os.Remove(tokenFile(refreshTokenFile))
+}
+
+// load loads the saved tokens, and refreshes them, as the saved access token may have expired. Returns false if
+// the player has to sign in
+func (s *session) load() bool {
+	access, err := os.ReadFile(tokenFile(accessTokenFile))
+	if err != nil {
+		return false
+	}
+	refresh, err := os.ReadFile(tokenFile(refreshTokenFile))
+	if err != nil {
+		// Tokens from before refresh tokens can't be renewed
+		log.Printf("No refresh token. Deleting old token.")
+		os.Remove(tokenFile(accessTokenFile))
+		return false
+	}
+
+	s.mu.Lock()
+	s.accessToken = strings.TrimSpace(string(access))
+	s.refreshToken = strings.TrimSpace(string(refresh))
+	s.mu.Unlock()
+	log.Printf("Token loaded from file")
+
+	if err := s.refresh(); err != nil {
+		log.Printf("Unable to refresh token: %s", err)
+		return false
+	}
+	return true
+}
+
+// authorizedRequest sends the request with the access token, refreshing the token and trying again once if it
+// was rejected
+func (s *session) authorizedRequest(method, path string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := s.token()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(method, iniCfg.Section("").Key("frontend_api").String()+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil || response.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return response, err
+		}
+		response.Body.Close()
+
+		if err := s.refresh(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func postRefreshToken(path, refreshToken string) (*http.Response, error) {
+	body, err := json.Marshal(map[string]string{"refreshToken": refreshToken})
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(iniCfg.Section("").Key("frontend_api").String()+path, "application/json", bytes.NewReader(body))
+}
+
+const (
+	accessTokenFile  = "droidshooter.jwt"
+	refreshTokenFile = "droidshooter.refresh"
+	deviceIDFile     = "droidshooter.device"
+)
+
+func tokenFile(name string) string {
+	dirname, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return filepath.Join(dirname, name)
+}
+
+func saveTokens(accessToken, refreshToken string) {
+	// Only the player can read their tokens
+	for name, token := range map[string]string{accessTokenFile: accessToken, refreshTokenFile: refreshToken} {
+		if err := os.WriteFile(tokenFile(name), []byte(token), 0600); err != nil {
+			log.Fatal(err)
+		}
+		// Files written by older launchers were readable by everyone
+		if err := os.Chmod(tokenFile(name), 0600); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// deviceID returns the id guests of this device sign in with, generating it on first use. It is kept when signing
+// out, so the device signs in as the same guest again
+func deviceID() string {
+	if id, err := os.ReadFile(tokenFile(deviceIDFile)); err == nil && len(bytes.TrimSpace(id)) > 0 {
+		return string(bytes.TrimSpace(id))
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	// Anyone with the device id can sign in as its guest, so only the player can read it
+	if err := os.WriteFile(tokenFile(deviceIDFile), []byte(id), 0600); err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
@@ -152,7 +152,7 @@ void UDroidshooterIntroUserWidget::FetchGameServer(const FString& frontendApi, c
                     /*std::function<void(const TSharedPtr<FJsonObject>&)> f = [=](const TSharedPtr<FJsonObject>& JsonResponseObject) {
                         // do stuff here or call a method
                     };*/
-                    ProcessGameserverResponse(pResponse->GetContentAsString());
+                    ProcessTicketResponse(pResponse->GetContentAsString());
                 }
                 else {
                     switch (pRequest->GetStatus()) {
@@ -193,40 +193,102 @@ void UDroidshooterIntroUserWidget::ProcessProfileResponse(const FString& Respons
     }
 }
 
-void UDroidshooterIntroUserWidget::ProcessGameserverResponse(const FString& ResponseContent) 
+void UDroidshooterIntroUserWidget::PollTicketStatus()
+{
+    UE_LOG(LogDroidshooter, Log, TEXT("Polling matchmaking ticket: %s"), *TicketId);
+
+    FString uriTicket = FrontendApi + TEXT("/play/") + TicketId;
+
+    FHttpModule& httpModule = FHttpModule::Get();
+    TSharedRef<IHttpRequest, ESPMode::ThreadSafe> pRequest = httpModule.CreateRequest();
+
+    pRequest->SetHeader("Authorization", "Bearer " + GlobalAccessToken);
+    pRequest->SetVerb(TEXT("GET"));
+    pRequest->SetURL(uriTicket);
+    pRequest->SetHeader(TEXT("User-Agent"), "X-UnrealEngine-Agent");
+    pRequest->SetHeader(TEXT("Accepts"), TEXT("application/json"));
+
+    // Set the callback, which will execute when the HTTP call is complete
+    pRequest->OnProcessRequestComplete().BindLambda(
+        [&](
+            FHttpRequestPtr pRequest,
+            FHttpResponsePtr pResponse,
+            bool connectedSuccessfully) mutable {
+
+                if (connectedSuccessfully) {
+                    ProcessTicketResponse(pResponse->GetContentAsString());
+                }
+                else {
+                    // Keep polling, the next poll may succeed
+                    UE_LOG(LogDroidshooter, Log, TEXT("Ticket status request failed."));
+                }
+        });
+
+    // Finally, submit the request for processing
+    pRequest->ProcessRequest();
+}
+
+void UDroidshooterIntroUserWidget::ProcessTicketResponse(const FString& ResponseContent)
 {
     TSharedRef<TJsonReader<TCHAR>> JsonReader = TJsonReaderFactory<TCHAR>::Create(ResponseContent);
     TSharedPtr<FJsonObject> JsonResponseObject;
 
-    if (FJsonSerializer::Deserialize(JsonReader, JsonResponseObject))
+    if (!FJsonSerializer::Deserialize(JsonReader, JsonResponseObject) || !JsonResponseObject)
     {
-        if (JsonResponseObject)
-        {
-            FString IP = JsonResponseObject->GetStringField(TEXT("IP"));
-            FString Port = JsonResponseObject->GetStringField(TEXT("Port"));
+        UE_LOG(LogDroidshooter, Log, TEXT("Unable to read matchmaking ticket: %s"), *ResponseContent);
+        GetWorld()->GetTimerManager().ClearTimer(TicketTimerHandle);
+        return;
+    }
+
+    FString Status = JsonResponseObject->GetStringField(TEXT("status"));
+
+    if (Status == TEXT("pending")) {
+        // Keep polling until the ticket is assigned a game server
+        if (!GetWorld()->GetTimerManager().IsTimerActive(TicketTimerHandle)) {
+            TicketId = JsonResponseObject->GetStringField(TEXT("ticketId"));
+            GetWorld()->GetTimerManager().SetTimer(TicketTimerHandle, this, &UDroidshooterIntroUserWidget::PollTicketStatus, 2.0f, true);
+        }
+        return;
+    }
+
+    GetWorld()->GetTimerManager().ClearTimer(TicketTimerHandle);
 
-            if (IP.Len() != 0 && Port.Len() != 0) {
-                // Set IP - Port variables!
-                ServerIPValue = IP;
-                ServerPortValue = Port;
+    if (Status == TEXT("assigned")) {
+        ProcessGameserverConnection(JsonResponseObject->GetObjectField(TEXT("connection")));
+    }
+    else {
+        UE_LOG(LogDroidshooter, Log, TEXT("Matchmaking ended without a game server: %s"), *ResponseContent);
+    }
+}
 
-                ServerIPBox->SetText(FText::FromString(IP));
-                ServerPortBox->SetText(FText::FromString(Port));
+void UDroidshooterIntroUserWidget::ProcessGameserverConnection(const TSharedPtr<FJsonObject>& JsonConnectionObject)
+{
+    if (JsonConnectionObject)
+    {
+        FString IP = JsonConnectionObject->GetStringField(TEXT("IP"));
+        FString Port = JsonConnectionObject->GetStringField(TEXT("Port"));
 
-                UE_LOG(LogDroidshooter, Log, TEXT("Found game server at: %s %s"), *IP, *Port);
+        if (IP.Len() != 0 && Port.Len() != 0) {
+            // Set IP - Port variables!
+            ServerIPValue = IP;
+            ServerPortValue = Port;
 
-                UFunction* Function = this->FindFunction("ConnectToOnlineGame");
+            ServerIPBox->SetText(FText::FromString(IP));
+            ServerPortBox->SetText(FText::FromString(Port));
 
-                if (Function == nullptr)
-                {
-                    return;
-                }
+            UE_LOG(LogDroidshooter, Log, TEXT("Found game server at: %s %s"), *IP, *Port);
 
-                this->ProcessEvent(Function, {});
-            }
-            else {
-                UE_LOG(LogDroidshooter, Log, TEXT("Unable to server player data. Timed out token?"));
+            UFunction* Function = this->FindFunction("ConnectToOnlineGame");
+
+            if (Function == nullptr)
+            {
+                return;
             }
+
+            this->ProcessEvent(Function, {});
+        }
+        else {
+            UE_LOG(LogDroidshooter, Log, TEXT("Unable to server player data. Timed out token?"));
         }
     }
 }
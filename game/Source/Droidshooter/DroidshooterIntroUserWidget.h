@@ -42,11 +42,13 @@ public:
 	void FindPreferredGameServerLocation(const FString& frontendApi, const FString& accessToken);
 
 	void ProcessProfileResponse(const FString& ResponseContent);
-	void ProcessGameserverResponse(const FString& ResponseContent);
+	void ProcessTicketResponse(const FString& ResponseContent);
+	void ProcessGameserverConnection(const TSharedPtr<FJsonObject>& JsonConnectionObject);
 	void ProcessServersToPingResponse(const FString& ResponseContent);
 	void ProcessGenericJsonResponse(const FString& ResponseContent, std::function<void(const TSharedPtr<FJsonObject>&)>& func);
 
 	void AllServersValidated();
+	void PollTicketStatus();
 
 	// Server IP/Port editboxes
 	UPROPERTY(EditAnywhere, BlueprintReadWrite, meta = (BindWidget))
@@ -77,6 +79,10 @@ public:
 private:
 
 	FTimerHandle MemberTimerHandle;
+	FTimerHandle TicketTimerHandle;
+
+	/** Matchmaking ticket we are waiting on an assignment for */
+	FString TicketId;
 	DroidshooterServerPing ServerPinger;
 
 };
@@ -64,8 +64,12 @@ resource "google_clouddeploy_delivery_pipeline" "services_pipeline" {
           frontend_client_id         = var.frontend-service.client_id
           frontend_client_secret     = var.frontend-service.client_secret
           frontend_jwt_key           = var.frontend-service.jwt_key
+          frontend_api_access_key    = var.frontend-service.api_access_key
+          frontend_join_token_key    = var.frontend-service.join_token_key
+          frontend_oauth_state_key   = var.frontend-service.oauth_state_key
           frontend_service_address   = google_compute_address.frontend-service.address
           frontend_callback_hostname = "http://${google_compute_address.frontend-service.address}.sslip.io/callback"
+          frontend_redis_address     = "${google_redis_instance.open-match.host}:${google_redis_instance.open-match.port}"
 
           # Open Match config
           players_per_match = var.open-match-matchfunction.players_per_match
@@ -118,9 +118,12 @@ variable "k8s_service_account_id" {
 
 variable "frontend-service" {
   type = object({
-    client_id     = string
-    client_secret = string
-    jwt_key       = string
+    client_id       = string
+    client_secret   = string
+    jwt_key         = string
+    api_access_key  = string
+    join_token_key  = string
+    oauth_state_key = string
   })
   description = "Configuration for the frontend service that provides oAuth authentications"
 }
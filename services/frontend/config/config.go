@@ -0,0 +1,562 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config provides the configuration of the frontend API.
+//
+// Configuration is read from environment variables and an optional config file, frontend.yml in the working
+// directory or the file set by CONFIG_FILE. Environment variables take precedence over the config file, and defaults
+// are set for everything that isn't required. Secrets can be read from files instead, by setting <NAME>_FILE, e.g.
+// JWT_KEY_FILE for JWT_KEY, or the key of the secret suffixed by _file in the config file.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/device"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/identity"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/jointoken"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/match"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared/auth"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared/redis"
+	"github.com/spf13/viper"
+)
+
+// Config contains all of the configuration of the frontend API
+type Config struct {
+	Server      ServerConfig
+	Services    ServicesConfig
+	Auth        AuthConfig
+	Identity    IdentityConfig
+	Matchmaking MatchmakingConfig
+	OpenMatch   OpenMatchConfig `mapstructure:"openmatch"`
+	JoinToken   JoinTokenConfig `mapstructure:"jointoken"`
+}
+
+// ServerConfig contains how the frontend API is served, and how it is reached by browsers and the launcher
+type ServerConfig struct {
+	Listen_port int
+	// Metrics_port serves the Prometheus metrics, which aren't exposed on the public listen port
+	Metrics_port int
+	// Callback_hostname is the URL identity providers redirect to after sign in
+	Callback_hostname    string
+	Client_launcher_port int
+	// Drain_delay is how long the frontend API keeps serving while unready when shutting down, and Shutdown_timeout
+	// how long requests in flight then have to complete
+	Drain_delay      time.Duration
+	Shutdown_timeout time.Duration
+	// Replicas is the number of replicas of the frontend API. More than one need the state they share in Redis
+	Replicas int
+}
+
+// ServicesConfig contains the services the frontend API depends on
+type ServicesConfig struct {
+	Profile         string
+	Profile_timeout time.Duration
+	Profile_retries int
+	Ping            string
+	// Redis_addr is the host:port of the Redis server that tickets, parties, sessions and device codes are shared
+	// through. They are kept per replica if empty.
+	Redis_addr string
+	// Redis_password authenticates to Redis, and Redis_tls connects over TLS, verifying the server against
+	// Redis_ca_cert if set
+	Redis_password string
+	Redis_tls      bool
+	Redis_ca_cert  string
+}
+
+// AuthConfig contains the keys and lifetimes of tokens and sessions
+type AuthConfig struct {
+	Jwt_key           string
+	Jwt_keys_dir      string
+	Jwt_signing_kid   string
+	Jwt_retired_kids  []string
+	Api_access_key    string
+	Oauth_state_key   string
+	Access_token_ttl  time.Duration
+	Session_ttl       time.Duration
+	Guest_session_ttl time.Duration
+	Device_code_ttl   time.Duration
+	// Guest_rate_limit is how many guest sign ins each client IP can make per minute
+	Guest_rate_limit int
+}
+
+// IdentityConfig contains the identity providers players sign in with, the first being the default
+type IdentityConfig struct {
+	Providers []string
+	Google    GoogleConfig
+	// Oidc contains the OpenID Connect providers, by name
+	Oidc map[string]OIDCConfig
+}
+
+// GoogleConfig contains the OAuth client of the google identity provider
+type GoogleConfig struct {
+	Client_id     string
+	Client_secret string
+}
+
+// OIDCConfig contains the issuer and OAuth client of an OpenID Connect identity provider
+type OIDCConfig struct {
+	Issuer        string
+	Client_id     string
+	Client_secret string
+	Scopes        []string
+}
+
+// MatchmakingConfig contains how players are matched
+type MatchmakingConfig struct {
+	Matchmaker           string
+	Active_ticket_policy string
+	Max_party_size       int
+	// Fallback_skill is the skill players are matched with when their profile can't be retrieved
+	Fallback_skill int64
+	// Players_per_match is the size of matches, made by the local matchmaker or the match function. Parties can't be
+	// larger
+	Players_per_match int
+	// Local_servers are the game servers of the local matchmaker
+	Local_servers []string
+}
+
+// OpenMatchConfig contains the connection to the Open Match frontend service
+type OpenMatchConfig struct {
+	Frontend_endpoint string
+	Tls               bool
+	Ca_cert           string
+	Client_cert       string
+	Client_key        string
+	Server_name       string
+	Dial_timeout      time.Duration
+	Keepalive_time    time.Duration
+	Keepalive_timeout time.Duration
+}
+
+// JoinTokenConfig contains the keys and lifetime of the join tokens that admit players to their game server
+type JoinTokenConfig struct {
+	Key         string
+	Private_key string
+	Ttl         time.Duration
+}
+
+// envs are the environment variables of the configuration keys
+var envs = map[string]string{
+	"server.listen_port":               "LISTEN_PORT",
+	"server.metrics_port":              "METRICS_PORT",
+	"server.callback_hostname":         "CALLBACK_HOSTNAME",
+	"server.client_launcher_port":      "CLIENT_LAUNCHER_PORT",
+	"server.drain_delay":               "DRAIN_DELAY",
+	"server.shutdown_timeout":          "SHUTDOWN_TIMEOUT",
+	"server.replicas":                  "REPLICAS",
+	"services.profile":                 "PROFILE_SERVICE",
+	"services.profile_timeout":         "PROFILE_SERVICE_TIMEOUT",
+	"services.profile_retries":         "PROFILE_SERVICE_RETRIES",
+	"services.ping":                    "PING_SERVICE",
+	"services.redis_addr":              "REDIS_ADDR",
+	"services.redis_password":          "REDIS_PASSWORD",
+	"services.redis_tls":               "REDIS_TLS",
+	"services.redis_ca_cert":           "REDIS_CA_CERT",
+	"auth.jwt_key":                     "JWT_KEY",
+	"auth.jwt_keys_dir":                "JWT_KEYS_DIR",
+	"auth.jwt_signing_kid":             "JWT_SIGNING_KID",
+	"auth.jwt_retired_kids":            "JWT_RETIRED_KIDS",
+	"auth.api_access_key":              "API_ACCESS_KEY",
+	"auth.oauth_state_key":             "OAUTH_STATE_KEY",
+	"auth.access_token_ttl":            "ACCESS_TOKEN_TTL",
+	"auth.session_ttl":                 "SESSION_TTL",
+	"auth.guest_session_ttl":           "GUEST_SESSION_TTL",
+	"auth.device_code_ttl":             "DEVICE_CODE_TTL",
+	"auth.guest_rate_limit":            "GUEST_RATE_LIMIT",
+	"identity.providers":               "IDENTITY_PROVIDERS",
+	"identity.google.client_id":        "CLIENT_ID",
+	"identity.google.client_secret":    "CLIENT_SECRET",
+	"matchmaking.matchmaker":           "MATCHMAKER",
+	"matchmaking.active_ticket_policy": "ACTIVE_TICKET_POLICY",
+	"matchmaking.max_party_size":       "MAX_PARTY_SIZE",
+	"matchmaking.fallback_skill":       "FALLBACK_SKILL",
+	"matchmaking.players_per_match":    "PLAYERS_PER_MATCH",
+	"matchmaking.local_servers":        "LOCAL_MATCHMAKER_SERVERS",
+	"openmatch.frontend_endpoint":      "OPENMATCH_FRONTEND_ENDPOINT",
+	"openmatch.tls":                    "OPENMATCH_TLS",
+	"openmatch.ca_cert":                "OPENMATCH_CA_CERT",
+	"openmatch.client_cert":            "OPENMATCH_CLIENT_CERT",
+	"openmatch.client_key":             "OPENMATCH_CLIENT_KEY",
+	"openmatch.server_name":            "OPENMATCH_SERVER_NAME",
+	"openmatch.dial_timeout":           "OPENMATCH_DIAL_TIMEOUT",
+	"openmatch.keepalive_time":         "OPENMATCH_KEEPALIVE_TIME",
+	"openmatch.keepalive_timeout":      "OPENMATCH_KEEPALIVE_TIMEOUT",
+	"jointoken.key":                    "JOIN_TOKEN_KEY",
+	"jointoken.private_key":            "JOIN_TOKEN_PRIVATE_KEY",
+	"jointoken.ttl":                    "JOIN_TOKEN_TTL",
+}
+
+// secrets are the configuration keys that can be read from files
+var secrets = []string{
+	"services.redis_password",
+	"auth.jwt_key",
+	"auth.api_access_key",
+	"auth.oauth_state_key",
+	"identity.google.client_secret",
+	"jointoken.key",
+}
+
+// NewConfig initializes the configuration with default values, binds environment variables, reads the config file
+// if there is one, and validates the result
+func NewConfig() (Config, error) {
+	v := viper.New()
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName("frontend")
+		v.SetConfigType("yml")
+		v.AddConfigPath(".")
+	}
+
+	setDefaults(v)
+
+	for key, env := range envs {
+		if err := bindEnv(v, key, env); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, key := range secrets {
+		if err := bindEnv(v, key+"_file", envs[key]+"_FILE"); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return Config{}, fmt.Errorf("could not read config: %w", err)
+		}
+	}
+
+	// OpenID Connect providers are configured by environment variables prefixed by their name
+	secrets := append([]string{}, secrets...)
+	for _, name := range splitList(v.GetStringSlice("identity.providers")) {
+		if name == identity.Google || name == identity.Local {
+			continue
+		}
+		key := "identity.oidc." + strings.ToLower(name) + "."
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		for _, field := range []string{"issuer", "client_id", "client_secret", "scopes"} {
+			if err := bindEnv(v, key+field, prefix+strings.ToUpper(field)); err != nil {
+				return Config{}, err
+			}
+		}
+		if err := bindEnv(v, key+"client_secret_file", prefix+"CLIENT_SECRET_FILE"); err != nil {
+			return Config{}, err
+		}
+		secrets = append(secrets, key+"client_secret")
+	}
+
+	if err := readSecrets(v, secrets); err != nil {
+		return Config{}, err
+	}
+
+	var c Config
+	if err := v.Unmarshal(&c); err != nil {
+		return Config{}, fmt.Errorf("unable to decode into struct, %v", err)
+	}
+	c.normalize()
+	// Parties fill a match unless limited further
+	if !v.IsSet("matchmaking.max_party_size") {
+		c.Matchmaking.Max_party_size = c.Matchmaking.Players_per_match
+	}
+
+	return c, c.Validate()
+}
+
+// setDefaults sets the defaults of everything that isn't required
+func setDefaults(v *viper.Viper) {
+	// Server defaults
+	v.SetDefault("server.listen_port", 8080)
+	v.SetDefault("server.metrics_port", 9090)
+	v.SetDefault("server.client_launcher_port", 8082)
+	// Kubernetes kills pods 30 seconds after asking them to stop by default
+	v.SetDefault("server.drain_delay", 5*time.Second)
+	v.SetDefault("server.shutdown_timeout", 20*time.Second)
+	v.SetDefault("server.replicas", 1)
+
+	// Services in the cluster
+	profileDefaults := profile.DefaultConfig()
+	v.SetDefault("services.profile", profileDefaults.BaseURL)
+	v.SetDefault("services.profile_timeout", profileDefaults.Timeout)
+	v.SetDefault("services.profile_retries", profileDefaults.Retries)
+	v.SetDefault("services.ping", "http://ping-discovery")
+
+	// Tokens and sessions
+	v.SetDefault("auth.access_token_ttl", auth.DefaultAccessTokenTTL)
+	v.SetDefault("auth.session_ttl", auth.DefaultSessionTTL)
+	v.SetDefault("auth.guest_session_ttl", auth.DefaultGuestSessionTTL)
+	v.SetDefault("auth.device_code_ttl", device.DefaultTTL)
+	v.SetDefault("auth.guest_rate_limit", 10)
+
+	v.SetDefault("identity.providers", []string{identity.Google})
+
+	// Matchmaking defaults. Matches are as large as those of the match function by default, and parties as large as
+	// matches
+	v.SetDefault("matchmaking.matchmaker", match.OpenMatchMatchmaker)
+	v.SetDefault("matchmaking.active_ticket_policy", match.ReuseActiveTicket)
+	v.SetDefault("matchmaking.fallback_skill", match.DefaultFallbackSkill)
+	v.SetDefault("matchmaking.players_per_match", 3)
+	v.SetDefault("matchmaking.local_servers", []string{"127.0.0.1:7777"})
+
+	openMatchDefaults := match.DefaultConfig()
+	v.SetDefault("openmatch.frontend_endpoint", openMatchDefaults.Endpoint)
+	v.SetDefault("openmatch.dial_timeout", openMatchDefaults.DialTimeout)
+	v.SetDefault("openmatch.keepalive_time", openMatchDefaults.KeepaliveTime)
+	v.SetDefault("openmatch.keepalive_timeout", openMatchDefaults.KeepaliveTimeout)
+
+	v.SetDefault("jointoken.ttl", jointoken.DefaultTTL)
+}
+
+func bindEnv(v *viper.Viper, key, env string) error {
+	if err := v.BindEnv(key, env); err != nil {
+		return fmt.Errorf("could not set environment variable '%s': %s", key, err)
+	}
+	return nil
+}
+
+// readSecrets reads the secrets whose file is set, which take precedence over the secrets themselves
+func readSecrets(v *viper.Viper, secrets []string) error {
+	for _, key := range secrets {
+		file := v.GetString(key + "_file")
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read secret '%s': %w", key, err)
+		}
+		v.Set(key, strings.TrimRight(string(data), "\r\n"))
+	}
+	return nil
+}
+
+// normalize cleans up lists, which can be comma or space separated in environment variables
+func (c *Config) normalize() {
+	c.Auth.Jwt_retired_kids = splitList(c.Auth.Jwt_retired_kids)
+	c.Identity.Providers = splitList(c.Identity.Providers)
+	c.Matchmaking.Local_servers = splitList(c.Matchmaking.Local_servers)
+	for name, p := range c.Identity.Oidc {
+		p.Scopes = splitList(p.Scopes)
+		c.Identity.Oidc[name] = p
+	}
+}
+
+func splitList(list []string) []string {
+	var out []string
+	for _, s := range list {
+		out = append(out, strings.Fields(strings.ReplaceAll(s, ",", " "))...)
+	}
+	return out
+}
+
+// ValidationError lists all the problems of a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate returns a ValidationError listing all the problems of the configuration, if there are any
+func (c *Config) Validate() error {
+	var problems []string
+	problemf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	checkPort := func(env string, port int) {
+		if port < 1 || port > 65535 {
+			problemf("%s must be a port, got %d", env, port)
+		}
+	}
+	checkURL := func(env, u string) {
+		if !isURL(u) {
+			problemf("%s must be an http(s) URL, got %q", env, u)
+		}
+	}
+	checkPositive := func(env string, d time.Duration) {
+		if d <= 0 {
+			problemf("%s must be positive, got %s", env, d)
+		}
+	}
+	checkNotNegative := func(env string, d time.Duration) {
+		if d < 0 {
+			problemf("%s must not be negative, got %s", env, d)
+		}
+	}
+
+	// Server
+	checkPort("LISTEN_PORT", c.Server.Listen_port)
+	checkPort("METRICS_PORT", c.Server.Metrics_port)
+	if c.Server.Metrics_port == c.Server.Listen_port {
+		problemf("METRICS_PORT must differ from LISTEN_PORT, got %d for both", c.Server.Metrics_port)
+	}
+	checkPort("CLIENT_LAUNCHER_PORT", c.Server.Client_launcher_port)
+	if c.Server.Callback_hostname == "" {
+		problemf("CALLBACK_HOSTNAME is required")
+	} else {
+		checkURL("CALLBACK_HOSTNAME", c.Server.Callback_hostname)
+	}
+	checkNotNegative("DRAIN_DELAY", c.Server.Drain_delay)
+	checkPositive("SHUTDOWN_TIMEOUT", c.Server.Shutdown_timeout)
+	if c.Server.Replicas < 1 {
+		problemf("REPLICAS must be at least 1, got %d", c.Server.Replicas)
+	}
+
+	// Services
+	checkURL("PROFILE_SERVICE", c.Services.Profile)
+	checkPositive("PROFILE_SERVICE_TIMEOUT", c.Services.Profile_timeout)
+	if c.Services.Profile_retries < 0 {
+		problemf("PROFILE_SERVICE_RETRIES must not be negative, got %d", c.Services.Profile_retries)
+	}
+	checkURL("PING_SERVICE", c.Services.Ping)
+	// Sessions, tickets, parties and device codes kept per replica would only work on the replica that created them
+	if c.Server.Replicas > 1 && c.Services.Redis_addr == "" {
+		problemf("REDIS_ADDR is required with more than one replica, got REPLICAS=%d", c.Server.Replicas)
+	}
+
+	// Auth
+	if c.Auth.Jwt_key == "" && c.Auth.Jwt_keys_dir == "" {
+		problemf("JWT_KEY or JWT_KEYS_DIR is required")
+	}
+	if c.Auth.Api_access_key == "" {
+		problemf("API_ACCESS_KEY is required")
+	}
+	// Logins can finish on any replica, which needs the key of the replica that started them
+	if c.Server.Replicas > 1 && c.Auth.Oauth_state_key == "" {
+		problemf("OAUTH_STATE_KEY is required with more than one replica, got REPLICAS=%d", c.Server.Replicas)
+	}
+	checkPositive("ACCESS_TOKEN_TTL", c.Auth.Access_token_ttl)
+	checkPositive("SESSION_TTL", c.Auth.Session_ttl)
+	checkPositive("GUEST_SESSION_TTL", c.Auth.Guest_session_ttl)
+	checkPositive("DEVICE_CODE_TTL", c.Auth.Device_code_ttl)
+	if c.Auth.Guest_rate_limit < 1 {
+		problemf("GUEST_RATE_LIMIT must be at least 1, got %d", c.Auth.Guest_rate_limit)
+	}
+
+	// Identity providers
+	if len(c.Identity.Providers) == 0 {
+		problemf("IDENTITY_PROVIDERS must list at least one identity provider")
+	}
+	for _, name := range c.Identity.Providers {
+		switch name {
+		case identity.Google:
+			if c.Identity.Google.Client_id == "" || c.Identity.Google.Client_secret == "" {
+				problemf("CLIENT_ID and CLIENT_SECRET are required by the google identity provider")
+			}
+		case identity.Local:
+		default:
+			prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			p := c.Identity.Oidc[strings.ToLower(name)]
+			if p.Issuer == "" || p.Client_id == "" {
+				problemf("%sISSUER and %sCLIENT_ID are required by the %s identity provider", prefix, prefix, name)
+			}
+		}
+	}
+
+	// Matchmaking
+	switch c.Matchmaking.Matchmaker {
+	case match.OpenMatchMatchmaker:
+		if c.OpenMatch.Frontend_endpoint == "" {
+			problemf("OPENMATCH_FRONTEND_ENDPOINT is required by the %s matchmaker", match.OpenMatchMatchmaker)
+		}
+		checkPositive("OPENMATCH_DIAL_TIMEOUT", c.OpenMatch.Dial_timeout)
+		checkNotNegative("OPENMATCH_KEEPALIVE_TIME", c.OpenMatch.Keepalive_time)
+		checkNotNegative("OPENMATCH_KEEPALIVE_TIMEOUT", c.OpenMatch.Keepalive_timeout)
+	case match.LocalMatchmaker:
+		if len(c.Matchmaking.Local_servers) == 0 {
+			problemf("LOCAL_MATCHMAKER_SERVERS is required by the %s matchmaker", match.LocalMatchmaker)
+		}
+	default:
+		problemf("MATCHMAKER must be %q or %q, got %q", match.OpenMatchMatchmaker, match.LocalMatchmaker, c.Matchmaking.Matchmaker)
+	}
+	if p := c.Matchmaking.Active_ticket_policy; p != match.ReuseActiveTicket && p != match.ReplaceActiveTicket {
+		problemf("ACTIVE_TICKET_POLICY must be %q or %q, got %q", match.ReuseActiveTicket, match.ReplaceActiveTicket, p)
+	}
+	if c.Matchmaking.Players_per_match < 1 {
+		problemf("PLAYERS_PER_MATCH must be at least 1, got %d", c.Matchmaking.Players_per_match)
+	}
+	if c.Matchmaking.Max_party_size < 1 {
+		problemf("MAX_PARTY_SIZE must be at least 1, got %d", c.Matchmaking.Max_party_size)
+	} else if c.Matchmaking.Max_party_size > c.Matchmaking.Players_per_match && c.Matchmaking.Players_per_match >= 1 {
+		// No match could fit the party
+		problemf("MAX_PARTY_SIZE must not be larger than PLAYERS_PER_MATCH, got %d > %d", c.Matchmaking.Max_party_size, c.Matchmaking.Players_per_match)
+	}
+	if c.Matchmaking.Fallback_skill < 0 {
+		problemf("FALLBACK_SKILL must not be negative, got %d", c.Matchmaking.Fallback_skill)
+	}
+
+	// Join tokens
+	checkPositive("JOIN_TOKEN_TTL", c.JoinToken.Ttl)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Keys returns the configuration of the keys JWTs are signed and verified with
+func (c *AuthConfig) Keys() auth.KeyConfig {
+	return auth.KeyConfig{
+		Secret:      c.Jwt_key,
+		Dir:         c.Jwt_keys_dir,
+		SigningKid:  c.Jwt_signing_kid,
+		RetiredKids: c.Jwt_retired_kids,
+	}
+}
+
+// ProfileClient returns the configuration of the profile service client
+func (c *ServicesConfig) ProfileClient() profile.Config {
+	cfg := profile.DefaultConfig()
+	cfg.BaseURL = c.Profile
+	cfg.Timeout = c.Profile_timeout
+	cfg.Retries = c.Profile_retries
+	return cfg
+}
+
+// Redis returns the configuration of the connection to Redis
+func (c *ServicesConfig) Redis() redis.Config {
+	return redis.Config{
+		Addr:     c.Redis_addr,
+		Password: c.Redis_password,
+		TLS:      c.Redis_tls,
+		CACert:   c.Redis_ca_cert,
+	}
+}
+
+// Match returns the configuration of the connection to the Open Match frontend service
+func (c *OpenMatchConfig) Match() match.Config {
+	return match.Config{
+		Endpoint:         c.Frontend_endpoint,
+		TLS:              c.Tls,
+		CACert:           c.Ca_cert,
+		ClientCert:       c.Client_cert,
+		ClientKey:        c.Client_key,
+		ServerName:       c.Server_name,
+		DialTimeout:      c.Dial_timeout,
+		KeepaliveTime:    c.Keepalive_time,
+		KeepaliveTimeout: c.Keepalive_timeout,
+	}
+}
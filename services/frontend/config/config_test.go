@@ -0,0 +1,183 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setRequired sets the environment variables that have no default
+func setRequired(t *testing.T) {
+	t.Setenv("CALLBACK_HOSTNAME", "http://localhost:8081/callback")
+	t.Setenv("JWT_KEY", "jwt")
+	t.Setenv("API_ACCESS_KEY", "api")
+	t.Setenv("CLIENT_ID", "id")
+	t.Setenv("CLIENT_SECRET", "secret")
+}
+
+// writeFile writes a file in a temporary directory, returning its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func problems(err error) []string {
+	if ve, ok := err.(*ValidationError); ok {
+		return ve.Problems
+	}
+	return nil
+}
+
+func TestDefaults(t *testing.T) {
+	setRequired(t)
+
+	c, err := NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, c.Server.Listen_port)
+	assert.Equal(t, 9090, c.Server.Metrics_port)
+	assert.Equal(t, 1, c.Server.Replicas)
+	assert.Equal(t, "http://profile", c.Services.Profile)
+	assert.Equal(t, []string{"google"}, c.Identity.Providers)
+	assert.Equal(t, 10, c.Auth.Guest_rate_limit)
+	assert.Equal(t, 3, c.Matchmaking.Players_per_match)
+	assert.Equal(t, 3, c.Matchmaking.Max_party_size)
+	assert.Equal(t, "jwt", c.Auth.Jwt_key)
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	c := Config{}
+	c.Server.Listen_port = 70000
+
+	err := c.Validate()
+	assert.IsType(t, &ValidationError{}, err)
+	for _, problem := range []string{
+		"LISTEN_PORT must be a port, got 70000",
+		"METRICS_PORT must be a port, got 0",
+		"CALLBACK_HOSTNAME is required",
+		"REPLICAS must be at least 1, got 0",
+		`PROFILE_SERVICE must be an http(s) URL, got ""`,
+		"JWT_KEY or JWT_KEYS_DIR is required",
+		"API_ACCESS_KEY is required",
+		"IDENTITY_PROVIDERS must list at least one identity provider",
+		`MATCHMAKER must be "openmatch" or "local", got ""`,
+		"JOIN_TOKEN_TTL must be positive, got 0s",
+	} {
+		assert.Contains(t, problems(err), problem)
+	}
+	assert.Contains(t, err.Error(), "invalid configuration:\n  - LISTEN_PORT must be a port")
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	setRequired(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "frontend.yml", `
+server:
+  listen_port: 9000
+  drain_delay: 2s
+services:
+  profile: http://profile.example.com
+identity:
+  providers: [google, my-idp]
+  oidc:
+    my-idp:
+      issuer: https://idp.example.com
+      client_id: file-client
+`))
+	t.Setenv("LISTEN_PORT", "9100")
+	t.Setenv("MY_IDP_CLIENT_ID", "env-client")
+	t.Setenv("MY_IDP_SCOPES", "openid, email")
+
+	c, err := NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 9100, c.Server.Listen_port)
+	assert.Equal(t, 2*time.Second, c.Server.Drain_delay)
+	assert.Equal(t, "http://profile.example.com", c.Services.Profile)
+	assert.Equal(t, "https://idp.example.com", c.Identity.Oidc["my-idp"].Issuer)
+	assert.Equal(t, "env-client", c.Identity.Oidc["my-idp"].Client_id)
+	assert.Equal(t, []string{"openid", "email"}, c.Identity.Oidc["my-idp"].Scopes)
+}
+
+func TestSecretFiles(t *testing.T) {
+	setRequired(t)
+	t.Setenv("JWT_KEY_FILE", writeFile(t, "jwt_key", "from-file\n"))
+	t.Setenv("REDIS_PASSWORD_FILE", writeFile(t, "redis_password", ""))
+	t.Setenv("CONFIG_FILE", writeFile(t, "frontend.yml", `
+auth:
+  api_access_key_file: `+writeFile(t, "api_access_key", "api-from-file")+`
+`))
+
+	// Files take precedence over the secrets themselves
+	c, err := NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "from-file", c.Auth.Jwt_key)
+	assert.Equal(t, "api-from-file", c.Auth.Api_access_key)
+	assert.Equal(t, "", c.Services.Redis_password)
+
+	t.Setenv("JWT_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = NewConfig()
+	assert.ErrorContains(t, err, "could not read secret 'auth.jwt_key'")
+}
+
+func TestMetricsPort(t *testing.T) {
+	setRequired(t)
+	t.Setenv("METRICS_PORT", "8080")
+
+	// Metrics must not be served on the public port
+	_, err := NewConfig()
+	assert.Equal(t, []string{"METRICS_PORT must differ from LISTEN_PORT, got 8080 for both"}, problems(err))
+}
+
+func TestMaxPartySize(t *testing.T) {
+	setRequired(t)
+	t.Setenv("PLAYERS_PER_MATCH", "2")
+
+	// Parties are as large as matches, unless limited further
+	c, err := NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, c.Matchmaking.Max_party_size)
+	t.Setenv("MAX_PARTY_SIZE", "1")
+	c, err = NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, c.Matchmaking.Max_party_size)
+
+	// But never larger, as no match could fit them
+	t.Setenv("MAX_PARTY_SIZE", "3")
+	_, err = NewConfig()
+	assert.Equal(t, []string{"MAX_PARTY_SIZE must not be larger than PLAYERS_PER_MATCH, got 3 > 2"}, problems(err))
+}
+
+func TestReplicas(t *testing.T) {
+	setRequired(t)
+	t.Setenv("REPLICAS", "3")
+
+	// State shared by replicas needs Redis, and logins need the same key on all replicas
+	_, err := NewConfig()
+	assert.Equal(t, []string{
+		"REDIS_ADDR is required with more than one replica, got REPLICAS=3",
+		"OAUTH_STATE_KEY is required with more than one replica, got REPLICAS=3",
+	}, problems(err))
+
+	t.Setenv("REDIS_ADDR", "redis:6379")
+	t.Setenv("OAUTH_STATE_KEY", "state")
+	c, err := NewConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Server.Replicas)
+}
@@ -0,0 +1,301 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package device implements the OAuth 2.0 device authorization grant (RFC 8628) for the launcher: the launcher gets a
+// device code and a user code, the player enters the user code in a browser and signs in there, and the launcher
+// polls with the device code until the player signed in.
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long players have to enter the user code and sign in by default
+	DefaultTTL = 10 * time.Minute
+	// DefaultInterval is how often the launcher may poll by default
+	DefaultInterval = 5 * time.Second
+
+	// User codes only use consonants, so they can't spell words, and are easy to type (RFC 8628, section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// Polls this much sooner than the interval are tolerated, as requests take varying time to arrive
+	pollJitter = time.Second
+	// How much the interval grows each time the launcher is told to slow down (RFC 8628, section 3.5)
+	slowDownIncrement = 5 * time.Second
+	// How often Start retries when a user code is already taken
+	createAttempts = 5
+)
+
+// Errors of Poll, named after the error codes of RFC 8628, which is what clients get
+var (
+	// ErrAuthorizationPending is returned while the player hasn't signed in yet
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is returned when the launcher polls more often than the interval
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied is returned when the player declined to sign in
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrExpiredToken is returned when the device code expired, or was already redeemed
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrInvalidGrant is returned for malformed or wrong device codes
+	ErrInvalidGrant = errors.New("invalid_grant")
+)
+
+// ErrInvalidUserCode is returned when a user code is unknown, expired or already used
+var ErrInvalidUserCode = errors.New("invalid or expired code")
+
+// ErrInvalidConfirmation is returned by Confirm when the confirmation token doesn't match the player's sign in
+var ErrInvalidConfirmation = errors.New("invalid confirmation")
+
+// Status of a grant
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// Grant is a device authorization, keyed by its user code. The device code and confirmation token are kept hashed.
+type Grant struct {
+	UserCode       string `json:"userCode"`
+	DeviceCodeHash string `json:"deviceCodeHash"`
+	Status         string `json:"status"`
+	// PlayerId is the player that signed in, who has to confirm the device with the token of ConfirmHash before it
+	// is approved
+	PlayerId    string    `json:"playerId,omitempty"`
+	ConfirmHash string    `json:"confirmHash,omitempty"`
+	Expires     time.Time `json:"expires"`
+	LastPoll    time.Time `json:"lastPoll"`
+	// Interval is how often the launcher may poll, which grows each time it polls too often
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+// Authorization is what the launcher gets to start the flow with
+type Authorization struct {
+	DeviceCode string
+	// UserCode is formatted for display, e.g. "BCDF-GHJK"
+	UserCode  string
+	ExpiresIn time.Duration
+	Interval  time.Duration
+}
+
+// Flow runs device authorizations
+type Flow struct {
+	store    Store
+	ttl      time.Duration
+	interval time.Duration
+}
+
+// NewFlow returns a Flow keeping its grants in the store, which expire after ttl, and may be polled every interval
+func NewFlow(store Store, ttl, interval time.Duration) *Flow {
+	return &Flow{store: store, ttl: ttl, interval: interval}
+}
+
+// Start starts a device authorization
+func (f *Flow) Start(ctx context.Context) (*Authorization, error) {
+	for i := 0; i < createAttempts; i++ {
+		userCode, err := newUserCode()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+		// The user code is in the clear, so the grant can be found without storing the device code
+		deviceCode := userCode + "." + secret
+
+		now := time.Now()
+		g := &Grant{UserCode: userCode, DeviceCodeHash: hash(deviceCode), Status: StatusPending, Expires: now.Add(f.ttl), LastPoll: now, Interval: f.interval}
+		ok, err := f.store.Create(ctx, g)
+		if err != nil {
+			return nil, fmt.Errorf("can't store device authorization: %w", err)
+		}
+		if ok {
+			return &Authorization{DeviceCode: deviceCode, UserCode: FormatUserCode(userCode), ExpiresIn: f.ttl, Interval: f.interval}, nil
+		}
+	}
+	return nil, errors.New("can't find a free user code, try again")
+}
+
+// Check returns ErrInvalidUserCode unless the user code belongs to a pending authorization nobody signed in for yet
+func (f *Flow) Check(ctx context.Context, userCode string) error {
+	g, err := f.store.Get(ctx, NormalizeUserCode(userCode))
+	if err != nil {
+		return err
+	}
+	if g == nil || g.Status != StatusPending || g.PlayerId != "" {
+		return ErrInvalidUserCode
+	}
+	return nil
+}
+
+// SignIn records that the player signed in for the pending authorization of the user code. The device is only
+// approved once the player confirms it with Confirm and the returned token, so players who were tricked into
+// entering someone else's code get a chance to notice.
+func (f *Flow) SignIn(ctx context.Context, userCode, playerID string) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.store.Update(ctx, NormalizeUserCode(userCode), func(g *Grant) (*Grant, error) {
+		if g == nil || g.Status != StatusPending || g.PlayerId != "" {
+			return nil, ErrInvalidUserCode
+		}
+		g.PlayerId, g.ConfirmHash = playerID, hash(token)
+		return g, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Confirm approves or denies the authorization of the user code, with the confirmation token of the player's sign in
+func (f *Flow) Confirm(ctx context.Context, userCode, token string, approve bool) error {
+	_, err := f.store.Update(ctx, NormalizeUserCode(userCode), func(g *Grant) (*Grant, error) {
+		if g == nil || g.Status != StatusPending || g.PlayerId == "" {
+			return nil, ErrInvalidUserCode
+		}
+		if subtle.ConstantTimeCompare([]byte(hash(token)), []byte(g.ConfirmHash)) != 1 {
+			return nil, ErrInvalidConfirmation
+		}
+		g.Status, g.ConfirmHash = StatusDenied, ""
+		if approve {
+			g.Status = StatusApproved
+		}
+		return g, nil
+	})
+	return err
+}
+
+// Deny denies the pending authorization of the user code, e.g. when the player declined to sign in
+func (f *Flow) Deny(ctx context.Context, userCode string) error {
+	_, err := f.store.Update(ctx, NormalizeUserCode(userCode), func(g *Grant) (*Grant, error) {
+		if g == nil || g.Status != StatusPending {
+			return nil, ErrInvalidUserCode
+		}
+		g.Status, g.PlayerId, g.ConfirmHash = StatusDenied, "", ""
+		return g, nil
+	})
+	return err
+}
+
+// Poll returns the id of the player that signed in for the device code. Approved device codes can be redeemed once.
+// Returns one of the errors named after those of RFC 8628 otherwise. Each ErrSlowDown raises the interval the device
+// code may be polled at by 5 seconds.
+func (f *Flow) Poll(ctx context.Context, deviceCode string) (string, error) {
+	userCode, _, ok := strings.Cut(deviceCode, ".")
+	if !ok || userCode == "" {
+		return "", ErrInvalidGrant
+	}
+
+	var result *Grant
+	slowDown := false
+	_, err := f.store.Update(ctx, userCode, func(g *Grant) (*Grant, error) {
+		if g == nil {
+			return nil, ErrExpiredToken
+		}
+		if subtle.ConstantTimeCompare([]byte(hash(deviceCode)), []byte(g.DeviceCodeHash)) != 1 {
+			return nil, ErrInvalidGrant
+		}
+
+		switch g.Status {
+		case StatusApproved:
+			// Redeemed, so delete it
+			result = g
+			return nil, nil
+		case StatusDenied:
+			return nil, ErrAccessDenied
+		}
+
+		// Grants stored before intervals were kept use the interval of the flow
+		if g.Interval == 0 {
+			g.Interval = f.interval
+		}
+		now := time.Now()
+		if now.Sub(g.LastPoll) < g.Interval-pollJitter {
+			slowDown = true
+			g.Interval += slowDownIncrement
+		}
+		g.LastPoll = now
+		result = g
+		return g, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if slowDown {
+		return "", ErrSlowDown
+	}
+
+	if result.Status == StatusApproved {
+		return result.PlayerId, nil
+	}
+	return "", ErrAuthorizationPending
+}
+
+// NormalizeUserCode returns the user code as stored: upper case, without separators
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// FormatUserCode returns the user code for display, e.g. "BCDF-GHJK"
+func FormatUserCode(userCode string) string {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("can't generate user code: %w", err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func hash(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestFlow returns a flow that can be polled right away
+func newTestFlow() (*Flow, *MemoryStore) {
+	store := NewMemoryStore()
+	return NewFlow(store, time.Minute, 0), store
+}
+
+func TestFlow(t *testing.T) {
+	f, _ := newTestFlow()
+	ctx := context.Background()
+
+	a, err := f.Start(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, a.UserCode, userCodeLength+1)
+	assert.Nil(t, f.Check(ctx, strings.ToLower(a.UserCode)))
+
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	// Signing in doesn't approve the device until the player confirms it
+	token, err := f.SignIn(ctx, a.UserCode, "p1")
+	assert.Nil(t, err)
+	assert.ErrorIs(t, f.Check(ctx, a.UserCode), ErrInvalidUserCode)
+	_, err = f.SignIn(ctx, a.UserCode, "p2")
+	assert.ErrorIs(t, err, ErrInvalidUserCode)
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	assert.ErrorIs(t, f.Confirm(ctx, a.UserCode, "wrong", true), ErrInvalidConfirmation)
+	assert.Nil(t, f.Confirm(ctx, a.UserCode, token, true))
+	assert.ErrorIs(t, f.Confirm(ctx, a.UserCode, token, true), ErrInvalidUserCode)
+
+	// Approved device codes can be redeemed once
+	playerID, err := f.Poll(ctx, a.DeviceCode)
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", playerID)
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
+
+func TestFlowDenied(t *testing.T) {
+	f, _ := newTestFlow()
+	ctx := context.Background()
+
+	a, err := f.Start(ctx)
+	assert.Nil(t, err)
+	token, err := f.SignIn(ctx, a.UserCode, "p1")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Confirm(ctx, a.UserCode, token, false))
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	// Players who decline to sign in deny the device too
+	a, err = f.Start(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Deny(ctx, a.UserCode))
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	_, err = f.SignIn(ctx, a.UserCode, "p1")
+	assert.ErrorIs(t, err, ErrInvalidUserCode)
+}
+
+func TestFlowSlowDown(t *testing.T) {
+	store := NewMemoryStore()
+	f := NewFlow(store, time.Minute, DefaultInterval)
+	ctx := context.Background()
+
+	a, err := f.Start(ctx)
+	assert.Nil(t, err)
+	userCode := NormalizeUserCode(a.UserCode)
+	assert.Equal(t, DefaultInterval, a.Interval)
+
+	// Each poll sooner than the interval raises it by 5 seconds
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+	assert.Equal(t, DefaultInterval+slowDownIncrement, store.grants[userCode].Interval)
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+	assert.Equal(t, DefaultInterval+2*slowDownIncrement, store.grants[userCode].Interval)
+
+	// Polling at the old interval is still too often
+	g := store.grants[userCode]
+	g.LastPoll = time.Now().Add(-DefaultInterval)
+	store.grants[userCode] = g
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+
+	g = store.grants[userCode]
+	g.LastPoll = time.Now().Add(-g.Interval)
+	store.grants[userCode] = g
+	_, err = f.Poll(ctx, a.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+	assert.Equal(t, DefaultInterval+3*slowDownIncrement, store.grants[userCode].Interval)
+}
+
+func TestFlowInvalidDeviceCode(t *testing.T) {
+	f, _ := newTestFlow()
+	ctx := context.Background()
+
+	a, err := f.Start(ctx)
+	assert.Nil(t, err)
+	userCode, _, _ := strings.Cut(a.DeviceCode, ".")
+
+	for _, deviceCode := range []string{"", "malformed", ".secret", userCode + ".wrong"} {
+		_, err = f.Poll(ctx, deviceCode)
+		assert.ErrorIs(t, err, ErrInvalidGrant, deviceCode)
+	}
+	_, err = f.Poll(ctx, "BCDFGHJK.secret")
+	assert.ErrorIs(t, err, ErrExpiredToken)
+	assert.ErrorIs(t, f.Check(ctx, "BCDF-GHJK"), ErrInvalidUserCode)
+}
+
+// postForm returns a request posting the form, with the CSRF cookie if set
+func postForm(cookie *http.Cookie, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	w := httptest.NewRecorder()
+	token, err := SetCSRFCookie(w, true)
+	assert.Nil(t, err)
+	cookies := w.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	cookie := cookies[0]
+	assert.Equal(t, token, cookie.Value)
+	assert.Equal(t, "/device", cookie.Path)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+
+	assert.True(t, CheckCSRF(postForm(cookie, url.Values{csrfField: {token}})))
+	assert.False(t, CheckCSRF(postForm(cookie, url.Values{csrfField: {"other"}})))
+	assert.False(t, CheckCSRF(postForm(cookie, url.Values{})))
+	assert.False(t, CheckCSRF(postForm(nil, url.Values{csrfField: {token}})))
+	assert.False(t, CheckCSRF(postForm(&http.Cookie{Name: csrfCookie}, url.Values{csrfField: {""}})))
+}
+
+func TestPages(t *testing.T) {
+	var entry bytes.Buffer
+	assert.Nil(t, WriteEntryPage(&entry, "/device", "csrf", "bcdfghjk", []string{"google"}, "<bad code>"))
+	assert.Contains(t, entry.String(), `name="csrf_token" value="csrf"`)
+	assert.Contains(t, entry.String(), `value="BCDF-GHJK"`)
+	assert.Contains(t, entry.String(), "&lt;bad code&gt;")
+
+	var confirm bytes.Buffer
+	assert.Nil(t, WriteConfirmPage(&confirm, "/device/confirm", "csrf", "BCDFGHJK", "token"))
+	assert.Contains(t, confirm.String(), "Approve device BCDF-GHJK")
+	assert.Contains(t, confirm.String(), `name="csrf_token" value="csrf"`)
+	assert.Contains(t, confirm.String(), `name="confirm" value="token"`)
+}
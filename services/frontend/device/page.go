@@ -0,0 +1,125 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+const (
+	// Cookie of the double submit CSRF token of the device pages, whose forms post it back as csrfField
+	csrfCookie = "device_csrf"
+	csrfField  = "csrf_token"
+	// How long players have to post the forms of the device pages
+	csrfTTL = 30 * 60
+)
+
+var entryPage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in to Droidshooter</title></head>
+<body>
+	<h2>Sign in to Droidshooter</h2>
+	<p>Enter the code shown by your launcher.</p>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	<form method="post" action="{{.Action}}">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<label>Code <input type="text" name="user_code" value="{{.UserCode}}" required autofocus autocomplete="off"></label>
+		{{if eq (len .Providers) 1}}
+		<input type="hidden" name="provider" value="{{index .Providers 0}}">
+		{{else}}
+		<label>Sign in with
+			<select name="provider">
+				{{range .Providers}}<option value="{{.}}">{{.}}</option>{{end}}
+			</select>
+		</label>
+		{{end}}
+		<button type="submit">Continue</button>
+	</form>
+</body>
+</html>
+`))
+
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in to Droidshooter</title></head>
+<body>
+	<h2>Approve device {{.UserCode}}?</h2>
+	<p>Only approve if your own launcher shows the code <strong>{{.UserCode}}</strong>. Approving signs it in as you.</p>
+	<form method="post" action="{{.Action}}">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<input type="hidden" name="user_code" value="{{.UserCode}}">
+		<input type="hidden" name="confirm" value="{{.Confirm}}">
+		<button type="submit" name="decision" value="approve">Approve device {{.UserCode}}</button>
+		<button type="submit" name="decision" value="deny">Deny</button>
+	</form>
+</body>
+</html>
+`))
+
+// WriteEntryPage writes the page players enter the user code on, and pick the identity provider to sign in with. The
+// form is posted to action, with the CSRF token.
+func WriteEntryPage(w io.Writer, action, csrfToken, userCode string, providers []string, errMsg string) error {
+	return entryPage.Execute(w, map[string]interface{}{
+		"Action":    action,
+		"CSRFToken": csrfToken,
+		"UserCode":  FormatUserCode(userCode),
+		"Providers": providers,
+		"Error":     errMsg,
+	})
+}
+
+// WriteConfirmPage writes the page signed in players approve or deny the device of the user code on. The form is
+// posted to action, with the CSRF token and the confirmation token of Flow.SignIn.
+func WriteConfirmPage(w io.Writer, action, csrfToken, userCode, confirmToken string) error {
+	return confirmPage.Execute(w, map[string]interface{}{
+		"Action":    action,
+		"CSRFToken": csrfToken,
+		"UserCode":  FormatUserCode(userCode),
+		"Confirm":   confirmToken,
+	})
+}
+
+// SetCSRFCookie sets a new CSRF token in a cookie of the device pages, and returns it, for their forms to post back.
+// The cookie is only sent over HTTPS if secure is true.
+func SetCSRFCookie(w http.ResponseWriter, secure bool) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	// Strict, so the cookie is only sent with forms posted from our own pages
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/device",
+		MaxAge:   csrfTTL,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// CheckCSRF reports whether the CSRF token posted with the form matches the cookie. Forms posted by other sites can't
+// know the token, as they can't read the cookie.
+func CheckCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue(csrfField))) == 1
+}
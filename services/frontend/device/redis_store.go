@@ -0,0 +1,117 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Prefix of the keys holding each grant, by user code.
+	redisGrantPrefix = "frontend:device:"
+	// How often Update retries when the grant is changed concurrently.
+	redisUpdateAttempts = 5
+)
+
+// Replaces KEYS[1] with ARGV[2] if it still holds ARGV[1], keeping its expiry, or deletes it if ARGV[2] is empty.
+// Returns 1 on success.
+var compareAndSwapScript = redis.NewScript(`if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then return 0 end
+if ARGV[2] == '' then redis.call('DEL', KEYS[1]) else redis.call('SET', KEYS[1], ARGV[2], 'PX', redis.call('PTTL', KEYS[1])) end
+return 1`)
+
+var errConcurrentUpdates = errors.New("device authorization is updated too often, try again")
+
+// RedisStore is a Store shared by all frontend replicas, so the browser and the launcher can be served by any replica.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisStore returns a RedisStore that keeps grants in Redis.
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Create implements Store.
+func (s *RedisStore) Create(ctx context.Context, g *Grant) (bool, error) {
+	v, err := json.Marshal(g)
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.client.SetNX(ctx, redisGrantPrefix+g.UserCode, v, time.Until(g.Expires)).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SET failed: %w", err)
+	}
+	return ok, nil
+}
+
+// Get implements Store.
+func (s *RedisStore) Get(ctx context.Context, userCode string) (*Grant, error) {
+	v, err := s.get(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGrant(v)
+}
+
+// Update implements Store.
+func (s *RedisStore) Update(ctx context.Context, userCode string, fn func(g *Grant) (*Grant, error)) (*Grant, error) {
+	for i := 0; i < redisUpdateAttempts; i++ {
+		v, err := s.get(ctx, userCode)
+		if err != nil {
+			return nil, err
+		}
+		old, err := decodeGrant(v)
+		if err != nil {
+			return nil, err
+		}
+		g, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+
+		var nv []byte
+		if g != nil {
+			if nv, err = json.Marshal(g); err != nil {
+				return nil, err
+			}
+		}
+		ok, err := compareAndSwapScript.Run(ctx, s.client, []string{redisGrantPrefix + userCode}, v, string(nv)).Int()
+		if err != nil {
+			return nil, fmt.Errorf("redis device authorization update failed: %w", err)
+		}
+		if ok == 1 {
+			return g, nil
+		}
+	}
+	return nil, errConcurrentUpdates
+}
+
+// get returns the encoded grant, or "" if there is none.
+func (s *RedisStore) get(ctx context.Context, userCode string) (string, error) {
+	v, err := s.client.Get(ctx, redisGrantPrefix+userCode).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GET failed: %w", err)
+	}
+	return v, nil
+}
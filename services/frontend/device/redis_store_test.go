@@ -0,0 +1,58 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rc.Close()
+	s := NewRedisStore(rc)
+	ctx := context.Background()
+
+	g := &Grant{UserCode: "BCDFGHJK", DeviceCodeHash: "h", Status: StatusPending, Expires: time.Now().Add(time.Minute)}
+	ok, err := s.Create(ctx, g)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// User codes are unique
+	ok, err = s.Create(ctx, g)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	g, err = s.Update(ctx, "BCDFGHJK", func(g *Grant) (*Grant, error) {
+		g.Status, g.PlayerId = StatusApproved, "p1"
+		return g, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusApproved, g.Status)
+	g, err = s.Get(ctx, "BCDFGHJK")
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", g.PlayerId)
+
+	_, err = s.Update(ctx, "BCDFGHJK", func(g *Grant) (*Grant, error) { return nil, nil })
+	assert.Nil(t, err)
+	g, err = s.Get(ctx, "BCDFGHJK")
+	assert.Nil(t, err)
+	assert.Nil(t, g)
+}
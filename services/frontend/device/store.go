@@ -0,0 +1,112 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Store keeps the grants of device authorizations until they expire.
+type Store interface {
+	// Create stores a new grant until it expires. Returns false if there already is a grant with its user code.
+	Create(ctx context.Context, g *Grant) (bool, error)
+	// Get returns the grant of the user code, or nil if it expired or was redeemed.
+	Get(ctx context.Context, userCode string) (*Grant, error)
+	// Update atomically replaces the grant with the result of fn, which is given a copy of the grant, or nil if it
+	// doesn't exist. A nil result deletes the grant. Errors of fn are returned as is, and leave the grant unchanged.
+	Update(ctx context.Context, userCode string, fn func(g *Grant) (*Grant, error)) (*Grant, error)
+}
+
+// MemoryStore is a Store for a single frontend replica.
+type MemoryStore struct {
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{grants: map[string]Grant{}}
+}
+
+// Create implements Store.
+func (s *MemoryStore) Create(_ context.Context, g *Grant) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forgetExpired()
+	if _, ok := s.grants[g.UserCode]; ok {
+		return false, nil
+	}
+	s.grants[g.UserCode] = *g
+	return true, nil
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(_ context.Context, userCode string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.grants[userCode]
+	if !ok || time.Now().After(g.Expires) {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(_ context.Context, userCode string, fn func(g *Grant) (*Grant, error)) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var old *Grant
+	if g, ok := s.grants[userCode]; ok && !time.Now().After(g.Expires) {
+		old = &g
+	}
+	g, err := fn(old)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		delete(s.grants, userCode)
+		return nil, nil
+	}
+	s.grants[userCode] = *g
+	return g, nil
+}
+
+// forgetExpired forgets expired grants. s.mu must be held.
+func (s *MemoryStore) forgetExpired() {
+	now := time.Now()
+	for code, g := range s.grants {
+		if now.After(g.Expires) {
+			delete(s.grants, code)
+		}
+	}
+}
+
+func decodeGrant(v string) (*Grant, error) {
+	if v == "" {
+		return nil, nil
+	}
+	g := &Grant{}
+	if err := json.Unmarshal([]byte(v), g); err != nil {
+		return nil, fmt.Errorf("can't decode device grant: %w", err)
+	}
+	return g, nil
+}
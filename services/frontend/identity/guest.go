@@ -0,0 +1,37 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Guest is the provider name of guest identities. Guests aren't signed in by a provider, but by the id of their device.
+const Guest = "guest"
+
+// NewGuest returns the guest identity of the device. The device id is a secret generated by the client, which is never
+// stored, so its guest identity is a hash of it.
+func NewGuest(deviceID string) *Identity {
+	sum := sha256.Sum256([]byte(deviceID))
+	subject := hex.EncodeToString(sum[:16])
+	return &Identity{Provider: Guest, Subject: subject, Name: "Guest-" + strings.ToUpper(subject[:6])}
+}
+
+// IsGuest reports whether the namespaced identity id, as returned by Identity.ID, is a guest identity
+func IsGuest(id string) bool {
+	return strings.HasPrefix(id, Guest+":")
+}
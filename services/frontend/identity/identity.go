@@ -0,0 +1,100 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package identity signs players in with identity providers: Google, any OpenID Connect provider, and a local
+// provider for offline development. Identities are namespaced by their provider, so one player can link several.
+// Guests are identified by their device instead.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownProvider is returned by Providers.Get for providers that are not configured
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// Identity is a player's identity with an identity provider
+type Identity struct {
+	Provider string
+	Subject  string
+	Name     string
+	Picture  string
+	Email    string
+	Locale   string
+}
+
+// ID returns the identity namespaced by its provider, e.g. "google:1234", which is unique across providers
+func (i *Identity) ID() string {
+	return i.Provider + ":" + i.Subject
+}
+
+// Provider is an identity provider players sign in with, using the OAuth 2.0 authorization code flow with PKCE
+type Provider interface {
+	// Name of the provider, which namespaces the identities of its players
+	Name() string
+	// AuthCodeURL returns the URL to redirect players to, to sign in with the provider
+	AuthCodeURL(state, challenge string) string
+	// Identify redeems the authorization code of the callback, returning the identity of the player that signed in
+	Identify(ctx context.Context, code, verifier string) (*Identity, error)
+}
+
+// Providers are the configured identity providers. The first one is the default.
+type Providers struct {
+	providers []Provider
+}
+
+// NewProviders returns the providers, the first being the default. Provider names must be unique.
+func NewProviders(providers ...Provider) (*Providers, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("no identity providers")
+	}
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if strings.ContainsAny(p.Name(), ":/") || p.Name() == "" || p.Name() == Guest {
+			return nil, fmt.Errorf("invalid identity provider name %q", p.Name())
+		}
+		if seen[p.Name()] {
+			return nil, fmt.Errorf("identity provider %q configured twice", p.Name())
+		}
+		seen[p.Name()] = true
+	}
+	return &Providers{providers: providers}, nil
+}
+
+// Default returns the default provider
+func (ps *Providers) Default() Provider {
+	return ps.providers[0]
+}
+
+// Get returns the provider with the name, or ErrUnknownProvider
+func (ps *Providers) Get(name string) (Provider, error) {
+	for _, p := range ps.providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+}
+
+// Names returns the names of the providers, the default first
+func (ps *Providers) Names() []string {
+	names := make([]string, 0, len(ps.providers))
+	for _, p := range ps.providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
+)
+
+const (
+	// Local is the name of the local provider
+	Local = "local"
+	// LocalLoginPath is where the local provider serves its sign in form
+	LocalLoginPath = "/dev/login"
+	// How long codes of the local provider can be redeemed
+	localCodeTTL = time.Minute
+)
+
+// ErrInvalidCode is returned by LocalProvider.Identify for unknown, expired or already redeemed codes, and codes
+// redeemed with the wrong PKCE code verifier
+var ErrInvalidCode = errors.New("invalid authorization code")
+
+var localLoginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Droidshooter development sign in</title></head>
+<body>
+	<h2>Development sign in</h2>
+	<p>Signs in without an identity provider. Only for local development.</p>
+	<form method="post" action="{{.Action}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="code_challenge" value="{{.Challenge}}">
+		<label>Player name <input type="text" name="name" required autofocus></label>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>
+`))
+
+// localCode is an authorization code issued by the local provider
+type localCode struct {
+	name      string
+	challenge string
+	expires   time.Time
+}
+
+// LocalProvider signs players in with just a player name, for offline development. The name is the subject, so
+// signing in with the same name again is the same player. Codes are kept in memory, so logins must finish on the
+// replica that started them.
+type LocalProvider struct {
+	redirectURL string
+
+	mu    sync.Mutex
+	codes map[string]localCode
+}
+
+// NewLocal returns the local provider, redirecting players to redirectURL once they signed in
+func NewLocal(redirectURL string) *LocalProvider {
+	return &LocalProvider{redirectURL: redirectURL, codes: map[string]localCode{}}
+}
+
+// Name returns the name of the provider
+func (p *LocalProvider) Name() string {
+	return Local
+}
+
+// AuthCodeURL returns the URL of the sign in form
+func (p *LocalProvider) AuthCodeURL(state, challenge string) string {
+	return LocalLoginPath + "?" + url.Values{"state": {state}, "code_challenge": {challenge}}.Encode()
+}
+
+// Identify redeems a code issued by the sign in form. Each code can be redeemed once.
+func (p *LocalProvider) Identify(ctx context.Context, code, verifier string) (*Identity, error) {
+	p.mu.Lock()
+	lc, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(lc.expires) {
+		return nil, ErrInvalidCode
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(lc.challenge)) != 1 {
+		return nil, ErrInvalidCode
+	}
+
+	return &Identity{Provider: Local, Subject: strings.ToLower(lc.name), Name: lc.name}, nil
+}
+
+// HandleForm serves the sign in form
+func (p *LocalProvider) HandleForm(c *gin.Context) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := localLoginForm.Execute(c.Writer, gin.H{
+		"Action":    LocalLoginPath,
+		"State":     c.Query("state"),
+		"Challenge": c.Query("code_challenge"),
+	}); err != nil {
+		c.Error(err)
+	}
+}
+
+// HandleSubmit issues a code for the player name of the sign in form, and redirects to the callback with it
+func (p *LocalProvider) HandleSubmit(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	challenge := c.PostForm("code_challenge")
+	if name == "" || challenge == "" {
+		shared.HandleError(c, http.StatusBadRequest, "local login", errors.New("name and code challenge are required"))
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); shared.HandleError(c, http.StatusInternalServerError, "local login", err) {
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	p.mu.Lock()
+	now := time.Now()
+	for k, lc := range p.codes {
+		if now.After(lc.expires) {
+			delete(p.codes, k)
+		}
+	}
+	p.codes[code] = localCode{name: name, challenge: challenge, expires: now.Add(localCodeTTL)}
+	p.mu.Unlock()
+
+	query := url.Values{"code": {code}, "state": {c.PostForm("state")}}
+	c.Redirect(http.StatusSeeOther, p.redirectURL+"?"+query.Encode())
+}
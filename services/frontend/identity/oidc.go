@@ -0,0 +1,172 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// Google is the name of the Google provider, whose subjects were the player ids before there were providers
+	Google = "google"
+	// Google's OpenID Connect userinfo endpoint
+	googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+	// How long to wait for discovery documents and userinfo
+	requestTimeout = 10 * time.Second
+)
+
+// DefaultScopes are the scopes requested from OpenID Connect providers, unless configured otherwise
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// OIDC is an OpenID Connect identity provider. Players are identified by the userinfo of their access token.
+type OIDC struct {
+	name        string
+	config      *oauth2.Config
+	userInfoURL string
+}
+
+// NewOIDC returns an OpenID Connect provider with the OAuth 2.0 config and userinfo endpoint
+func NewOIDC(name string, config *oauth2.Config, userInfoURL string) *OIDC {
+	return &OIDC{name: name, config: config, userInfoURL: userInfoURL}
+}
+
+// NewGoogle returns the Google provider
+func NewGoogle(clientID, clientSecret, redirectURL string) *OIDC {
+	return NewOIDC(Google, &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       DefaultScopes,
+		Endpoint:     google.Endpoint,
+	}, googleUserInfoURL)
+}
+
+// discoveryDocument is the part of the OpenID Connect discovery document we need
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Discover returns the OpenID Connect provider of the issuer, configured from its discovery document
+func Discover(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string, scopes []string) (*OIDC, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch discovery document of %s: %w", issuer, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch discovery document of %s, error code: %d", issuer, response.StatusCode)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(response.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid discovery document of %s: %w", issuer, err)
+	}
+
+	// Per OpenID Connect Discovery, the issuer must be the one the document was fetched for
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document of %s is for issuer %q", issuer, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserInfoEndpoint == "" {
+		return nil, fmt.Errorf("discovery document of %s lacks authorization, token or userinfo endpoint", issuer)
+	}
+
+	return NewOIDC(name, &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}, doc.UserInfoEndpoint), nil
+}
+
+// Name returns the name of the provider
+func (p *OIDC) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the URL of the provider's consent page, with the S256 PKCE code challenge
+func (p *OIDC) AuthCodeURL(state, challenge string) string {
+	return p.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// Identify exchanges the code for an access token, and fetches the userinfo of the player with it
+func (p *OIDC) Identify(ctx context.Context, code, verifier string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	token, err := p.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("can't exchange code: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.config.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s user profile, error code: %d", p.name, response.StatusCode)
+	}
+
+	var userInfo models.UserInfo
+	if err := json.NewDecoder(response.Body).Decode(&userInfo); err != nil {
+		return nil, err
+	}
+	if userInfo.Sub == "" {
+		return nil, errors.New("userinfo has no subject")
+	}
+
+	return &Identity{
+		Provider: p.name,
+		Subject:  userInfo.Sub,
+		Name:     userInfo.Name,
+		Picture:  userInfo.Picture,
+		Email:    userInfo.Email,
+		Locale:   userInfo.Locale,
+	}, nil
+}
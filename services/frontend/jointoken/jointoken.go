@@ -0,0 +1,190 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jointoken mints and verifies join tokens: short-lived signed tokens that admit a player to the game server
+// of their match. The frontend mints them once a ticket is assigned, and game servers, or a sidecar in front of
+// them, verify them offline with the shared key or the frontend's public key. The package only depends on the
+// standard library and golang-jwt, so it can be imported without the rest of the frontend.
+package jointoken
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// Issuer and Audience of join tokens, so they can't be confused with other tokens signed with the same key.
+	Issuer   = "droidshooter-frontend"
+	Audience = "droidshooter-game-server"
+
+	// DefaultTTL is how long join tokens are valid for by default: enough to connect right after the assignment.
+	DefaultTTL = 5 * time.Minute
+
+	// Leeway for the clocks of the frontend and the game servers being out of sync.
+	clockSkew = 30 * time.Second
+)
+
+var (
+	// ErrInvalidToken is returned by Verify when a token is malformed, expired, or its signature doesn't match.
+	ErrInvalidToken = errors.New("invalid join token")
+	// ErrWrongServer is returned by Verify when a valid token is for another game server.
+	ErrWrongServer = errors.New("join token is for another server")
+)
+
+// Claims of a join token. The subject is the player id.
+type Claims struct {
+	MatchID string `json:"mid"`
+	Server  string `json:"srv"` // host:port of the game server
+	jwt.RegisteredClaims
+}
+
+// PlayerID returns the id of the player the token was minted for.
+func (c *Claims) PlayerID() string {
+	return c.Subject
+}
+
+// Signer mints join tokens.
+type Signer struct {
+	method jwt.SigningMethod
+	key    interface{}
+	ttl    time.Duration
+}
+
+// NewHMACSigner returns a Signer of HS256 tokens, verified with the same secret.
+func NewHMACSigner(secret []byte, ttl time.Duration) (*Signer, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("join token secret is empty")
+	}
+	return &Signer{method: jwt.SigningMethodHS256, key: secret, ttl: ttl}, nil
+}
+
+// NewECDSASigner returns a Signer of ES256 tokens, verified with the public key of the P-256 private key.
+func NewECDSASigner(key *ecdsa.PrivateKey, ttl time.Duration) (*Signer, error) {
+	if key.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("join token key must be on P-256, got %s", key.Curve.Params().Name)
+	}
+	return &Signer{method: jwt.SigningMethodES256, key: key, ttl: ttl}, nil
+}
+
+// NewECDSASignerFromFile returns an ES256 Signer with the PEM encoded private key in the file.
+func NewECDSASignerFromFile(path string, ttl time.Duration) (*Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read join token key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse join token key: %w", err)
+	}
+	return NewECDSASigner(key, ttl)
+}
+
+// Sign returns a join token admitting the player to the match on the server (host:port), and when it expires.
+func (s *Signer) Sign(playerID, matchID, server string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(s.ttl)
+	claims := &Claims{
+		MatchID: matchID,
+		Server:  server,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   playerID,
+			Audience:  jwt.ClaimStrings{Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("can't sign join token: %w", err)
+	}
+	return token, expires, nil
+}
+
+// Verifier validates join tokens offline.
+type Verifier struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// NewHMACVerifier returns a Verifier of HS256 tokens signed with the secret.
+func NewHMACVerifier(secret []byte) (*Verifier, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("join token secret is empty")
+	}
+	return &Verifier{method: jwt.SigningMethodHS256, key: secret}, nil
+}
+
+// NewECDSAVerifier returns a Verifier of ES256 tokens signed with the private key of the public key.
+func NewECDSAVerifier(key *ecdsa.PublicKey) *Verifier {
+	return &Verifier{method: jwt.SigningMethodES256, key: key}
+}
+
+// NewECDSAVerifierFromFile returns an ES256 Verifier with the PEM encoded public key in the file.
+func NewECDSAVerifierFromFile(path string) (*Verifier, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read join token public key: %w", err)
+	}
+	key, err := jwt.ParseECPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse join token public key: %w", err)
+	}
+	return NewECDSAVerifier(key), nil
+}
+
+// Verify checks the token's signature, expiry, issuer and audience, and returns its claims. If server is not
+// empty, the token must also be for that server (host:port), so tokens can't be replayed against other servers.
+func (v *Verifier) Verify(token, server string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{v.method.Alg()}))
+	tkn, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return v.key, nil
+	})
+	if err != nil {
+		// Accept tokens that only failed the time checks within the clock skew
+		var verr *jwt.ValidationError
+		if !errors.As(err, &verr) || verr.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorIssuedAt) != 0 || !v.withinSkew(claims) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	} else if !tkn.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuer(Issuer, true) || !claims.VerifyAudience(Audience, true) {
+		return nil, fmt.Errorf("%w: wrong issuer or audience", ErrInvalidToken)
+	}
+	if claims.Subject == "" || claims.MatchID == "" {
+		return nil, fmt.Errorf("%w: no player or match", ErrInvalidToken)
+	}
+	if server != "" && claims.Server != server {
+		return nil, ErrWrongServer
+	}
+	return claims, nil
+}
+
+// withinSkew reports whether the token's time claims are valid, allowing for clockSkew.
+func (v *Verifier) withinSkew(c *Claims) bool {
+	now := time.Now()
+	return c.VerifyExpiresAt(now.Add(-clockSkew), true) && c.VerifyIssuedAt(now.Add(clockSkew), false)
+}
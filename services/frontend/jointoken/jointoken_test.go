@@ -0,0 +1,203 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jointoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const server = "10.0.0.1:7777"
+
+var secret = []byte("join-token-secret")
+
+func newECDSAKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+// sign returns a token with the claims, signed with HS256 and the secret.
+func sign(t *testing.T, claims *Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	assert.Nil(t, err)
+	return token
+}
+
+func validClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		MatchID: "match-1",
+		Server:  server,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   "p1",
+			Audience:  jwt.ClaimStrings{Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	s, err := NewHMACSigner(secret, DefaultTTL)
+	assert.Nil(t, err)
+	v, err := NewHMACVerifier(secret)
+	assert.Nil(t, err)
+
+	token, expires, err := s.Sign("p1", "match-1", server)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultTTL), expires, time.Second)
+
+	claims, err := v.Verify(token, server)
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", claims.PlayerID())
+	assert.Equal(t, "match-1", claims.MatchID)
+	// Servers that don't know their address can skip the check
+	_, err = v.Verify(token, "")
+	assert.Nil(t, err)
+
+	_, err = v.Verify(token, "10.0.0.2:7777")
+	assert.ErrorIs(t, err, ErrWrongServer)
+
+	other, _ := NewHMACVerifier([]byte("other-secret"))
+	_, err = other.Verify(token, server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewHMACSigner(nil, DefaultTTL)
+	assert.NotNil(t, err)
+	_, err = NewHMACVerifier(nil)
+	assert.NotNil(t, err)
+}
+
+func TestECDSA(t *testing.T) {
+	key := newECDSAKey(t, elliptic.P256())
+	s, err := NewECDSASigner(key, DefaultTTL)
+	assert.Nil(t, err)
+	v := NewECDSAVerifier(&key.PublicKey)
+
+	token, _, err := s.Sign("p1", "match-1", server)
+	assert.Nil(t, err)
+	_, err = v.Verify(token, server)
+	assert.Nil(t, err)
+
+	other := NewECDSAVerifier(&newECDSAKey(t, elliptic.P256()).PublicKey)
+	_, err = other.Verify(token, server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewECDSASigner(newECDSAKey(t, elliptic.P384()), DefaultTTL)
+	assert.NotNil(t, err)
+}
+
+func TestECDSAFromFile(t *testing.T) {
+	key := newECDSAKey(t, elliptic.P256())
+	dir := t.TempDir()
+	priv, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	privPath, pubPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem")
+	assert.Nil(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: priv}), 0o600))
+	assert.Nil(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600))
+
+	s, err := NewECDSASignerFromFile(privPath, DefaultTTL)
+	assert.Nil(t, err)
+	v, err := NewECDSAVerifierFromFile(pubPath)
+	assert.Nil(t, err)
+	token, _, err := s.Sign("p1", "match-1", server)
+	assert.Nil(t, err)
+	_, err = v.Verify(token, server)
+	assert.Nil(t, err)
+
+	_, err = NewECDSASignerFromFile(filepath.Join(dir, "missing.pem"), DefaultTTL)
+	assert.NotNil(t, err)
+	_, err = NewECDSAVerifierFromFile(privPath)
+	assert.NotNil(t, err)
+}
+
+func TestWrongAlgorithm(t *testing.T) {
+	key := newECDSAKey(t, elliptic.P256())
+	v := NewECDSAVerifier(&key.PublicKey)
+
+	// An HS256 token can't pass for an ES256 one, whatever its key
+	_, err := v.Verify(sign(t, validClaims()), server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.Nil(t, err)
+	hv, _ := NewHMACVerifier(secret)
+	_, err = hv.Verify(none, server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestExpiry(t *testing.T) {
+	v, _ := NewHMACVerifier(secret)
+
+	// Expired tokens are accepted within the clock skew only
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-clockSkew / 2))
+	_, err := v.Verify(sign(t, claims), server)
+	assert.Nil(t, err)
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-2 * clockSkew))
+	_, err = v.Verify(sign(t, claims), server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// So are tokens issued in the future
+	claims = validClaims()
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(clockSkew / 2))
+	_, err = v.Verify(sign(t, claims), server)
+	assert.Nil(t, err)
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(2 * clockSkew))
+	_, err = v.Verify(sign(t, claims), server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	claims = validClaims()
+	claims.ExpiresAt = nil
+	_, err = v.Verify(sign(t, claims), server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	s, _ := NewHMACSigner(secret, -time.Minute)
+	token, _, _ := s.Sign("p1", "match-1", server)
+	_, err = v.Verify(token, server)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestClaims(t *testing.T) {
+	v, _ := NewHMACVerifier(secret)
+
+	for name, change := range map[string]func(c *Claims){
+		"issuer":   func(c *Claims) { c.Issuer = "someone-else" },
+		"audience": func(c *Claims) { c.Audience = jwt.ClaimStrings{"another-service"} },
+		"player":   func(c *Claims) { c.Subject = "" },
+		"match":    func(c *Claims) { c.MatchID = "" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			change(claims)
+			_, err := v.Verify(sign(t, claims), server)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
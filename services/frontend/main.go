@@ -15,215 +15,828 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/config"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/device"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/identity"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/jointoken"
 	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/match"
 	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/party"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/ping"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/ratelimit"
 	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
 	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared/auth"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared/health"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared/redis"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-var (
-	googleOauthConfig = &oauth2.Config{
-		Scopes:   []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
-		Endpoint: google.Endpoint,
-	}
-
-	oauthStateString = "r4nd0ms7r1ng"
-)
+// How long after their expiry the player tokens relayed by game servers are accepted. Game clients keep the token
+// they were launched with, so it may expire during a play session.
+const statsTokenGrace = 12 * time.Hour
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serves the frontend API until it is told to shut down. Returns an error if it couldn't be served, once everything
+// it opened is closed
+func run() error {
 	// Load local .env
 	godotenv.Load()
-	shared.ValidateEnvVars()
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+
+	// Shut down on SIGTERM, which Kubernetes sends before killing the pod, or on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	h := health.New()
+
+	// Identity providers players sign in with, all redirecting back to our callback
+	callbackURL := cfg.Server.Callback_hostname
+	providers, err := newIdentityProviders(cfg.Identity, callbackURL)
+	if err != nil {
+		return fmt.Errorf("could not initialize identity providers: %w", err)
+	}
+
+	// Each login is bound to the browser that started it. The key is shared by replicas if set
+	secureCookies := strings.HasPrefix(callbackURL, "https://")
+	oauthStates, err := auth.NewOAuthStates([]byte(cfg.Auth.Oauth_state_key), secureCookies)
+	if err != nil {
+		return fmt.Errorf("could not initialize oauth states: %w", err)
+	}
 
-	// Oauth config from env vars
-	googleOauthConfig.ClientID = os.Getenv("CLIENT_ID")
-	googleOauthConfig.ClientSecret = os.Getenv("CLIENT_SECRET")
-	googleOauthConfig.RedirectURL = os.Getenv("CALLBACK_HOSTNAME")
+	// Keys to sign and verify JWTs with
+	if err := auth.LoadKeys(cfg.Auth.Keys()); err != nil {
+		return fmt.Errorf("could not load JWT keys: %w", err)
+	}
 
 	r := gin.Default()
+	r.Use(shared.RequestID())
 
 	// TODO: Better configuration of trusted proxy
 	if err := r.SetTrustedProxies(nil); err != nil {
-		log.Fatalf("could not set trusted proxies: %s", err)
+		return fmt.Errorf("could not set trusted proxies: %w", err)
+	}
+
+	// Active tickets, parties, sessions and rate limits are shared by all replicas if Redis is configured, else kept
+	// per replica
+	var registry match.Registry = match.NewMemoryRegistry()
+	var partyStore party.Store = party.NewMemoryStore()
+	var sessionStore auth.SessionStore = auth.NewMemorySessionStore()
+	var deviceStore device.Store = device.NewMemoryStore()
+	var guestLimiter ratelimit.Limiter = ratelimit.NewMemoryLimiter(cfg.Auth.Guest_rate_limit, time.Minute)
+	if cfg.Services.Redis_addr != "" {
+		rc, err := redis.NewClient(cfg.Services.Redis())
+		if err != nil {
+			return fmt.Errorf("could not connect to Redis: %w", err)
+		}
+		defer rc.Close()
+		registry = match.NewRedisRegistry(rc)
+		partyStore = party.NewRedisStore(rc)
+		sessionStore = auth.NewRedisSessionStore(rc)
+		deviceStore = device.NewRedisStore(rc)
+		guestLimiter = ratelimit.NewRedisLimiter(rc, cfg.Auth.Guest_rate_limit, time.Minute)
+		h.AddCheck("redis", func(ctx context.Context) error {
+			return rc.Ping(ctx).Err()
+		})
+	}
+
+	// Signed in players get short-lived access tokens, and refresh tokens to renew them
+	sessions := auth.NewSessions(sessionStore, cfg.Auth.Access_token_ttl, cfg.Auth.Session_ttl, cfg.Auth.Guest_session_ttl)
+	auth.UseSessions(sessions)
+
+	// Launchers can sign in with a device code instead of a callback, the player signing in on the device page
+	devices := device.NewFlow(deviceStore, cfg.Auth.Device_code_ttl, device.DefaultInterval)
+	verificationURL, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("CALLBACK_HOSTNAME not a valid URL: %w", err)
 	}
+	verificationURL.Path, verificationURL.RawQuery = "/device", ""
+
+	parties := party.NewService(partyStore, cfg.Matchmaking.Max_party_size)
+
+	// Player profiles, stats and identities are kept by the profile service
+	profiles := profile.NewClient(cfg.Services.ProfileClient())
 
-	m, err := match.NewMatcher()
+	// Regions sent by clients are validated against those of the ping discovery service
+	pingServers := ping.NewServers(cfg.Services.Ping)
+
+	// Players have one active ticket at a time
+	mm, err := newMatchmaker(cfg)
+	if err != nil {
+		return fmt.Errorf("could not initialize matchmaker: %w", err)
+	}
+	m, err := match.NewMatcher(mm, registry, cfg.Matchmaking.Active_ticket_policy, cfg.Matchmaking.Fallback_skill)
 	if err != nil {
-		log.Fatalf("could not initialize matcher: %v", err)
+		mm.Close()
+		return fmt.Errorf("could not initialize matcher: %w", err)
 	}
 	defer m.Close()
+	h.AddCheck("matchmaker", m.Ready)
+	h.OnDrain(m.Drain)
+
+	// Party tickets finish on whichever replica watches them, and are recorded in the party
+	m.HandlePartyTickets(func(partyID string, status models.TicketStatus) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := parties.FinishTicket(ctx, partyID, status); err != nil {
+			log.Printf("Party %s: can't record the status of ticket %s: %v", partyID, status.TicketId, err)
+		}
+	})
+
+	// Assigned players get a join token for their game server
+	joinTokens, err := newJoinTokenSigner(cfg.JoinToken)
+	if err != nil {
+		return fmt.Errorf("could not initialize join tokens: %w", err)
+	}
 
-	r.GET("/login", handleGoogleLogin)
-	r.GET("/callback", handleGoogleCallback)
+	h.Register(r)
+
+	r.GET("/login", func(c *gin.Context) { handleLogin(c, oauthStates, providers.Default()) })
+	r.GET("/login/:provider", func(c *gin.Context) {
+		provider, err := providers.Get(c.Param("provider"))
+		if shared.HandleError(c, http.StatusNotFound, "auth login", err) {
+			return
+		}
+		handleLogin(c, oauthStates, provider)
+	})
+	r.POST("/login/:provider", func(c *gin.Context) {
+		provider, err := providers.Get(c.Param("provider"))
+		if shared.HandleError(c, http.StatusNotFound, "link login", err) {
+			return
+		}
+		handleLinkLogin(c, oauthStates, provider)
+	})
+	r.GET("/.well-known/jwks.json", handleJWKS)
+	r.GET("/callback", func(c *gin.Context) {
+		handleLoginCallback(c, oauthStates, providers, sessions, devices, profiles, cfg.Server.Client_launcher_port, secureCookies)
+	})
+	r.POST("/device/code", func(c *gin.Context) { handleDeviceCode(c, devices, verificationURL.String()) })
+	r.GET("/device", func(c *gin.Context) { handleDevicePage(c, providers, secureCookies) })
+	r.POST("/device", func(c *gin.Context) { handleDeviceSubmit(c, oauthStates, providers, devices, secureCookies) })
+	r.POST("/device/confirm", func(c *gin.Context) { handleDeviceConfirm(c, devices) })
+	r.POST("/device/token", func(c *gin.Context) { handleDeviceToken(c, devices, sessions) })
+	r.POST("/guest", ratelimit.PerIP(guestLimiter, "guest login", func(c *gin.Context) { handleGuestLogin(c, sessions, profiles) }))
+	r.POST("/token/exchange", func(c *gin.Context) { handleExchangeCode(c, sessions) })
+	r.POST("/token/refresh", func(c *gin.Context) { handleRefreshToken(c, sessions) })
+	r.POST("/logout", func(c *gin.Context) { handleLogout(c, sessions) })
 
 	// JWT protected endpoint handlers
-	r.POST("/play", auth.VerifyJWT(func(id string, c *gin.Context) { handlePlay(id, c, m) }))
-	r.GET("/profile", auth.VerifyJWT(handleProfile))
-	r.GET("/stats", auth.VerifyJWT(handleGetStats))
-	r.PUT("/stats", auth.VerifyJWT(handleUpdateStats))
-	r.GET("/ping", auth.VerifyJWT(handlePingServers))
+	r.POST("/play", auth.VerifyJWT(func(id string, c *gin.Context) { handlePlay(id, c, m, pingServers, profiles) }))
+	r.GET("/play/:ticket", auth.VerifyJWT(func(id string, c *gin.Context) { handlePlayStatus(id, c, m, joinTokens) }))
+	r.DELETE("/play", auth.VerifyJWT(func(id string, c *gin.Context) { handleCancelPlay(id, c, m) }))
+	r.POST("/party", auth.VerifyJWT(func(id string, c *gin.Context) { handleCreateParty(id, c, parties, pingServers) }))
+	r.GET("/party/:party", auth.VerifyJWT(func(id string, c *gin.Context) { handleGetParty(id, c, m, parties, joinTokens) }))
+	r.POST("/party/:party/join", auth.VerifyJWT(func(id string, c *gin.Context) { handleJoinParty(id, c, parties, pingServers) }))
+	r.POST("/party/:party/leave", auth.VerifyJWT(func(id string, c *gin.Context) { handleLeaveParty(id, c, m, parties) }))
+	r.POST("/party/:party/play", auth.VerifyJWT(func(id string, c *gin.Context) { handlePartyPlay(id, c, m, parties, profiles) }))
+	r.DELETE("/party/:party/play", auth.VerifyJWT(func(id string, c *gin.Context) { handleCancelPartyPlay(id, c, m, parties) }))
+	r.GET("/profile", auth.VerifyJWT(func(id string, c *gin.Context) { handleProfile(id, c, profiles) }))
+	r.GET("/identities", auth.VerifyJWT(func(id string, c *gin.Context) { handleGetIdentities(id, c, profiles) }))
+	r.POST("/link/:provider", auth.VerifyJWT(func(id string, c *gin.Context) { handleLinkProvider(id, c, oauthStates, providers) }))
+	r.GET("/stats", auth.VerifyJWT(func(id string, c *gin.Context) { handleGetStats(id, c, profiles) }))
+	r.PUT("/stats", auth.VerifyJWT(func(id string, c *gin.Context) { handleUpdateStats(id, c, profiles) }))
+	r.GET("/matches", auth.VerifyJWT(func(id string, c *gin.Context) { handleGetMatches(id, c, profiles) }))
+	r.GET("/ping", auth.VerifyJWT(func(id string, c *gin.Context) { handlePingServers(id, c, cfg.Services.Ping) }))
+
+	// The local identity provider serves its own sign in form
+	if p, err := providers.Get(identity.Local); err == nil {
+		local := p.(*identity.LocalProvider)
+		r.GET(identity.LocalLoginPath, local.HandleForm)
+		r.POST(identity.LocalLoginPath, local.HandleSubmit)
+	}
 
-	log.Printf("Google for Games Frontend API is listening on :%s\n", os.Getenv("LISTEN_PORT"))
+	// API key protected endpoint handlers, used by the game servers
+	r.POST("/stats", auth.VerifyApiKey(cfg.Auth.Api_access_key, func(c *gin.Context) { handleGameServerStats(c, sessions, profiles) }))
 
-	if err := r.Run(":" + os.Getenv("LISTEN_PORT")); err != nil {
-		log.Fatalf("could not run gin router: %s", err)
-		return
+	// Prometheus metrics, such as the time to assignment and matchmaking skill fallbacks, are served on an internal
+	// port, which the load balancer doesn't expose
+	metrics, err := serveMetrics(cfg.Server.Metrics_port)
+	if err != nil {
+		return err
+	}
+	defer metrics.Close()
+
+	log.Printf("Google for Games Frontend API is listening on :%d\n", cfg.Server.Listen_port)
+
+	// Requests in flight complete before the matchmaker and Redis connections are closed
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Server.Listen_port), Handler: r}
+	shutdown := health.ShutdownConfig{DrainDelay: cfg.Server.Drain_delay, Timeout: cfg.Server.Shutdown_timeout}
+	if err := health.ListenAndServe(ctx, srv, h, shutdown); err != nil {
+		return fmt.Errorf("could not run gin router: %w", err)
 	}
+	return nil
 }
 
-// Generates a redirect to google's login
-func handleGoogleLogin(c *gin.Context) {
-	url := googleOauthConfig.AuthCodeURL(oauthStateString, oauth2.AccessTypeOffline)
-	http.Redirect(c.Writer, c.Request, url, http.StatusTemporaryRedirect)
+// Serves the Prometheus metrics on /metrics of the port, until the returned server is closed
+func serveMetrics(port int) (*http.Server, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics serve failed: %v", err)
+		}
+	}()
+	log.Printf("Metrics are served on :%d/metrics", port)
+	return srv, nil
 }
 
-// Callback handler that gets the access token for further profile querying of Google APIs
-func handleGoogleCallback(c *gin.Context) {
+// Returns the matchmaker of the configuration: Open Match by default, or the local matchmaker for development
+// without Open Match and Agones
+func newMatchmaker(cfg config.Config) (match.Matchmaker, error) {
+	switch kind := cfg.Matchmaking.Matchmaker; kind {
+	case match.OpenMatchMatchmaker:
+		return match.NewOpenMatch(cfg.OpenMatch.Match())
+	case match.LocalMatchmaker:
+		return match.NewLocal(cfg.Matchmaking.Players_per_match, cfg.Matchmaking.Local_servers)
+	default:
+		return nil, fmt.Errorf("unknown matchmaker %q, want %q or %q", kind, match.OpenMatchMatchmaker, match.LocalMatchmaker)
+	}
+}
 
-	state := c.Request.FormValue("state")
-	if state != oauthStateString {
-		err := fmt.Errorf("invalid oauth state, expected '%s', got '%s'", oauthStateString, state)
-		if shared.HandleError(c, http.StatusBadRequest, "auth callback", err) {
-			return
+// Returns the identity providers of the configuration, the first being the default. "google" is configured by its
+// client id and secret, and "local" signs players in with just a name, for offline development. Any other name is an
+// OpenID Connect provider, configured by its issuer, client id and secret, and optionally scopes.
+func newIdentityProviders(cfg config.IdentityConfig, callbackURL string) (*identity.Providers, error) {
+	var providers []identity.Provider
+	for _, name := range cfg.Providers {
+		switch name {
+		case identity.Google:
+			providers = append(providers, identity.NewGoogle(cfg.Google.Client_id, cfg.Google.Client_secret, callbackURL))
+		case identity.Local:
+			log.Print("The local identity provider is enabled, anyone can sign in as any player. Only use it for development")
+			providers = append(providers, identity.NewLocal(callbackURL))
+		default:
+			oidc := cfg.Oidc[strings.ToLower(name)]
+			scopes := identity.DefaultScopes
+			if len(oidc.Scopes) > 0 {
+				scopes = oidc.Scopes
+			}
+			p, err := identity.Discover(context.Background(), name, oidc.Issuer, oidc.Client_id, oidc.Client_secret, callbackURL, scopes)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
 		}
 	}
+	return identity.NewProviders(providers...)
+}
+
+// Returns the signer of join tokens: ES256 with the private key file if set, so game servers only need the public
+// key, else HS256 with the shared key. Returns nil if neither is set, in which case no join tokens are minted.
+func newJoinTokenSigner(cfg config.JoinTokenConfig) (*jointoken.Signer, error) {
+	if path := cfg.Private_key; path != "" {
+		return jointoken.NewECDSASignerFromFile(path, cfg.Ttl)
+	}
+	if key := cfg.Key; key != "" {
+		return jointoken.NewHMACSigner([]byte(key), cfg.Ttl)
+	}
+	log.Print("JOIN_TOKEN_KEY and JOIN_TOKEN_PRIVATE_KEY not set, join tokens are disabled")
+	return nil, nil
+}
+
+// Returns the ticket status with a join token for the player, if the ticket is assigned and join tokens are enabled
+func withJoinToken(signer *jointoken.Signer, id string, status models.TicketStatus) models.TicketStatus {
+	conn := status.Connection
+	if signer == nil || status.Status != models.TicketAssigned || conn == nil {
+		return status
+	}
+	if conn.MatchId == "" {
+		log.Printf("Ticket %s: no match id, can't mint a join token", status.TicketId)
+		return status
+	}
+
+	token, _, err := signer.Sign(id, conn.MatchId, fmt.Sprintf("%s:%d", conn.IP, conn.Port))
+	if err != nil {
+		log.Printf("Ticket %s: %v", status.TicketId, err)
+		return status
+	}
+	status.JoinToken = token
+	return status
+}
 
-	code := c.Request.FormValue("code")
-	token, err := googleOauthConfig.Exchange(context.Background(), code)
-	if shared.HandleError(c, http.StatusBadRequest, "auth exchange", err) {
+// Returns the public keys JWTs are verified with, so other services can verify them without the signing keys
+func handleJWKS(c *gin.Context) {
+	keys, err := auth.PublicKeys()
+	if shared.HandleError(c, http.StatusInternalServerError, "jwks", err) {
 		return
 	}
 
-	// Call our profile service to create a new entry in Spanner DB
-	id, err := createProfileIfNotExists(token.AccessToken)
-	if shared.HandleError(c, http.StatusInternalServerError, "creating profile if not exists", err) {
+	// Keys are added well before they are used for signing, so caching them for a while is fine
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys)
+}
+
+// Generates a redirect to the identity provider's login, with a random state and PKCE code challenge for this login
+func handleLogin(c *gin.Context, oauthStates *auth.OAuthStates, provider identity.Provider) {
+	beginLogin(c, oauthStates, provider, auth.Login{Provider: provider.Name()})
+}
+
+// Starts a login that links the identity provider to the player of the link ticket, posted by the browser so the
+// ticket is never in a URL. The state cookie binds the callback to this browser
+func handleLinkLogin(c *gin.Context, oauthStates *auth.OAuthStates, provider identity.Provider) {
+	playerID, err := oauthStates.LinkTicketPlayer(c.PostForm("link"))
+	if shared.HandleError(c, http.StatusBadRequest, "link login", err) {
 		return
 	}
 
-	// Generate our own jwt token (1 month validity) that will be used in the game launcher / game client
-	jwtToken, err := auth.GenerateJWT(id, 31)
-	if shared.HandleError(c, http.StatusInternalServerError, "token generation", err) {
+	beginLogin(c, oauthStates, provider, auth.Login{Provider: provider.Name(), LinkPlayerId: playerID})
+}
+
+// Starts the login, and redirects to the identity provider
+func beginLogin(c *gin.Context, oauthStates *auth.OAuthStates, provider identity.Provider, login auth.Login) {
+	state, challenge, err := oauthStates.Begin(c, login)
+	if shared.HandleError(c, http.StatusInternalServerError, "auth login", err) {
 		return
 	}
 
-	// Redirect to the launcher callback port
-	http.Redirect(c.Writer, c.Request, fmt.Sprintf("http://localhost:%s/callback?token=%s", os.Getenv("CLIENT_LAUNCHER_PORT"), jwtToken), http.StatusTemporaryRedirect)
+	http.Redirect(c.Writer, c.Request, provider.AuthCodeURL(state, challenge), http.StatusSeeOther)
 }
 
-// Profile handling endpoint
-func handleProfile(id string, c *gin.Context) {
-	// Query our own profile service to get player data
-	response, err := http.Get(fmt.Sprintf("%s/players/%s", os.Getenv("PROFILE_SERVICE"), id))
-	if shared.HandleError(c, http.StatusInternalServerError, "fetching profile", err) {
+// Callback handler of all identity providers, which identifies the player and signs them in, or links the identity
+// to their player
+func handleLoginCallback(c *gin.Context, oauthStates *auth.OAuthStates, providers *identity.Providers, sessions *auth.Sessions, devices *device.Flow, profiles *profile.Client, launcherPort int, secureCookies bool) {
+	// Only finish logins started by this browser
+	login, err := oauthStates.Finish(c, c.Request.FormValue("state"))
+	if shared.HandleError(c, http.StatusBadRequest, "auth callback", err) {
+		return
+	}
+	if e := c.Request.FormValue("error"); e != "" {
+		// The launcher stops polling once the device is denied
+		if login.DeviceUserCode != "" {
+			if err := devices.Deny(c.Request.Context(), login.DeviceUserCode); err != nil {
+				log.Printf("can't deny device authorization: %s", err)
+			}
+		}
+		shared.HandleError(c, http.StatusBadRequest, "auth callback", fmt.Errorf("login failed: %s", e))
 		return
 	}
 
-	defer response.Body.Close()
+	provider, err := providers.Get(login.Provider)
+	if shared.HandleError(c, http.StatusBadRequest, "auth callback", err) {
+		return
+	}
+	ident, err := provider.Identify(c.Request.Context(), c.Request.FormValue("code"), login.Verifier)
+	if handleIdentifyError(c, "auth exchange", err) {
+		return
+	}
 
-	if response.StatusCode == 200 {
-		// Profile found, decode and show
-		var p models.Player
-		err := json.NewDecoder(response.Body).Decode(&p)
-		if shared.HandleError(c, http.StatusInternalServerError, "decoding profile", err) {
+	if login.LinkPlayerId != "" {
+		// Linking an identity provider upgrades guests to full players, ending their guest sessions. They are ended
+		// first, so they don't outlive a successful upgrade
+		guest, err := isGuestPlayer(c.Request.Context(), profiles, login.LinkPlayerId)
+		if handleProfileError(c, "linking identity", err) {
 			return
 		}
-
-		c.JSON(http.StatusOK, p)
-	} else if response.StatusCode == 404 { // If not found, return an error
-		err := fmt.Errorf("profile not found: %s", id)
-		if shared.HandleError(c, http.StatusBadRequest, "profile lookup", err) {
+		if guest {
+			err := sessions.EndGuestSessions(c.Request.Context(), login.LinkPlayerId)
+			if shared.HandleError(c, http.StatusServiceUnavailable, "linking identity", err) {
+				return
+			}
+		}
+		err = linkIdentity(c.Request.Context(), profiles, ident, login.LinkPlayerId)
+		if errors.Is(err, errIdentityLinked) {
+			shared.HandleErrorCode(c, http.StatusConflict, shared.CodeIdentityLinked, "linking identity", err)
 			return
 		}
-	} else {
-		err := fmt.Errorf("unable to fetch profile, error code: %d", response.StatusCode)
-		if shared.HandleError(c, http.StatusBadRequest, "profile lookup", err) {
+		if handleProfileError(c, "linking identity", err) {
 			return
 		}
-	}
-}
 
-// Getting the stats from profile api
-func handleGetStats(id string, c *gin.Context) {
-	response, err := http.Get(fmt.Sprintf("%s/players/%s/stats", os.Getenv("PROFILE_SERVICE"), id))
-	if shared.HandleError(c, http.StatusInternalServerError, "fetching profile", err) {
+		// Upgraded guests are signed in as full players right away
+		if guest {
+			redirectToLauncher(c, sessions, login.LinkPlayerId, launcherPort)
+			return
+		}
+
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<p>
+	<h2>Linked successfully. You can now sign in with this account. Please return to your application.</h2>
+</p>`))
 		return
 	}
 
-	defer response.Body.Close()
+	// Find the player of the identity in our profile service, creating them on their first sign in
+	id, err := resolvePlayer(c.Request.Context(), profiles, ident)
+	if handleProfileError(c, "resolving player", err) {
+		return
+	}
 
-	if response.StatusCode == 200 {
-		// Profile found, decode and show
-		var p models.Player
-		err := json.NewDecoder(response.Body).Decode(&p)
-		if shared.HandleError(c, http.StatusInternalServerError, "decoding profile", err) {
+	// Devices get their tokens when polling, not from the browser, once the player confirmed the device
+	if login.DeviceUserCode != "" {
+		confirmToken, err := devices.SignIn(c.Request.Context(), login.DeviceUserCode, id)
+		if errors.Is(err, device.ErrInvalidUserCode) {
+			shared.HandleError(c, http.StatusBadRequest, "device login", err)
 			return
 		}
-
-		c.JSON(http.StatusOK, p.Stats)
-	} else if response.StatusCode == 404 { // If not found, return an error
-		err := fmt.Errorf("profile not found: %s", id)
-		if shared.HandleError(c, http.StatusBadRequest, "profile lookup", err) {
+		if shared.HandleError(c, http.StatusServiceUnavailable, "device login", err) {
 			return
 		}
-	} else {
-		err := fmt.Errorf("unable to fetch profile, error code: %d", response.StatusCode)
-		if shared.HandleError(c, http.StatusBadRequest, "profile lookup", err) {
+
+		csrfToken, err := device.SetCSRFCookie(c.Writer, secureCookies)
+		if shared.HandleError(c, http.StatusInternalServerError, "device login", err) {
 			return
 		}
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if err := device.WriteConfirmPage(c.Writer, "/device/confirm", csrfToken, login.DeviceUserCode, confirmToken); err != nil {
+			log.Printf("can't write device confirmation page: %s", err)
+		}
+		return
+	}
+
+	redirectToLauncher(c, sessions, id, launcherPort)
+}
+
+// Starts a device authorization, returning the device code the launcher polls with, and the user code the player
+// enters in their browser
+func handleDeviceCode(c *gin.Context, devices *device.Flow, verificationURL string) {
+	a, err := devices.Start(c.Request.Context())
+	if shared.HandleError(c, http.StatusServiceUnavailable, "device code", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.DeviceAuthorization{
+		DeviceCode:              a.DeviceCode,
+		UserCode:                a.UserCode,
+		VerificationUri:         verificationURL,
+		VerificationUriComplete: verificationURL + "?" + url.Values{"user_code": {a.UserCode}}.Encode(),
+		ExpiresIn:               int64(a.ExpiresIn.Seconds()),
+		Interval:                int64(a.Interval.Seconds()),
+	})
+}
+
+// Serves the page players enter the user code of their device on
+func handleDevicePage(c *gin.Context, providers *identity.Providers, secureCookies bool) {
+	writeDevicePage(c, http.StatusOK, providers, c.Query("user_code"), "", secureCookies)
+}
+
+// Checks the user code entered by the player, and starts their login with the identity provider they picked
+func handleDeviceSubmit(c *gin.Context, oauthStates *auth.OAuthStates, providers *identity.Providers, devices *device.Flow, secureCookies bool) {
+	userCode := c.PostForm("user_code")
+	if !device.CheckCSRF(c.Request) {
+		writeDevicePage(c, http.StatusForbidden, providers, userCode, "This page expired. Please try again.", secureCookies)
+		return
+	}
+	provider, err := providers.Get(c.PostForm("provider"))
+	if err != nil {
+		writeDevicePage(c, http.StatusBadRequest, providers, userCode, "Please pick a way to sign in.", secureCookies)
+		return
+	}
+
+	err = devices.Check(c.Request.Context(), userCode)
+	if errors.Is(err, device.ErrInvalidUserCode) {
+		writeDevicePage(c, http.StatusBadRequest, providers, userCode, "This code is invalid or expired. Please check the code shown by your launcher.", secureCookies)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "device login", err) {
+		return
+	}
+
+	beginLogin(c, oauthStates, provider, auth.Login{Provider: provider.Name(), DeviceUserCode: device.NormalizeUserCode(userCode)})
+}
+
+// Approves or denies the device the player signed in for, as they chose on the confirmation page
+func handleDeviceConfirm(c *gin.Context, devices *device.Flow) {
+	if !device.CheckCSRF(c.Request) {
+		shared.HandleError(c, http.StatusForbidden, "device confirmation", errors.New("invalid CSRF token"))
+		return
+	}
+
+	approve := c.PostForm("decision") == "approve"
+	err := devices.Confirm(c.Request.Context(), c.PostForm("user_code"), c.PostForm("confirm"), approve)
+	if errors.Is(err, device.ErrInvalidUserCode) || errors.Is(err, device.ErrInvalidConfirmation) {
+		shared.HandleError(c, http.StatusBadRequest, "device confirmation", err)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "device confirmation", err) {
+		return
+	}
+
+	if !approve {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<p>
+	<h2>The device was denied, and won't be signed in. You can close this tab.</h2>
+</p>`))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<p>
+	<h2>Signed in successfully. Please return to your application, you can close this tab.</h2>
+</p>`))
+}
+
+// Writes the page players enter the user code on, with a new CSRF token
+func writeDevicePage(c *gin.Context, code int, providers *identity.Providers, userCode, errMsg string, secureCookies bool) {
+	csrfToken, err := device.SetCSRFCookie(c.Writer, secureCookies)
+	if shared.HandleError(c, http.StatusInternalServerError, "device page", err) {
+		return
+	}
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := device.WriteEntryPage(c.Writer, "/device", csrfToken, userCode, providers.Names(), errMsg); err != nil {
+		log.Printf("can't write device page: %s", err)
+	}
+}
+
+// Polled by the launcher with its device code. Once the player signed in, starts their session and returns its
+// token pair. Until then, fails with a 400 and the error code of RFC 8628, e.g. "authorization_pending", as both
+// error and code
+func handleDeviceToken(c *gin.Context, devices *device.Flow, sessions *auth.Sessions) {
+	var req models.DeviceTokenRequest
+	if err := c.ShouldBindJSON(&req); shared.HandleError(c, http.StatusBadRequest, "device token", err) {
+		return
+	}
+
+	id, err := devices.Poll(c.Request.Context(), req.DeviceCode)
+	switch {
+	case errors.Is(err, device.ErrAuthorizationPending), errors.Is(err, device.ErrSlowDown), errors.Is(err, device.ErrAccessDenied),
+		errors.Is(err, device.ErrExpiredToken), errors.Is(err, device.ErrInvalidGrant):
+		shared.HandleErrorCode(c, http.StatusBadRequest, err.Error(), "device token", err)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "device token", err) {
+		return
+	}
+
+	tokens, err := sessions.Start(c.Request.Context(), id)
+	if shared.HandleError(c, http.StatusServiceUnavailable, "token generation", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Redirects to the launcher with a one-time login code, which it exchanges for the tokens of a new session with
+// POST /token/exchange. Tokens are never put in the URL, where they would end up in the browser's history
+func redirectToLauncher(c *gin.Context, sessions *auth.Sessions, id string, launcherPort int) {
+	code, err := sessions.IssueLoginCode(c.Request.Context(), id)
+	if shared.HandleError(c, http.StatusServiceUnavailable, "login code", err) {
+		return
+	}
+
+	// Redirect to the launcher callback port
+	query := url.Values{"code": {code}}
+	http.Redirect(c.Writer, c.Request, fmt.Sprintf("http://localhost:%d/callback?%s", launcherPort, query.Encode()), http.StatusTemporaryRedirect)
+}
+
+// Exchanges the login code the launcher got on its callback for the token pair of a new session. Each code can
+// only be used once
+func handleExchangeCode(c *gin.Context, sessions *auth.Sessions) {
+	var req models.CodeExchangeRequest
+	if err := c.ShouldBindJSON(&req); shared.HandleError(c, http.StatusBadRequest, "code exchange", err) {
+		return
+	}
+
+	tokens, err := sessions.ExchangeLoginCode(c.Request.Context(), req.Code)
+	if errors.Is(err, auth.ErrInvalidLoginCode) {
+		shared.HandleErrorCode(c, http.StatusUnauthorized, shared.CodeInvalidLoginCode, "code exchange", err)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "code exchange", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Signs a guest in with the id of their device, creating their player on their first sign in. Guests that linked an
+// identity provider are no longer guests, and have to sign in with it
+func handleGuestLogin(c *gin.Context, sessions *auth.Sessions, profiles *profile.Client) {
+	var req models.GuestRequest
+	if err := c.ShouldBindJSON(&req); shared.HandleError(c, http.StatusBadRequest, "guest login", err) {
+		return
+	}
+
+	id, err := resolvePlayer(c.Request.Context(), profiles, identity.NewGuest(req.DeviceId))
+	if handleProfileError(c, "resolving player", err) {
+		return
+	}
+	guest, err := isGuestPlayer(c.Request.Context(), profiles, id)
+	if handleProfileError(c, "resolving player", err) {
+		return
+	}
+	if !guest {
+		shared.HandleErrorCode(c, http.StatusConflict, shared.CodeGuestUpgraded, "guest login", errGuestUpgraded)
+		return
+	}
+
+	tokens, err := sessions.StartGuest(c.Request.Context(), id)
+	if shared.HandleError(c, http.StatusServiceUnavailable, "token generation", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Returns the link ticket to post from a browser to link an identity provider to the player, and the URL to post it to
+func handleLinkProvider(id string, c *gin.Context, oauthStates *auth.OAuthStates, providers *identity.Providers) {
+	provider, err := providers.Get(c.Param("provider"))
+	if shared.HandleError(c, http.StatusNotFound, "link provider", err) {
+		return
+	}
+
+	ticket, err := oauthStates.LinkTicket(id)
+	if shared.HandleError(c, http.StatusInternalServerError, "link provider", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": "/login/" + provider.Name(), "ticket": ticket})
+}
+
+// Lists the identities linked to the player
+func handleGetIdentities(id string, c *gin.Context, profiles *profile.Client) {
+	identities, err := profiles.GetPlayerIdentities(c.Request.Context(), id)
+	if handleProfileError(c, "identities lookup", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// Exchanges a refresh token for a new access token and refresh token. Each refresh token can only be used once
+func handleRefreshToken(c *gin.Context, sessions *auth.Sessions) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); shared.HandleError(c, http.StatusBadRequest, "refresh token", err) {
+		return
+	}
+
+	tokens, err := sessions.Refresh(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		shared.HandleErrorCode(c, http.StatusUnauthorized, shared.CodeInvalidRefreshToken, "refresh token", err)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "refresh token", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Ends the session of the refresh token, revoking it and its access tokens
+func handleLogout(c *gin.Context, sessions *auth.Sessions) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); shared.HandleError(c, http.StatusBadRequest, "logout", err) {
+		return
+	}
+
+	err := sessions.End(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		shared.HandleErrorCode(c, http.StatusUnauthorized, shared.CodeInvalidRefreshToken, "logout", err)
+		return
+	}
+	if shared.HandleError(c, http.StatusServiceUnavailable, "logout", err) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Profile handling endpoint
+func handleProfile(id string, c *gin.Context, profiles *profile.Client) {
+	// Query our own profile service to get player data
+	p, err := profiles.GetPlayer(c.Request.Context(), id)
+	if handleProfileError(c, "profile lookup", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// Getting the stats from profile api
+func handleGetStats(id string, c *gin.Context, profiles *profile.Client) {
+	p, err := profiles.GetPlayerStats(c.Request.Context(), id)
+	if handleProfileError(c, "stats lookup", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, p.Stats)
+}
+
+// Getting the recent games from profile api. Passes through the limit and offset pagination parameters.
+func handleGetMatches(id string, c *gin.Context, profiles *profile.Client) {
+	var page [2]int64
+	for i, param := range []string{"limit", "offset"} {
+		if value, ok := c.GetQuery(param); ok {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				shared.HandleError(c, http.StatusBadRequest, "matches lookup", fmt.Errorf("invalid %s: %q", param, value))
+				return
+			}
+			page[i] = n
+		}
+	}
+
+	g, err := profiles.GetPlayerGames(c.Request.Context(), id, page[0], page[1])
+	if handleProfileError(c, "matches lookup", err) {
+		return
 	}
+
+	c.JSON(http.StatusOK, g)
 }
 
 // Updating the stats in the profile api
-func handleUpdateStats(id string, c *gin.Context) {
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/players/%s/stats", os.Getenv("PROFILE_SERVICE"), id), c.Request.Body)
-	if shared.HandleError(c, http.StatusInternalServerError, "stats update", err) {
+func handleUpdateStats(id string, c *gin.Context, profiles *profile.Client) {
+	var stats profile.SingleGameStats
+	if err := c.ShouldBindJSON(&stats); shared.HandleError(c, http.StatusBadRequest, "stats update", err) {
 		return
 	}
+	// Players only update their own stats
+	stats.Player_google_id = id
 
-	response, err := client.Do(req)
-	if shared.HandleError(c, http.StatusInternalServerError, "stats update", err) {
+	_, err := profiles.UpdatePlayerStats(c.Request.Context(), id, &stats)
+	if handleProfileError(c, "stats update", err) {
 		return
 	}
 
-	defer response.Body.Close()
+	c.JSON(http.StatusOK, "OK")
+}
 
-	if response.StatusCode == 200 {
-		c.JSON(http.StatusOK, "OK")
+// Records the outcome of a game for a player, as reported by the game server
+func handleGameServerStats(c *gin.Context, sessions *auth.Sessions, profiles *profile.Client) {
+	var gs models.GameServerStats
+	err := c.ShouldBindJSON(&gs)
+	if shared.HandleError(c, http.StatusBadRequest, "game server stats", err) {
 		return
-	} else {
-		err := fmt.Errorf("unable to update profile stats, error code: %d", response.StatusCode)
-		if shared.HandleError(c, http.StatusBadRequest, "stats update", err) {
-			return
+	}
+
+	// The game server relays the player's own token, which tells us who the stats belong to
+	claims, err := auth.ParseExpiredJWT(gs.Token, statsTokenGrace)
+	if shared.HandleError(c, http.StatusUnauthorized, "player token", err) {
+		return
+	}
+	// Expired tokens are accepted for a while, but not once the player's session was revoked
+	if auth.HandleSessionError(c, "player token", sessions.Check(c.Request.Context(), claims)) {
+		return
+	}
+
+	// Opponents are only used to rate the player, so ones we can't identify are skipped rather than failing the request
+	var opponents []string
+	for _, token := range gs.OpponentTokens {
+		opponent, err := auth.ParseExpiredJWT(token, statsTokenGrace)
+		if err != nil {
+			log.Printf("skipping opponent of %s in game %s: %s", claims.Id, gs.GameId, err)
+			continue
 		}
+		opponents = append(opponents, opponent.Id)
+	}
 
+	stats := profile.SingleGameStats{
+		Player_google_id: claims.Id,
+		Game_id:          gs.GameId,
+		Region:           gs.Region,
+		Won:              gs.Won,
+		Score:            gs.Score,
+		Kills:            gs.Kills,
+		Deaths:           gs.Deaths,
+		Opponents:        opponents,
+	}
+
+	_, err = profiles.UpdatePlayerStats(c.Request.Context(), claims.Id, &stats)
+	if handleProfileError(c, "stats update", err) {
+		return
 	}
+
+	c.JSON(http.StatusOK, "OK")
 }
 
 // WIP: Needs an endpoint to fetch the ping servers
-func handlePingServers(id string, c *gin.Context) {
+func handlePingServers(id string, c *gin.Context, pingURL string) {
 	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/list", os.Getenv("PING_SERVICE")), c.Request.Body)
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/list", pingURL), c.Request.Body)
 	if shared.HandleError(c, http.StatusInternalServerError, "fetch ping servers", err) {
 		return
 	}
 
 	response, err := client.Do(req)
-	if shared.HandleError(c, http.StatusInternalServerError, "fetch ping servers", err) {
+	if shared.HandleUpstreamError(c, "fetch ping servers", err) {
 		return
 	}
 
@@ -232,7 +845,7 @@ func handlePingServers(id string, c *gin.Context) {
 	if response.StatusCode == 200 {
 		var pingServers map[string]models.PingServer
 		err := json.NewDecoder(response.Body).Decode(&pingServers)
-		if shared.HandleError(c, http.StatusInternalServerError, "decoding ping servers", err) {
+		if shared.HandleError(c, http.StatusBadGateway, "decoding ping servers", err) {
 			return
 		}
 
@@ -240,108 +853,379 @@ func handlePingServers(id string, c *gin.Context) {
 		return
 	} else {
 		err := fmt.Errorf("unable to fetch ping servers, error code: %d", response.StatusCode)
-		if shared.HandleError(c, http.StatusBadRequest, "stats update", err) {
+		if shared.HandleError(c, shared.UpstreamStatusCode(response.StatusCode), "fetch ping servers", err) {
 			return
 		}
 
 	}
 }
 
-// WIP: Handles the play request from the game client
-func handlePlay(id string, c *gin.Context, m *match.Matcher) {
+// Handles the play request from the game client. Starts matchmaking and returns the pending ticket right away,
+// whose status is then polled via GET /play/:ticket.
+func handlePlay(id string, c *gin.Context, m *match.Matcher, ps *ping.Servers, profiles *profile.Client) {
+	pr, ok := bindPlayRequest(c, "play", ps)
+	if !ok {
+		return
+	}
+
+	// Matchmake on the player's skill. If it can't be retrieved, still let the player play with a fallback skill
+	p, err := fetchPlayerStats(c.Request.Context(), profiles, id)
+	if err != nil {
+		p = m.FallbackPlayer(id, err)
+	}
 
-	host, hok := os.LookupEnv("LOCAL_OPENMATCH_SERVER_OVERRIDE_HOST")
-	port, pok := os.LookupEnv("LOCAL_OPENMATCH_SERVER_OVERRIDE_PORT")
-	if hok && pok {
-		port, _ := strconv.Atoi(port)
-		fmt.Printf("Overriding openmatch response with %s:%d\n", host, port)
-		c.JSON(http.StatusOK, models.OMServerResponse{IP: host, Port: port})
+	status, err := m.StartSearch(c.Request.Context(), id, pr, p)
+	if handleMatchError(c, "play", err) {
 		return
 	}
 
-	pr := &models.PlayRequest{}
-	if err := c.Bind(pr); err != nil {
-		c.JSON(http.StatusBadRequest, err.Error())
+	c.JSON(http.StatusAccepted, status)
+}
+
+// Returns the status of the player's matchmaking ticket, including the game server to connect to and a join token
+// once assigned
+func handlePlayStatus(id string, c *gin.Context, m *match.Matcher, joinTokens *jointoken.Signer) {
+	status, err := m.TicketStatus(c.Request.Context(), id, c.Param("ticket"))
+	if errors.Is(err, match.ErrTicketNotFound) {
+		shared.HandleErrorCode(c, http.StatusNotFound, shared.CodeTicketNotFound, "ticket status", err)
+		return
+	}
+	if shared.HandleUpstreamError(c, "ticket status", err) {
 		return
 	}
 
-	// TODO #1: Get profile here (from Cloud Spanner via token/id??)
-	// TODO #2: Add profile parameter for finding the server (besides the preferred region)
+	c.JSON(http.StatusOK, withJoinToken(joinTokens, id, status))
+}
 
-	conn, err := m.FindMatchingServer(c.Request.Context(), pr)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+// Cancels the player's pending matchmaking ticket
+func handleCancelPlay(id string, c *gin.Context, m *match.Matcher) {
+	status, err := m.CancelSearch(c.Request.Context(), id)
+	if errors.Is(err, match.ErrTicketNotFound) {
+		shared.HandleErrorCode(c, http.StatusNotFound, shared.CodeTicketNotFound, "cancel play", err)
+		return
+	}
+	if shared.HandleUpstreamError(c, "cancel play", err) {
 		return
 	}
 
-	c.JSON(http.StatusOK, conn)
+	c.JSON(http.StatusOK, status)
 }
 
-// Function responsible for checking if profile is not yet created in our own profile service
-func createProfileIfNotExists(token string) (string, error) {
+// Binds the PlayRequest and validates its pings against the regions of the ping servers. Responds with a 400
+// listing the invalid fields if the request is invalid.
+func bindPlayRequest(c *gin.Context, context string, ps *ping.Servers) (*models.PlayRequest, bool) {
+	pr := &models.PlayRequest{}
+	if err := c.ShouldBind(pr); shared.HandleError(c, http.StatusBadRequest, context, err) {
+		return nil, false
+	}
 
-	// Fetch the data from Google's API by access token
-	response, err := http.Get("https://www.googleapis.com/oauth2/v3/userinfo?access_token=" + token)
+	// Don't turn players away when the ping discovery service is down, only the pings themselves are validated then
+	known, err := ps.List(c.Request.Context())
 	if err != nil {
-		return "", err
+		log.Printf("Validating pings without known regions: %v", err)
+	}
+	if shared.HandleValidationError(c, context, pr.Validate(known)) {
+		return nil, false
+	}
+	return pr, true
+}
+
+// Creates a party led by the player
+func handleCreateParty(id string, c *gin.Context, parties *party.Service, ps *ping.Servers) {
+	pr, ok := bindPlayRequest(c, "create party", ps)
+	if !ok {
+		return
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		return "", fmt.Errorf("unable to fetch google's user profile, error code: %d", response.StatusCode)
+	p, err := parties.Create(c.Request.Context(), id, pr.PingByRegion)
+	if handlePartyError(c, "create party", err) {
+		return
 	}
 
-	var udata bytes.Buffer
-	_, err = io.Copy(&udata, response.Body)
+	c.JSON(http.StatusCreated, p)
+}
 
-	if err != nil {
-		return "", err
+// Returns the party, including the status of its ticket while searching or once assigned, with a join token for
+// the player
+func handleGetParty(id string, c *gin.Context, m *match.Matcher, parties *party.Service, joinTokens *jointoken.Signer) {
+	p, err := parties.Get(c.Request.Context(), c.Param("party"), id)
+	if handlePartyError(c, "get party", err) {
+		return
+	}
+	if p.Ticket != nil && p.Ticket.Status == models.TicketPending {
+		m.EnsureWatched(c.Request.Context(), p.Ticket.TicketId)
+	}
+	if p.Ticket != nil {
+		ticket := withJoinToken(joinTokens, id, *p.Ticket)
+		p.Ticket = &ticket
 	}
 
-	var userInfo models.UserInfo
-	err = json.Unmarshal(udata.Bytes(), &userInfo)
-	if err != nil {
-		return "", err
+	c.JSON(http.StatusOK, p)
+}
+
+// Adds the player to the party, or updates their pings if they are a member already
+func handleJoinParty(id string, c *gin.Context, parties *party.Service, ps *ping.Servers) {
+	pr, ok := bindPlayRequest(c, "join party", ps)
+	if !ok {
+		return
+	}
+
+	p, err := parties.Join(c.Request.Context(), c.Param("party"), id, pr.PingByRegion)
+	if handlePartyError(c, "join party", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// Removes the player from the party, cancelling its search. The party is disbanded when its leader leaves
+func handleLeaveParty(id string, c *gin.Context, m *match.Matcher, parties *party.Service) {
+	p, cancelled, err := parties.Leave(c.Request.Context(), c.Param("party"), id)
+	if handlePartyError(c, "leave party", err) {
+		return
+	}
+	if cancelled != "" {
+		if err := m.CancelTicket(c.Request.Context(), cancelled); err != nil {
+			log.Printf("Party %s: can't delete ticket %s: %v", c.Param("party"), cancelled, err)
+		}
+	}
+
+	if p == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// Starts the search for a match for all the party's members, on a single ticket. Only the leader can start it
+func handlePartyPlay(id string, c *gin.Context, m *match.Matcher, parties *party.Service, profiles *profile.Client) {
+	ctx := c.Request.Context()
+
+	p, err := parties.PrepareSearch(ctx, c.Param("party"), id)
+	if handlePartyError(c, "party play", err) {
+		return
+	}
+	pr, err := party.PlayRequest(p)
+	if handlePartyError(c, "party play", err) {
+		return
+	}
+
+	// Matchmake on the members' skill, with a fallback skill for those whose profile can't be retrieved
+	members := make([]*profile.Player, len(p.Members))
+	for i, member := range p.Members {
+		if members[i], err = fetchPlayerStats(ctx, profiles, member.PlayerId); err != nil {
+			members[i] = m.FallbackPlayer(member.PlayerId, err)
+		}
 	}
 
-	// Fetch profile data from our profile service
-	response, err = http.Get(fmt.Sprintf("%s/players/%s", os.Getenv("PROFILE_SERVICE"), userInfo.Sub))
+	partyID := p.PartyId
+	status, err := m.StartPartySearch(ctx, partyID, pr, members)
+	if handleMatchError(c, "party play", err) {
+		return
+	}
+
+	p, err = parties.SetTicket(ctx, p, status)
 	if err != nil {
+		// Don't leave a ticket behind for a search that didn't start
+		if err := m.CancelTicket(ctx, status.TicketId); err != nil {
+			log.Printf("Party %s: can't delete ticket %s: %v", partyID, status.TicketId, err)
+		}
+		handlePartyError(c, "party play", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, p)
+}
+
+// Cancels the party's search. Only the leader can cancel it
+func handleCancelPartyPlay(id string, c *gin.Context, m *match.Matcher, parties *party.Service) {
+	p, cancelled, err := parties.CancelSearch(c.Request.Context(), c.Param("party"), id)
+	if handlePartyError(c, "cancel party play", err) {
+		return
+	}
+	if err := m.CancelTicket(c.Request.Context(), cancelled); shared.HandleUpstreamError(c, "cancel party play", err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// Responds with a 400 if the identity provider rejected the authorization code, e.g. as it expired or was already
+// used, and with the status code matching the provider's failure otherwise
+func handleIdentifyError(c *gin.Context, context string, err error) bool {
+	var re *oauth2.RetrieveError
+	rejected := errors.As(err, &re) && re.Response != nil && re.Response.StatusCode >= 400 && re.Response.StatusCode < 500
+	if rejected || errors.Is(err, identity.ErrInvalidCode) {
+		return shared.HandleError(c, http.StatusBadRequest, context, err)
+	}
+	return shared.HandleUpstreamError(c, context, err)
+}
+
+// Responds with a 503 asking to retry searches refused while shutting down, which the load balancer sends to another
+// replica, with a 409 for parties too large for a match, and with the status code matching the matchmaker's failure
+// otherwise
+func handleMatchError(c *gin.Context, context string, err error) bool {
+	if errors.Is(err, match.ErrDraining) {
+		c.Header("Retry-After", "1")
+		return shared.HandleErrorCode(c, http.StatusServiceUnavailable, shared.CodeUnavailable, context, err)
+	}
+	if errors.Is(err, match.ErrTooManyPlayers) {
+		return shared.HandleError(c, http.StatusConflict, context, err)
+	}
+	return shared.HandleUpstreamError(c, context, err)
+}
+
+// Responds with the status code and error code matching a party error, if any
+func handlePartyError(c *gin.Context, context string, err error) bool {
+	for _, e := range []struct {
+		err       error
+		code      int
+		errorCode string
+	}{
+		{party.ErrPartyNotFound, http.StatusNotFound, shared.CodePartyNotFound},
+		{party.ErrNotMember, http.StatusForbidden, shared.CodeNotPartyMember},
+		{party.ErrNotLeader, http.StatusForbidden, shared.CodeNotPartyLeader},
+		{party.ErrInOtherParty, http.StatusConflict, shared.CodeInOtherParty},
+		{party.ErrPartyFull, http.StatusConflict, shared.CodePartyFull},
+		{party.ErrSearching, http.StatusConflict, shared.CodePartySearching},
+		{party.ErrNotSearching, http.StatusConflict, shared.CodePartyNotSearching},
+		{party.ErrPartyChanged, http.StatusConflict, shared.CodePartyChanged},
+		{party.ErrNoCommonRegion, http.StatusBadRequest, shared.CodeNoCommonRegion},
+	} {
+		if errors.Is(err, e.err) {
+			return shared.HandleErrorCode(c, e.code, e.errorCode, context, err)
+		}
+	}
+	return shared.HandleError(c, http.StatusServiceUnavailable, context, err)
+}
+
+// Fetches the player's skill and tier from the profile service, for matchmaking. Matchmaking doesn't wait long for
+// them, as it falls back to a default skill
+func fetchPlayerStats(ctx context.Context, profiles *profile.Client, id string) (*profile.Player, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return profiles.GetPlayerStats(ctx, id)
+}
+
+// Responds with the status code matching an error of the profile service, if any. Failures of the profile service
+// itself are upstream failures
+func handleProfileError(c *gin.Context, context string, err error) bool {
+	switch {
+	case errors.Is(err, profile.ErrNotFound):
+		return shared.HandleError(c, http.StatusNotFound, context, err)
+	case errors.Is(err, profile.ErrConflict):
+		return shared.HandleError(c, http.StatusConflict, context, err)
+	case errors.Is(err, profile.ErrBadRequest):
+		return shared.HandleError(c, http.StatusBadRequest, context, err)
+	}
+	return shared.HandleUpstreamError(c, context, err)
+}
+
+var (
+	// errIdentityLinked is returned by linkIdentity when the identity is linked to another player
+	errIdentityLinked = errors.New("identity is linked to another player")
+	// errGuestUpgraded is returned to guests signing in with a device whose player linked an identity provider
+	errGuestUpgraded = errors.New("guest account was upgraded, sign in with its identity provider")
+)
+
+// Returns the id of the player the identity belongs to, creating the player on their first sign in. Players who
+// signed in with Google before there were identity providers keep their Google subject as id, other players get the
+// id of the identity they first signed in with.
+func resolvePlayer(ctx context.Context, profiles *profile.Client, ident *identity.Identity) (string, error) {
+	linked, err := profiles.GetIdentity(ctx, ident.ID())
+	if err == nil {
+		return linked.Player_google_id, nil
+	}
+	if !errors.Is(err, profile.ErrNotFound) {
 		return "", err
 	}
-	defer response.Body.Close()
 
-	// If we get a 404, means that the player doesn't exist and we need to create it via POST call
-	if response.StatusCode == 404 {
-		// Init the model with data fro mgoogle's api
-		p := models.Player{
-			Player_google_id: userInfo.Sub,
-			Player_name:      userInfo.Name,
-			Profile_image:    userInfo.Picture,
-			Region:           userInfo.Locale,
-			Stats:            models.PlayerStats{},
+	candidates := []string{ident.ID()}
+	if ident.Provider == identity.Google {
+		candidates = []string{ident.Subject, ident.ID()}
+	}
+
+	// Link the first existing player, in case of legacy players and earlier sign ins that failed before linking
+	playerID := ""
+	for _, candidate := range candidates {
+		_, err := profiles.GetPlayer(ctx, candidate)
+		if err == nil {
+			playerID = candidate
+			break
+		}
+		if !errors.Is(err, profile.ErrNotFound) {
+			return "", err
+		}
+	}
+
+	if playerID == "" {
+		playerID = ident.ID()
+		name := ident.Name
+		if name == "" {
+			name = ident.Subject
+		}
+		p := profile.Player{
+			Player_google_id: playerID,
+			Player_name:      name,
+			Profile_image:    ident.Picture,
+			Region:           ident.Locale,
+			Stats:            profile.PlayerStats{},
 			Skill_level:      0,
 			Tier:             "U",
 		}
-
-		profileData, _ := json.Marshal(p)
-		request, err := http.NewRequest("POST", os.Getenv("PROFILE_SERVICE")+"/players", bytes.NewBuffer(profileData))
-		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
-		if err != nil {
+		if err := profiles.CreatePlayer(ctx, &p); err != nil {
 			return "", err
 		}
+	}
 
-		client := &http.Client{}
-		response, err := client.Do(request)
-
+	err = linkIdentity(ctx, profiles, ident, playerID)
+	if errors.Is(err, errIdentityLinked) {
+		// Linked by a concurrent sign in
+		linked, err := profiles.GetIdentity(ctx, ident.ID())
 		if err != nil {
 			return "", err
 		}
+		return linked.Player_google_id, nil
+	}
+	if err != nil {
+		return "", err
+	}
 
-		defer response.Body.Close()
+	return playerID, nil
+}
+
+// Reports whether the player is a guest, which they are until they link an identity provider
+func isGuestPlayer(ctx context.Context, profiles *profile.Client, id string) (bool, error) {
+	identities, err := profiles.GetPlayerIdentities(ctx, id)
+	if err != nil {
+		return false, err
+	}
 
+	for _, i := range identities {
+		if !identity.IsGuest(i.Identity) {
+			return false, nil
+		}
 	}
+	return len(identities) > 0, nil
+}
 
-	// Return google's user ID that we use as a primary in our profile service
-	return userInfo.Sub, nil
+// Links the identity to the player in our profile service
+func linkIdentity(ctx context.Context, profiles *profile.Client, ident *identity.Identity, playerID string) error {
+	// Google identities of legacy players may not be linked yet, but still belong to them
+	if ident.Provider == identity.Google && ident.Subject != playerID {
+		_, err := profiles.GetPlayer(ctx, ident.Subject)
+		if err == nil {
+			return errIdentityLinked
+		}
+		if !errors.Is(err, profile.ErrNotFound) {
+			return err
+		}
+	}
+
+	_, err := profiles.LinkIdentity(ctx, ident.ID(), playerID)
+	if errors.Is(err, profile.ErrConflict) {
+		return errIdentityLinked
+	}
+	return err
 }
@@ -0,0 +1,108 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Config configures the connection to the Open Match frontend service.
+type Config struct {
+	// Endpoint is the host:port of the Open Match frontend service.
+	Endpoint string
+	// TLS enables TLS, verifying the server against CACert, or the system's roots if empty.
+	TLS    bool
+	CACert string
+	// ClientCert and ClientKey are the PEM files of the client certificate for mTLS. Setting them enables TLS.
+	ClientCert string
+	ClientKey  string
+	// ServerName overrides the name the server certificate is verified against.
+	ServerName string
+	// DialTimeout is how long NewOpenMatch waits for the connection before failing. It must be positive.
+	DialTimeout time.Duration
+	// KeepaliveTime is how often idle connections are pinged, and KeepaliveTimeout how long to wait for the reply
+	// before the connection is considered dead. Zero disables keepalive pings.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+}
+
+// DefaultConfig returns the configuration for the Open Match frontend service in the cluster.
+func DefaultConfig() Config {
+	return Config{
+		Endpoint:    "open-match-frontend.open-match.svc.cluster.local:50504",
+		DialTimeout: 10 * time.Second,
+		// Servers reject pings more frequent than every 5 minutes by default
+		KeepaliveTime:    5 * time.Minute,
+		KeepaliveTimeout: 20 * time.Second,
+	}
+}
+
+// dialOptions returns the options to dial Open Match with.
+func (cfg Config) dialOptions() ([]grpc.DialOption, error) {
+	creds, err := cfg.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// Fail fast on unreachable endpoints, instead of retrying until the first ticket is created
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError(),
+	}
+	if cfg.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    cfg.KeepaliveTime,
+			Timeout: cfg.KeepaliveTimeout,
+		}))
+	}
+	return opts, nil
+}
+
+func (cfg Config) credentials() (credentials.TransportCredentials, error) {
+	if !cfg.TLS && cfg.ClientCert == "" && cfg.ClientKey == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	tc := &tls.Config{ServerName: cfg.ServerName, MinVersion: tls.VersionTLS12}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("can't read Open Match CA certificate: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Open Match CA certificate %s", cfg.CACert)
+		}
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't load Open Match client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tc), nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+)
+
+var errLocalTicketDeleted = errors.New("ticket deleted")
+
+// ErrTooManyPlayers is returned for tickets of parties larger than a match, which could never be matched.
+var ErrTooManyPlayers = errors.New("more players than fit in a match")
+
+// Local is an in-memory Matchmaker, for local development and tests without Open Match. Tickets are queued in the
+// region they have the lowest ping to, and matched first come, first served once the region has enough players for
+// a match. Like the match function, parties take one slot per member and are never split. Matches are assigned the
+// configured servers in turn.
+type Local struct {
+	playersPerMatch int
+	servers         []*models.OMServerResponse
+
+	mu         sync.Mutex
+	lastID     int
+	lastMatch  int
+	nextServer int
+	tickets    map[string]*localTicket
+	queues     map[string][]*localTicket // region -> tickets waiting for a match, in order of creation
+}
+
+type localTicket struct {
+	id     string
+	region string
+	size   int
+	// done is closed once the ticket is assigned or deleted
+	done     chan struct{}
+	conn     *models.OMServerResponse
+	deleted  bool
+	assigned time.Time
+}
+
+// NewLocal returns a Local matchmaker for matches of playersPerMatch players, assigned to the servers (host:port).
+func NewLocal(playersPerMatch int, servers []string) (*Local, error) {
+	if playersPerMatch < 1 {
+		return nil, fmt.Errorf("players per match must be at least 1, got %d", playersPerMatch)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("at least one local server is required")
+	}
+
+	l := &Local{
+		playersPerMatch: playersPerMatch,
+		tickets:         map[string]*localTicket{},
+		queues:          map[string][]*localTicket{},
+	}
+	for _, s := range servers {
+		conn, err := hostPortToModel(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local server: %w", err)
+		}
+		l.servers = append(l.servers, conn)
+	}
+	return l, nil
+}
+
+// CreateTicket implements Matchmaker.
+func (l *Local) CreateTicket(_ context.Context, pr *models.PlayRequest, players []*profile.Player) (string, error) {
+	region, ok := closestRegion(pr)
+	if !ok {
+		return "", errors.New("no region to match in")
+	}
+	if len(players) > l.playersPerMatch {
+		return "", fmt.Errorf("%w: %d players, matches are of %d", ErrTooManyPlayers, len(players), l.playersPerMatch)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.forgetAssigned()
+
+	l.lastID++
+	t := &localTicket{
+		id:     fmt.Sprintf("local-%d", l.lastID),
+		region: region,
+		size:   len(players),
+		done:   make(chan struct{}),
+	}
+	l.tickets[t.id] = t
+	l.queues[region] = append(l.queues[region], t)
+	log.Printf("Ticket %s: queued locally in %s for %d players", t.id, region, t.size)
+
+	l.match(region)
+	return t.id, nil
+}
+
+// WaitForAssignment implements Matchmaker.
+func (l *Local) WaitForAssignment(ctx context.Context, tid string) (*models.OMServerResponse, error) {
+	l.mu.Lock()
+	t, ok := l.tickets[tid]
+	l.mu.Unlock()
+	if !ok {
+		return nil, ErrTicketNotFound
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.done:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.deleted {
+		return nil, errLocalTicketDeleted
+	}
+	return t.conn, nil
+}
+
+// DeleteTicket implements Matchmaker.
+func (l *Local) DeleteTicket(_ context.Context, tid string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.tickets[tid]
+	if !ok {
+		return nil
+	}
+	delete(l.tickets, tid)
+	if t.conn != nil {
+		return nil
+	}
+
+	queue := l.queues[t.region]
+	for i, q := range queue {
+		if q == t {
+			l.queues[t.region] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	t.deleted = true
+	close(t.done)
+	return nil
+}
+
+// TicketExists implements Matchmaker.
+func (l *Local) TicketExists(_ context.Context, tid string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.tickets[tid]
+	return ok, nil
+}
+
+// Close implements Matchmaker.
+func (l *Local) Close() error {
+	return nil
+}
+
+// Ready implements Matchmaker. The local matchmaker is always ready.
+func (l *Local) Ready(ctx context.Context) error {
+	return nil
+}
+
+// match makes as many matches as the region's queue allows. l.mu must be held.
+func (l *Local) match(region string) {
+	for {
+		// Fill the match in order of creation, skipping the parties that don't fit in the slots left
+		var matched, rest []*localTicket
+		filled := 0
+		for _, t := range l.queues[region] {
+			if filled+t.size > l.playersPerMatch {
+				rest = append(rest, t)
+				continue
+			}
+			matched = append(matched, t)
+			filled += t.size
+		}
+		if filled < l.playersPerMatch {
+			return
+		}
+		l.queues[region] = rest
+
+		server := l.servers[l.nextServer%len(l.servers)]
+		l.nextServer++
+		l.lastMatch++
+		conn := &models.OMServerResponse{IP: server.IP, Port: server.Port, MatchId: fmt.Sprintf("local-match-%d", l.lastMatch)}
+		for _, t := range matched {
+			log.Printf("Ticket %s: assigned locally to %s:%d", t.id, conn.IP, conn.Port)
+			t.conn = conn
+			t.assigned = time.Now()
+			close(t.done)
+		}
+	}
+}
+
+// forgetAssigned forgets assigned tickets once they can no longer be watched. l.mu must be held.
+func (l *Local) forgetAssigned() {
+	for id, t := range l.tickets {
+		if t.conn != nil && time.Since(t.assigned) > ticketTimeout+registryGrace {
+			delete(l.tickets, id)
+		}
+	}
+}
+
+// closestRegion returns the region with the lowest ping, picking the first by name on ties.
+func closestRegion(pr *models.PlayRequest) (string, bool) {
+	regions := make([]string, 0, len(pr.PingByRegion))
+	for region := range pr.PingByRegion {
+		regions = append(regions, region)
+	}
+	if len(regions) == 0 {
+		return "", false
+	}
+	sort.Strings(regions)
+
+	closest := regions[0]
+	for _, region := range regions[1:] {
+		if pr.PingByRegion[region] < pr.PingByRegion[closest] {
+			closest = region
+		}
+	}
+	return closest, true
+}
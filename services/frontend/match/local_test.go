@@ -0,0 +1,149 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func players(n int) []*profile.Player {
+	ps := make([]*profile.Player, n)
+	for i := range ps {
+		ps[i] = &profile.Player{}
+	}
+	return ps
+}
+
+func pingsTo(pingByRegion map[string]int32) *models.PlayRequest {
+	return &models.PlayRequest{PingByRegion: pingByRegion}
+}
+
+// assigned returns the assignment of the ticket, or nil if it isn't assigned right away.
+func assigned(t *testing.T, l *Local, tid string) *models.OMServerResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	conn, err := l.WaitForAssignment(ctx, tid)
+	if err != nil {
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		return nil
+	}
+	return conn
+}
+
+func TestNewLocal(t *testing.T) {
+	_, err := NewLocal(0, []string{"127.0.0.1:7777"})
+	assert.NotNil(t, err)
+	_, err = NewLocal(2, nil)
+	assert.NotNil(t, err)
+	_, err = NewLocal(2, []string{"127.0.0.1"})
+	assert.NotNil(t, err)
+}
+
+func TestLocalGroupsByRegion(t *testing.T) {
+	l, err := NewLocal(2, []string{"127.0.0.1:7777", "127.0.0.1:7778"})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	// Tickets are queued in the region with the lowest ping
+	us1, err := l.CreateTicket(ctx, pingsTo(map[string]int32{"us-central1": 20, "europe-west1": 100}), players(1))
+	assert.Nil(t, err)
+	eu1, err := l.CreateTicket(ctx, pingsTo(map[string]int32{"us-central1": 100, "europe-west1": 20}), players(1))
+	assert.Nil(t, err)
+	assert.Nil(t, assigned(t, l, us1))
+	assert.Nil(t, assigned(t, l, eu1))
+
+	us2, err := l.CreateTicket(ctx, pingsTo(map[string]int32{"us-central1": 50}), players(1))
+	assert.Nil(t, err)
+	conn := assigned(t, l, us1)
+	assert.NotNil(t, conn)
+	assert.Equal(t, conn, assigned(t, l, us2))
+	assert.Equal(t, 7777, conn.Port)
+	assert.Nil(t, assigned(t, l, eu1))
+
+	// Matches are assigned the servers in turn
+	eu2, err := l.CreateTicket(ctx, pingsTo(map[string]int32{"europe-west1": 50}), players(1))
+	assert.Nil(t, err)
+	conn = assigned(t, l, eu2)
+	assert.Equal(t, 7778, conn.Port)
+	assert.NotEqual(t, assigned(t, l, us1).MatchId, conn.MatchId)
+
+	_, err = l.CreateTicket(ctx, pingsTo(nil), players(1))
+	assert.NotNil(t, err)
+}
+
+func TestLocalKeepsPartiesTogether(t *testing.T) {
+	l, err := NewLocal(3, []string{"127.0.0.1:7777"})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	pr := pingsTo(map[string]int32{"us-central1": 20})
+
+	party1, err := l.CreateTicket(ctx, pr, players(2))
+	assert.Nil(t, err)
+	party2, err := l.CreateTicket(ctx, pr, players(2))
+	assert.Nil(t, err)
+	assert.Nil(t, assigned(t, l, party1))
+
+	// The second party doesn't fit next to the first, a player does
+	solo, err := l.CreateTicket(ctx, pr, players(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, assigned(t, l, party1))
+	assert.NotNil(t, assigned(t, l, solo))
+	assert.Nil(t, assigned(t, l, party2))
+
+	// Parties larger than a match are refused, as they could never be matched
+	_, err = l.CreateTicket(ctx, pr, players(4))
+	assert.ErrorIs(t, err, ErrTooManyPlayers)
+	l.mu.Lock()
+	assert.Len(t, l.queues["us-central1"], 1)
+	l.mu.Unlock()
+}
+
+func TestLocalDeleteTicket(t *testing.T) {
+	l, err := NewLocal(2, []string{"127.0.0.1:7777"})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	pr := pingsTo(map[string]int32{"us-central1": 20})
+
+	tid, err := l.CreateTicket(ctx, pr, players(1))
+	assert.Nil(t, err)
+	exists, _ := l.TicketExists(ctx, tid)
+	assert.True(t, exists)
+
+	assert.Nil(t, l.DeleteTicket(ctx, tid))
+	exists, _ = l.TicketExists(ctx, tid)
+	assert.False(t, exists)
+	_, err = l.WaitForAssignment(ctx, tid)
+	assert.ErrorIs(t, err, ErrTicketNotFound)
+
+	// Deleted tickets are no longer matched
+	t1, _ := l.CreateTicket(ctx, pr, players(1))
+	assert.Nil(t, assigned(t, l, t1))
+}
+
+func TestClosestRegion(t *testing.T) {
+	region, ok := closestRegion(pingsTo(map[string]int32{"us-central1": 40, "europe-west1": 20, "asia-east1": 20}))
+	assert.True(t, ok)
+	assert.Equal(t, "asia-east1", region)
+
+	_, ok = closestRegion(pingsTo(nil))
+	assert.False(t, ok)
+}
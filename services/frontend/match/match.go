@@ -12,105 +12,621 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Match creates/watches Open Match tickets for a PlayRequest
+// Match creates/watches matchmaking tickets for a PlayRequest, with Open Match or a local Matchmaker
 package match
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
-
-	"google.golang.org/grpc"
-	om "open-match.dev/open-match/pkg/pb"
+	"sync"
+	"time"
 
 	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
 )
 
 const (
-	// The endpoint for the Open Match Frontend service.
-	omFrontendEndpoint = "open-match-frontend.open-match.svc.cluster.local:50504"
+	// How long to wait for the matchmaker or the registry when cleaning up tickets in the background.
+	cleanupTimeout = 5 * time.Second
+
+	// How long to wait before watching a ticket again after its watch failed, doubling up to maxWatchBackoff.
+	watchBackoff    = 250 * time.Millisecond
+	maxWatchBackoff = 10 * time.Second
+
+	// The tier used for players whose profile can't be retrieved.
+	fallbackTier = "U"
 )
 
-// Matcher interfaces with Open Match to create a ticket and watch for an assignment.
+// errTicketLost is returned when the matchmaker no longer has a ticket that is still pending.
+var errTicketLost = errors.New("ticket no longer exists in the matchmaker")
+
+// ErrDraining is returned for searches while the frontend is shutting down. Clients retry them on another replica.
+var ErrDraining = errors.New("shutting down, try again")
+
+// DefaultFallbackSkill is the default skill of players whose profile can't be retrieved. It matches the initial
+// rating of the profile service.
+const DefaultFallbackSkill = 1500
+
+// Matcher creates tickets with a Matchmaker and watches for their assignment, keeping track of each player's ticket
+// and of the status of each ticket in the registry, so they can be polled and cancelled through any replica.
 type Matcher struct {
-	conn   *grpc.ClientConn
-	client om.FrontendServiceClient
+	mm            Matchmaker
+	registry      Registry
+	watches       *watches
+	policy        string
+	fallbackSkill int64
+	partyFinished func(partyID string, status models.TicketStatus)
+	done          chan struct{}
+	draining      chan struct{}
+	drainOnce     sync.Once
 }
 
-// NewMatcher returns a new Matcher. Close() should be deferred after a successful return.
-func NewMatcher() (*Matcher, error) {
-	conn, err := grpc.Dial(omFrontendEndpoint, grpc.WithInsecure())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Open Match: %w", err)
+// NewMatcher returns a new Matcher that matches players with the Matchmaker, and keeps each player to one active
+// ticket in the registry. The policy decides what happens when a player with an active ticket searches again, and
+// is one of ReuseActiveTicket or ReplaceActiveTicket. Players whose profile can't be retrieved are matched with the
+// fallback skill. The Matcher takes ownership of the Matchmaker.
+// Close() should be deferred after a successful return.
+func NewMatcher(mm Matchmaker, registry Registry, policy string, fallbackSkill int64) (*Matcher, error) {
+	if err := ValidateTicketPolicy(policy); err != nil {
+		return nil, err
+	}
+	m := &Matcher{
+		mm:            mm,
+		registry:      registry,
+		watches:       newWatches(),
+		policy:        policy,
+		fallbackSkill: fallbackSkill,
+		done:          make(chan struct{}),
+		draining:      make(chan struct{}),
 	}
-	return &Matcher{client: om.NewFrontendServiceClient(conn)}, nil
+	go m.maintainWatches()
+	return m, nil
 }
 
-// Close releases any resources of the Matcher.
+// HandlePartyTickets sets the function the final status of party tickets is passed to once they are assigned or
+// expire, by whichever replica watches them. It must be set before the first party search starts.
+func (m *Matcher) HandlePartyTickets(finished func(partyID string, status models.TicketStatus)) {
+	m.partyFinished = finished
+}
+
+// Close releases any resources of the Matcher. The tickets it watches are handed over to the other replicas.
 func (m *Matcher) Close() {
-	m.conn.Close()
+	close(m.done)
+	m.handOver()
+	m.mm.Close()
 }
 
-// FindMatchingServer takes a PlayRequest, constructs an Open Match ticket, and waits for an assignment.
-func (m *Matcher) FindMatchingServer(ctx context.Context, pr *models.PlayRequest) (*models.OMServerResponse, error) {
-	log.Printf("Creating Open Match ticket for /play request: %#v", pr)
+// Drain makes new searches fail with ErrDraining, so they are retried on another replica. Tickets already watched
+// in the background are handed over by Close.
+func (m *Matcher) Drain() {
+	m.drainOnce.Do(func() { close(m.draining) })
+}
 
-	req := &om.CreateTicketRequest{
-		Ticket: makeTicket(pr),
+// isDraining reports whether Drain was called.
+func (m *Matcher) isDraining() bool {
+	select {
+	case <-m.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// FallbackPlayer returns the player to matchmake with when their profile can't be retrieved.
+// Every call is counted, so the rate of fallbacks can be monitored.
+func (m *Matcher) FallbackPlayer(id string, err error) *profile.Player {
+	log.Printf("Player %s: using fallback skill %d and tier %q for matchmaking: %v", id, m.fallbackSkill, fallbackTier, err)
+	skillFallbacks.Inc()
+	return &profile.Player{Player_google_id: id, Skill_level: m.fallbackSkill, Tier: fallbackTier}
+}
+
+// Ready returns an error if the Matchmaker can't create tickets.
+func (m *Matcher) Ready(ctx context.Context) error {
+	return m.mm.Ready(ctx)
+}
+
+// StartSearch takes a PlayRequest and the player's profile, creates a ticket, and watches for
+// its assignment in the background. The returned pending ticket can be polled with TicketStatus.
+// If the player already has an active ticket, it is either returned as is or replaced, depending on the policy.
+func (m *Matcher) StartSearch(ctx context.Context, playerID string, pr *models.PlayRequest, p *profile.Player) (models.TicketStatus, error) {
+	if m.policy == ReuseActiveTicket {
+		status, ok, err := m.activeTicket(ctx, playerID)
+		if err != nil {
+			return models.TicketStatus{}, err
+		}
+		if ok {
+			log.Printf("Ticket %s: reused for player %s", status.TicketId, playerID)
+			return status, nil
+		}
 	}
-	resp, err := m.client.CreateTicket(ctx, req)
+
+	r, err := m.createTicket(ctx, pr, []*profile.Player{p}, []string{playerID}, "")
 	if err != nil {
-		log.Printf("CreateTicket failed for ticket %#v: %v", req.Ticket, err)
-		return nil, fmt.Errorf("CreateTicket failed: %w", err)
+		return models.TicketStatus{}, err
 	}
-	tid := resp.Id
-	log.Printf("Ticket %s: created: %v", tid, req.Ticket)
+	tid := r.Status.TicketId
 
-	stream, err := m.client.WatchAssignments(ctx, &om.WatchAssignmentsRequest{TicketId: tid})
-	for {
-		resp, err := stream.Recv()
+	switch m.policy {
+	case ReplaceActiveTicket:
+		prev, err := m.registry.Swap(ctx, playerID, tid, ticketTimeout+registryGrace)
+		if err != nil {
+			m.abortTicket(tid)
+			return models.TicketStatus{}, fmt.Errorf("can't register ticket: %w", err)
+		}
+		m.replaced(ctx, prev, tid)
+	default:
+		active, err := m.registry.Claim(ctx, playerID, tid, ticketTimeout+registryGrace)
+		if err != nil {
+			m.abortTicket(tid)
+			return models.TicketStatus{}, fmt.Errorf("can't register ticket: %w", err)
+		}
+		if active != tid {
+			// A concurrent search of the player registered its ticket first
+			m.abortTicket(tid)
+			return m.statusOr(ctx, active, playerID), nil
+		}
+	}
+
+	m.watch(r)
+	return r.Status, nil
+}
+
+// StartPartySearch creates a single ticket for all the members of a party, with the party's
+// PlayRequest and the members' profiles, and watches for its assignment in the background. The ticket becomes the
+// active ticket of every member, replacing the tickets they were searching with alone. Party tickets are
+// tracked by the party rather than polled with TicketStatus: their final status is passed to the function set with
+// HandlePartyTickets once they are assigned, expire or are cancelled.
+func (m *Matcher) StartPartySearch(ctx context.Context, partyID string, pr *models.PlayRequest, members []*profile.Player) (models.TicketStatus, error) {
+	playerIDs := make([]string, len(members))
+	for i, p := range members {
+		playerIDs[i] = p.Player_google_id
+	}
+
+	// Members stop searching alone first, so they can't be matched with themselves
+	for _, playerID := range playerIDs {
+		prev, err := m.registry.Get(ctx, playerID)
 		if err != nil {
-			log.Printf("Ticket %s: WatchAssignments failed: %v", tid, err)
-			return nil, fmt.Errorf("WatchAssignments failed: %w", err)
+			return models.TicketStatus{}, fmt.Errorf("can't look up active ticket: %w", err)
 		}
+		m.replaced(ctx, prev, "party "+partyID)
+	}
+
+	r, err := m.createTicket(ctx, pr, members, playerIDs, partyID)
+	if err != nil {
+		return models.TicketStatus{}, err
+	}
+	tid := r.Status.TicketId
 
-		omsr, err := hostPortToModel(resp.Assignment.Connection)
+	for _, playerID := range playerIDs {
+		prev, err := m.registry.Swap(ctx, playerID, tid, ticketTimeout+registryGrace)
 		if err != nil {
-			log.Printf("Ticket %s: can't parse connection: %v", tid, err)
-			return nil, fmt.Errorf("can't parse connection: %w", err)
+			m.abortTicket(tid)
+			return models.TicketStatus{}, fmt.Errorf("can't register ticket: %w", err)
+		}
+		m.replaced(ctx, prev, tid)
+	}
+
+	m.watch(r)
+	return r.Status, nil
+}
+
+// CancelTicket cancels a ticket created by StartPartySearch and deletes it from the matchmaker.
+func (m *Matcher) CancelTicket(ctx context.Context, tid string) error {
+	log.Printf("Ticket %s: cancelled by party", tid)
+	_, cancelErr := m.cancelTicket(ctx, tid, outcomeCancelled)
+	if err := m.mm.DeleteTicket(ctx, tid); err != nil {
+		log.Printf("Ticket %s: %v", tid, err)
+		return err
+	}
+	return cancelErr
+}
+
+// CancelSearch cancels the player's active ticket and deletes it from the matchmaker. Returns ErrTicketNotFound
+// if the player has no active ticket.
+func (m *Matcher) CancelSearch(ctx context.Context, playerID string) (models.TicketStatus, error) {
+	status, ok, err := m.activeTicket(ctx, playerID)
+	if err != nil {
+		return models.TicketStatus{}, err
+	}
+	if !ok {
+		return models.TicketStatus{}, ErrTicketNotFound
+	}
+
+	tid := status.TicketId
+	log.Printf("Ticket %s: cancelled by player", tid)
+	// The ticket may be watched by another replica, which stops once its watch fails or it next reports in
+	if _, err := m.cancelTicket(ctx, tid, outcomeCancelled); err != nil {
+		return models.TicketStatus{}, err
+	}
+	if err := m.mm.DeleteTicket(ctx, tid); err != nil {
+		log.Printf("Ticket %s: %v", tid, err)
+		return models.TicketStatus{}, err
+	}
+	m.release(playerID, tid)
+
+	return models.TicketStatus{TicketId: tid, Status: models.TicketCancelled}, nil
+}
+
+// TicketStatus returns the status of a ticket of the player, created through any replica. Returns
+// ErrTicketNotFound if the ticket is unknown or does not belong to the player.
+func (m *Matcher) TicketStatus(ctx context.Context, playerID, tid string) (models.TicketStatus, error) {
+	r, err := m.registry.GetTicket(ctx, tid)
+	if err != nil {
+		return models.TicketStatus{}, fmt.Errorf("can't look up ticket: %w", err)
+	}
+	if r == nil || !r.ownedBy(playerID) {
+		return models.TicketStatus{}, ErrTicketNotFound
+	}
+	if r.pending() {
+		m.ensureWatched(ctx, r, true)
+	}
+	return r.Status, nil
+}
+
+// EnsureWatched takes over the watch of the pending party ticket if the replica watching it went away. It is called
+// whenever the party is looked up, as party tickets aren't polled with TicketStatus.
+func (m *Matcher) EnsureWatched(ctx context.Context, tid string) {
+	r, err := m.registry.GetTicket(ctx, tid)
+	if err != nil {
+		log.Printf("Ticket %s: can't look up ticket: %v", tid, err)
+		return
+	}
+	if r != nil && r.pending() {
+		m.ensureWatched(ctx, r, false)
+	}
+}
+
+// activeTicket returns the status of the player's active ticket from the registry, if there is one.
+// Registry entries of tickets that are no longer pending, or no longer exist in the matchmaker, are released.
+func (m *Matcher) activeTicket(ctx context.Context, playerID string) (models.TicketStatus, bool, error) {
+	tid, err := m.registry.Get(ctx, playerID)
+	if err != nil {
+		return models.TicketStatus{}, false, fmt.Errorf("can't look up active ticket: %w", err)
+	}
+	if tid == "" {
+		return models.TicketStatus{}, false, nil
+	}
+
+	r, err := m.registry.GetTicket(ctx, tid)
+	if err != nil {
+		return models.TicketStatus{}, false, fmt.Errorf("can't look up active ticket: %w", err)
+	}
+	if r != nil {
+		if r.pending() {
+			return r.Status, true, nil
 		}
-		return omsr, nil
+		m.release(playerID, tid)
+		return models.TicketStatus{}, false, nil
 	}
+
+	// The record is gone, e.g. because the registry was restarted
+	exists, err := m.mm.TicketExists(ctx, tid)
+	if err != nil {
+		return models.TicketStatus{}, false, err
+	}
+	if !exists {
+		m.release(playerID, tid)
+		return models.TicketStatus{}, false, nil
+	}
+	return models.TicketStatus{TicketId: tid, Status: models.TicketPending}, true, nil
+}
+
+// statusOr returns the status of the player's ticket, or a pending status if it can't be looked up.
+func (m *Matcher) statusOr(ctx context.Context, tid, playerID string) models.TicketStatus {
+	if r, err := m.registry.GetTicket(ctx, tid); err == nil && r != nil && r.ownedBy(playerID) {
+		return r.Status
+	}
+	return models.TicketStatus{TicketId: tid, Status: models.TicketPending}
 }
 
-func hostPortToModel(hostPort string) (*models.OMServerResponse, error) {
-	pieces := strings.Split(hostPort, ":")
-	if len(pieces) != 2 {
-		return nil, fmt.Errorf("host:port %q has %d pieces, want 2", hostPort, len(pieces))
+// createTicket creates a ticket for the players, or the party, and records it as pending in the registry.
+func (m *Matcher) createTicket(ctx context.Context, pr *models.PlayRequest, players []*profile.Player, playerIDs []string, partyID string) (*TicketRecord, error) {
+	// Tickets created now would be handed over right away, so the search is retried on another replica instead
+	if m.isDraining() {
+		return nil, ErrDraining
 	}
-	port, err := strconv.Atoi(pieces[1])
+	created := time.Now()
+	tid, err := m.mm.CreateTicket(ctx, pr, players)
 	if err != nil {
-		return nil, fmt.Errorf("can't parse port of host:port %q as int: %w", hostPort, err)
+		return nil, err
+	}
+
+	r := &TicketRecord{
+		Status:    models.TicketStatus{TicketId: tid, Status: models.TicketPending},
+		PlayerIds: playerIDs,
+		PartyId:   partyID,
+		Region:    ticketRegion(pr),
+		Created:   created,
+		Updated:   created,
+		Polled:    created,
+		Watched:   created,
+	}
+	if _, err := m.registry.UpdateTicket(ctx, tid, func(*TicketRecord) *TicketRecord { return r }); err != nil {
+		m.deleteTicket(tid)
+		return nil, fmt.Errorf("can't record ticket: %w", err)
 	}
-	return &models.OMServerResponse{
-		IP:   pieces[0],
-		Port: port,
-	}, nil
+	return r, nil
 }
 
-func makeTicket(pr *models.PlayRequest) *om.Ticket {
-	t := &om.Ticket{
-		SearchFields: &om.SearchFields{
-			DoubleArgs: map[string]float64{
-				"skill": 0.0, // TODO: Add skill!
-			},
-		},
+// replaced cancels and deletes the previous active ticket of a player, if it isn't the ticket replacing it.
+func (m *Matcher) replaced(ctx context.Context, prev, by string) {
+	if prev == "" || prev == by {
+		return
 	}
-	// TODO: validate against known regions
-	for region, ping := range pr.PingByRegion {
-		t.SearchFields.DoubleArgs["latency-"+region] = float64(ping)
+	log.Printf("Ticket %s: replaced by %s", prev, by)
+	if _, err := m.cancelTicket(ctx, prev, outcomeCancelled); err != nil {
+		log.Printf("Ticket %s: %v", prev, err)
+	}
+	m.deleteTicket(prev)
+}
+
+// cancelTicket moves the pending ticket to cancelled and stops watching it, counting it with the outcome.
+// The ticket is released like a finished ticket. Reports whether the ticket was pending.
+func (m *Matcher) cancelTicket(ctx context.Context, tid, outcome string) (bool, error) {
+	r, err := m.registry.UpdateTicket(ctx, tid, func(r *TicketRecord) *TicketRecord {
+		if r == nil || !r.pending() {
+			return nil
+		}
+		r.Status.Status = models.TicketCancelled
+		r.Updated = time.Now()
+		return r
+	})
+	if err != nil {
+		return false, fmt.Errorf("can't cancel ticket: %w", err)
+	}
+	m.watches.stop(tid)
+	if r == nil {
+		return false, nil
+	}
+	ticketOutcomes.WithLabelValues(outcome).Inc()
+	m.finished(r)
+	return true, nil
+}
+
+// finish moves a pending ticket to its final status, and reports whether it did. Tickets that are no longer
+// pending, such as cancelled tickets, are left as they are.
+func (m *Matcher) finish(tid, status string, conn *models.OMServerResponse) (*TicketRecord, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	r, err := m.registry.UpdateTicket(ctx, tid, func(r *TicketRecord) *TicketRecord {
+		if r == nil || !r.pending() {
+			return nil
+		}
+		r.Status.Status = status
+		r.Status.Connection = conn
+		r.Updated = time.Now()
+		return r
+	})
+	if err != nil {
+		log.Printf("Ticket %s: can't record status %s: %v", tid, status, err)
+		return nil, false
+	}
+	return r, r != nil
+}
+
+// finished releases the ticket from the registry entries of its players, and passes the final status of a party
+// ticket to the party.
+func (m *Matcher) finished(r *TicketRecord) {
+	for _, playerID := range r.PlayerIds {
+		m.release(playerID, r.Status.TicketId)
+	}
+	if r.PartyId != "" && m.partyFinished != nil {
+		m.partyFinished(r.PartyId, r.Status)
+	}
+}
+
+// watch watches for the assignment of the ticket in the background, until it expires.
+func (m *Matcher) watch(r *TicketRecord) {
+	ctx, stop := context.WithDeadline(context.Background(), r.Created.Add(ticketTimeout))
+	m.watches.add(r.Status.TicketId, stop)
+	go m.watchTicket(ctx, stop, r)
+}
+
+// watchTicket waits for the ticket's assignment. If none arrives in time, or the matchmaker lost the ticket, the
+// ticket expires and is deleted. The final status is passed on, unless the ticket was cancelled: cancelled tickets are deleted and
+// cleaned up by whoever cancelled them. The time to the assignment is recorded by region, and the outcome by cause.
+func (m *Matcher) watchTicket(ctx context.Context, stop context.CancelFunc, r *TicketRecord) {
+	tid := r.Status.TicketId
+	defer m.watches.done(tid)
+	defer stop()
+
+	conn, err := m.waitForAssignment(ctx, tid)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// Cancelled, or handed over to another replica
+			return
+		}
+		// Only tickets whose deadline passed expired, lost tickets are errors of the watch
+		outcome := outcomeExpired
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Ticket %s: watch failed: %v", tid, err)
+			outcome = outcomeError
+		}
+		if r, ok := m.finish(tid, models.TicketExpired, nil); ok {
+			ticketOutcomes.WithLabelValues(outcome).Inc()
+			m.deleteTicket(tid)
+			m.finished(r)
+		}
+		return
+	}
+
+	if r, ok := m.finish(tid, models.TicketAssigned, conn); ok {
+		observeAssignment(r.Region, r.Created)
+		m.finished(r)
+	}
+}
+
+// waitForAssignment waits for the ticket's assignment until ctx is done. Failed waits, e.g. as the stream to Open
+// Match was reset, are retried with backoff while the matchmaker still has the ticket. Returns errTicketLost if
+// it doesn't.
+func (m *Matcher) waitForAssignment(ctx context.Context, tid string) (*models.OMServerResponse, error) {
+	backoff := watchBackoff
+	for {
+		conn, err := m.mm.WaitForAssignment(ctx, tid)
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+		exists, existsErr := m.mm.TicketExists(ctx, tid)
+		if existsErr == nil && !exists {
+			return nil, fmt.Errorf("%w: %v", errTicketLost, err)
+		}
+		log.Printf("Ticket %s: watching again in %s: %v", tid, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
+}
+
+// ensureWatched records that the pending ticket was polled, if it was, and takes over the watch for its assignment
+// if the replica watching it stopped reporting in, e.g. because it was shut down.
+func (m *Matcher) ensureWatched(ctx context.Context, r *TicketRecord, polled bool) {
+	tid := r.Status.TicketId
+	stale := func(r *TicketRecord) bool {
+		return !m.watches.has(tid) && time.Since(r.Watched) > ticketAbandonTimeout
+	}
+	if (!polled || time.Since(r.Polled) < pollResolution) && !stale(r) {
+		return
+	}
+
+	adopt := false
+	r, err := m.registry.UpdateTicket(ctx, tid, func(r *TicketRecord) *TicketRecord {
+		adopt = false
+		if r == nil || !r.pending() {
+			return nil
+		}
+		now := time.Now()
+		if adopt = stale(r); adopt {
+			r.Watched = now
+		}
+		if polled {
+			r.Polled = now
+		}
+		return r
+	})
+	if err != nil {
+		log.Printf("Ticket %s: can't record poll: %v", tid, err)
+		return
+	}
+	if r != nil && adopt {
+		log.Printf("Ticket %s: taking over the watch", tid)
+		m.watch(r)
+	}
+}
+
+// maintainWatches periodically reports in for the tickets this replica watches, and cancels and deletes the
+// pending tickets of players that stopped polling them, e.g. because the game was closed while searching.
+func (m *Matcher) maintainWatches() {
+	ticker := time.NewTicker(watchHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			for _, tid := range m.watches.ids() {
+				m.heartbeat(tid)
+			}
+		}
+	}
+}
+
+// heartbeat reports in for the watched ticket, or cancels it if it was abandoned. The watch is stopped if the
+// ticket was finished or cancelled through another replica.
+func (m *Matcher) heartbeat(tid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	abandoned := false
+	r, err := m.registry.UpdateTicket(ctx, tid, func(r *TicketRecord) *TicketRecord {
+		abandoned = false
+		if r == nil || !r.pending() {
+			return nil
+		}
+		now := time.Now()
+		if r.PartyId == "" && now.Sub(r.Polled) > ticketAbandonTimeout {
+			abandoned = true
+			r.Status.Status = models.TicketCancelled
+			r.Updated = now
+			return r
+		}
+		r.Watched = now
+		return r
+	})
+	if err != nil {
+		log.Printf("Ticket %s: can't report in: %v", tid, err)
+		return
+	}
+	if r == nil {
+		m.watches.stop(tid)
+		return
+	}
+	if abandoned {
+		log.Printf("Ticket %s: abandoned by player %s", tid, strings.Join(r.PlayerIds, ", "))
+		ticketOutcomes.WithLabelValues(outcomeAbandoned).Inc()
+		m.watches.stop(tid)
+		m.deleteTicket(tid)
+		m.finished(r)
+	}
+}
+
+// handOver stops watching tickets, and lets the other replicas take over their watches right away.
+func (m *Matcher) handOver() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	for _, tid := range m.watches.ids() {
+		m.watches.stop(tid)
+		_, err := m.registry.UpdateTicket(ctx, tid, func(r *TicketRecord) *TicketRecord {
+			if r == nil || !r.pending() {
+				return nil
+			}
+			r.Watched = time.Time{}
+			return r
+		})
+		if err != nil {
+			log.Printf("Ticket %s: can't hand over: %v", tid, err)
+		}
+	}
+}
+
+// abortTicket cancels and deletes a ticket that never became the player's active ticket.
+func (m *Matcher) abortTicket(tid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if _, err := m.cancelTicket(ctx, tid, outcomeCancelled); err != nil {
+		log.Printf("Ticket %s: %v", tid, err)
+	}
+	m.deleteTicket(tid)
+}
+
+// deleteTicket deletes the ticket from the matchmaker, so it can no longer be matched.
+func (m *Matcher) deleteTicket(tid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if err := m.mm.DeleteTicket(ctx, tid); err != nil {
+		log.Printf("Ticket %s: %v", tid, err)
+	}
+}
+
+// release clears the ticket from the registry, if it is still the player's active ticket.
+func (m *Matcher) release(playerID, tid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if err := m.registry.Release(ctx, playerID, tid); err != nil {
+		log.Printf("Ticket %s: can't release from registry: %v", tid, err)
 	}
-	return t
 }
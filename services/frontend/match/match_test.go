@@ -0,0 +1,377 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPlayRequest = &models.PlayRequest{PingByRegion: map[string]int32{"us-central1": 20, "europe-west1": 120}}
+
+// newTestReplicas returns Matchers that share a registry and a matchmaker, like frontend replicas sharing Redis and
+// Open Match.
+func newTestReplicas(t *testing.T, n, playersPerMatch int, policy string) []*Matcher {
+	mm, err := NewLocal(playersPerMatch, []string{"127.0.0.1:7777"})
+	assert.Nil(t, err)
+	registry := NewMemoryRegistry()
+
+	replicas := make([]*Matcher, n)
+	for i := range replicas {
+		m, err := NewMatcher(mm, registry, policy, DefaultFallbackSkill)
+		assert.Nil(t, err)
+		replicas[i] = m
+	}
+	return replicas
+}
+
+// failingMatchmaker is a Local matchmaker whose first waits for an assignment fail, like an Open Match stream that
+// was reset.
+type failingMatchmaker struct {
+	*Local
+	failures int32
+}
+
+func (f *failingMatchmaker) WaitForAssignment(ctx context.Context, tid string) (*models.OMServerResponse, error) {
+	if atomic.AddInt32(&f.failures, -1) >= 0 {
+		return nil, errors.New("stream reset")
+	}
+	return f.Local.WaitForAssignment(ctx, tid)
+}
+
+// newFailingMatcher returns a Matcher whose first waits for an assignment fail.
+func newFailingMatcher(t *testing.T, playersPerMatch int, failures int32) *Matcher {
+	mm, err := NewLocal(playersPerMatch, []string{"127.0.0.1:7777"})
+	assert.Nil(t, err)
+	m, err := NewMatcher(&failingMatchmaker{Local: mm, failures: failures}, NewMemoryRegistry(), ReuseActiveTicket, DefaultFallbackSkill)
+	assert.Nil(t, err)
+	return m
+}
+
+// outcomes returns the number of tickets counted with the outcome so far.
+func outcomes(outcome string) float64 {
+	return testutil.ToFloat64(ticketOutcomes.WithLabelValues(outcome))
+}
+
+func player(id string) *profile.Player {
+	return &profile.Player{Player_google_id: id, Skill_level: 1500, Tier: "U"}
+}
+
+// eventuallyStatus waits until the player's ticket has the status when polled through the Matcher.
+func eventuallyStatus(t *testing.T, m *Matcher, playerID, tid, status string) models.TicketStatus {
+	var s models.TicketStatus
+	assert.Eventually(t, func() bool {
+		var err error
+		s, err = m.TicketStatus(context.Background(), playerID, tid)
+		return err == nil && s.Status == status
+	}, 2*time.Second, 10*time.Millisecond)
+	return s
+}
+
+func TestMatcherSharesTicketStatus(t *testing.T) {
+	replicas := newTestReplicas(t, 2, 2, ReuseActiveTicket)
+	ctx := context.Background()
+
+	s1, err := replicas[0].StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.TicketPending, s1.Status)
+
+	// The ticket can be polled through the other replica, but only by its player
+	s, err := replicas[1].TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TicketPending, s.Status)
+	_, err = replicas[1].TicketStatus(ctx, "p2", s1.TicketId)
+	assert.ErrorIs(t, err, ErrTicketNotFound)
+
+	// The assignment seen by the replica watching the ticket is seen by the other replica
+	s2, err := replicas[1].StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	s = eventuallyStatus(t, replicas[1], "p1", s1.TicketId, models.TicketAssigned)
+	assert.Equal(t, 7777, s.Connection.Port)
+	eventuallyStatus(t, replicas[0], "p2", s2.TicketId, models.TicketAssigned)
+}
+
+func TestMatcherCancelsThroughOtherReplica(t *testing.T) {
+	replicas := newTestReplicas(t, 2, 2, ReuseActiveTicket)
+	ctx := context.Background()
+
+	s1, err := replicas[0].StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.True(t, replicas[0].watches.has(s1.TicketId))
+
+	s, err := replicas[1].CancelSearch(ctx, "p1")
+	assert.Nil(t, err)
+	assert.Equal(t, models.TicketCancelled, s.Status)
+	eventuallyStatus(t, replicas[0], "p1", s1.TicketId, models.TicketCancelled)
+
+	// The replica watching the ticket stops once it is deleted
+	assert.Eventually(t, func() bool { return !replicas[0].watches.has(s1.TicketId) }, time.Second, 10*time.Millisecond)
+	_, err = replicas[0].CancelSearch(ctx, "p1")
+	assert.ErrorIs(t, err, ErrTicketNotFound)
+}
+
+func TestMatcherTakesOverWatch(t *testing.T) {
+	replicas := newTestReplicas(t, 2, 2, ReuseActiveTicket)
+	ctx := context.Background()
+
+	s1, err := replicas[0].StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+
+	// Polls through a replica don't move a watch that is reported in
+	_, err = replicas[1].TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Nil(t, err)
+	assert.False(t, replicas[1].watches.has(s1.TicketId))
+
+	// The replica watching the ticket shuts down, the next poll takes over its watch
+	replicas[0].Close()
+	assert.False(t, replicas[0].watches.has(s1.TicketId))
+	_, err = replicas[1].TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Nil(t, err)
+	assert.True(t, replicas[1].watches.has(s1.TicketId))
+
+	_, err = replicas[1].StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	eventuallyStatus(t, replicas[1], "p1", s1.TicketId, models.TicketAssigned)
+}
+
+func TestMatcherOutcomes(t *testing.T) {
+	ctx := context.Background()
+
+	replicas := newTestReplicas(t, 2, 2, ReuseActiveTicket)
+	assigned := outcomes(outcomeAssigned)
+	s1, err := replicas[0].StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	_, err = replicas[0].StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	eventuallyStatus(t, replicas[0], "p1", s1.TicketId, models.TicketAssigned)
+	assert.Eventually(t, func() bool { return outcomes(outcomeAssigned) == assigned+2 }, time.Second, 10*time.Millisecond)
+
+	// Tickets expire once their deadline passed, here while their replica was away
+	expired := outcomes(outcomeExpired)
+	s3, err := replicas[0].StartSearch(ctx, "p3", testPlayRequest, player("p3"))
+	assert.Nil(t, err)
+	_, err = replicas[0].registry.UpdateTicket(ctx, s3.TicketId, func(r *TicketRecord) *TicketRecord {
+		r.Created = time.Now().Add(-ticketTimeout)
+		return r
+	})
+	assert.Nil(t, err)
+	replicas[0].Close()
+	eventuallyStatus(t, replicas[1], "p3", s3.TicketId, models.TicketExpired)
+	assert.Eventually(t, func() bool { return outcomes(outcomeExpired) == expired+1 }, time.Second, 10*time.Millisecond)
+
+	// Tickets the matchmaker lost before the deadline are errors
+	m := replicas[1]
+	failed := outcomes(outcomeError)
+	s4, err := m.StartSearch(ctx, "p4", testPlayRequest, player("p4"))
+	assert.Nil(t, err)
+	assert.Nil(t, m.mm.DeleteTicket(ctx, s4.TicketId))
+	eventuallyStatus(t, m, "p4", s4.TicketId, models.TicketExpired)
+	assert.Eventually(t, func() bool { return outcomes(outcomeError) == failed+1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, expired+1, outcomes(outcomeExpired))
+}
+
+func TestMatcherRewatchesFailedWaits(t *testing.T) {
+	m := newFailingMatcher(t, 2, 2)
+	ctx := context.Background()
+	failed := outcomes(outcomeError)
+
+	// The watch of a ticket the matchmaker still has outlives a reset stream
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&m.mm.(*failingMatchmaker).failures) < 0 }, 2*time.Second, 10*time.Millisecond)
+	s, err := m.TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TicketPending, s.Status)
+
+	_, err = m.StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	eventuallyStatus(t, m, "p1", s1.TicketId, models.TicketAssigned)
+	assert.Equal(t, failed, outcomes(outcomeError))
+}
+
+func TestMatcherAbandonedTickets(t *testing.T) {
+	replicas := newTestReplicas(t, 1, 2, ReuseActiveTicket)
+	m := replicas[0]
+	ctx := context.Background()
+
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+
+	// Polled tickets are kept
+	m.heartbeat(s1.TicketId)
+	s, _ := m.TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Equal(t, models.TicketPending, s.Status)
+
+	_, err = m.registry.UpdateTicket(ctx, s1.TicketId, func(r *TicketRecord) *TicketRecord {
+		r.Polled = time.Now().Add(-2 * ticketAbandonTimeout)
+		return r
+	})
+	assert.Nil(t, err)
+	m.heartbeat(s1.TicketId)
+	s, _ = m.TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Equal(t, models.TicketCancelled, s.Status)
+	assert.False(t, m.watches.has(s1.TicketId))
+	tid, _ := m.registry.Get(ctx, "p1")
+	assert.Equal(t, "", tid)
+}
+
+func TestMatcherPartyTickets(t *testing.T) {
+	replicas := newTestReplicas(t, 2, 3, ReuseActiveTicket)
+	ctx := context.Background()
+	finished := make(chan models.TicketStatus, 1)
+	for _, m := range replicas {
+		m.HandlePartyTickets(func(partyID string, status models.TicketStatus) {
+			assert.Equal(t, "party1", partyID)
+			finished <- status
+		})
+	}
+
+	// Members searching alone have their tickets replaced by the party's
+	solo, err := replicas[0].StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	s, err := replicas[0].StartPartySearch(ctx, "party1", testPlayRequest, []*profile.Player{player("p1"), player("p2")})
+	assert.Nil(t, err)
+	eventuallyStatus(t, replicas[1], "p2", solo.TicketId, models.TicketCancelled)
+
+	// Every member has the party ticket as their active ticket
+	for _, id := range []string{"p1", "p2"} {
+		tid, err := replicas[1].registry.Get(ctx, id)
+		assert.Nil(t, err)
+		assert.Equal(t, s.TicketId, tid)
+	}
+	reused, err := replicas[1].StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.Equal(t, s.TicketId, reused.TicketId)
+
+	// The assignment is passed to the party, and the members are released
+	_, err = replicas[1].StartSearch(ctx, "p3", testPlayRequest, player("p3"))
+	assert.Nil(t, err)
+	select {
+	case status := <-finished:
+		assert.Equal(t, s.TicketId, status.TicketId)
+		assert.Equal(t, models.TicketAssigned, status.Status)
+	case <-time.After(2 * time.Second):
+		t.Fatal("party ticket not finished")
+	}
+	for _, id := range []string{"p1", "p2"} {
+		tid, _ := replicas[1].registry.Get(ctx, id)
+		assert.Equal(t, "", tid)
+	}
+}
+
+func TestMatcherDrain(t *testing.T) {
+	m := newTestReplicas(t, 1, 2, ReuseActiveTicket)[0]
+	ctx := context.Background()
+
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	m.Drain()
+
+	// New searches are retried on another replica, searches in progress are still watched
+	_, err = m.StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.ErrorIs(t, err, ErrDraining)
+	_, err = m.StartPartySearch(ctx, "party", testPlayRequest, []*profile.Player{player("p2"), player("p3")})
+	assert.ErrorIs(t, err, ErrDraining)
+	assert.True(t, m.watches.has(s1.TicketId))
+	m.Drain()
+}
+
+func TestMatcherFallbackPlayer(t *testing.T) {
+	mm, err := NewLocal(2, []string{"127.0.0.1:7777"})
+	assert.Nil(t, err)
+	m, err := NewMatcher(mm, NewMemoryRegistry(), ReuseActiveTicket, 1200)
+	assert.Nil(t, err)
+	defer m.Close()
+
+	p := m.FallbackPlayer("p1", errors.New("profile service unavailable"))
+	assert.Equal(t, "p1", p.Player_google_id)
+	assert.Equal(t, int64(1200), p.Skill_level)
+	assert.Equal(t, fallbackTier, p.Tier)
+}
+
+func TestMatcherReusePolicy(t *testing.T) {
+	m := newTestReplicas(t, 1, 2, ReuseActiveTicket)[0]
+	ctx := context.Background()
+
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	s2, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.Equal(t, s1.TicketId, s2.TicketId)
+
+	// Tickets that are no longer pending aren't reused
+	_, err = m.CancelSearch(ctx, "p1")
+	assert.Nil(t, err)
+	s3, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, s1.TicketId, s3.TicketId)
+	tid, _ := m.registry.Get(ctx, "p1")
+	assert.Equal(t, s3.TicketId, tid)
+}
+
+func TestMatcherReplacePolicy(t *testing.T) {
+	m := newTestReplicas(t, 1, 2, ReplaceActiveTicket)[0]
+	ctx := context.Background()
+
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	s2, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, s1.TicketId, s2.TicketId)
+
+	// The replaced ticket is cancelled and deleted, so the player isn't matched with themselves
+	s, _ := m.TicketStatus(ctx, "p1", s1.TicketId)
+	assert.Equal(t, models.TicketCancelled, s.Status)
+	exists, _ := m.mm.TicketExists(ctx, s1.TicketId)
+	assert.False(t, exists)
+	s, _ = m.TicketStatus(ctx, "p1", s2.TicketId)
+	assert.Equal(t, models.TicketPending, s.Status)
+	tid, _ := m.registry.Get(ctx, "p1")
+	assert.Equal(t, s2.TicketId, tid)
+}
+
+func TestMatcherReleasesFinishedTickets(t *testing.T) {
+	m := newTestReplicas(t, 1, 2, ReuseActiveTicket)[0]
+	ctx := context.Background()
+
+	s1, err := m.StartSearch(ctx, "p1", testPlayRequest, player("p1"))
+	assert.Nil(t, err)
+	_, err = m.StartSearch(ctx, "p2", testPlayRequest, player("p2"))
+	assert.Nil(t, err)
+	eventuallyStatus(t, m, "p1", s1.TicketId, models.TicketAssigned)
+	assert.Eventually(t, func() bool {
+		tid, _ := m.registry.Get(ctx, "p1")
+		return tid == ""
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = m.CancelSearch(ctx, "p1")
+	assert.ErrorIs(t, err, ErrTicketNotFound)
+}
+
+func TestValidateTicketPolicy(t *testing.T) {
+	assert.Nil(t, ValidateTicketPolicy(ReuseActiveTicket))
+	assert.Nil(t, ValidateTicketPolicy(ReplaceActiveTicket))
+	assert.NotNil(t, ValidateTicketPolicy("queue"))
+	_, err := NewMatcher(nil, NewMemoryRegistry(), "queue", DefaultFallbackSkill)
+	assert.NotNil(t, err)
+}
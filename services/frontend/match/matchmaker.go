@@ -0,0 +1,64 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+)
+
+// Matchmaker backends, selectable at startup.
+const (
+	OpenMatchMatchmaker = "openmatch"
+	LocalMatchmaker     = "local"
+)
+
+// Matchmaker creates matchmaking tickets and assigns them game servers.
+type Matchmaker interface {
+	// CreateTicket creates a ticket for the players, who are matched together, returning its id.
+	CreateTicket(ctx context.Context, pr *models.PlayRequest, players []*profile.Player) (string, error)
+	// WaitForAssignment waits until the ticket is assigned a game server, returning the server to connect to
+	// and the id of the match.
+	// Returns an error if the ticket is deleted, or ctx is done first.
+	WaitForAssignment(ctx context.Context, tid string) (*models.OMServerResponse, error)
+	// DeleteTicket deletes the ticket, so it can no longer be matched.
+	DeleteTicket(ctx context.Context, tid string) error
+	// TicketExists reports whether the ticket exists, i.e. was created and not deleted yet.
+	TicketExists(ctx context.Context, tid string) (bool, error)
+	// Ready returns an error if the Matchmaker can't create tickets, e.g. because its backend can't be reached.
+	Ready(ctx context.Context) error
+	// Close releases any resources of the Matchmaker.
+	Close() error
+}
+
+func hostPortToModel(hostPort string) (*models.OMServerResponse, error) {
+	pieces := strings.Split(hostPort, ":")
+	if len(pieces) != 2 {
+		return nil, fmt.Errorf("host:port %q has %d pieces, want 2", hostPort, len(pieces))
+	}
+	port, err := strconv.Atoi(pieces[1])
+	if err != nil {
+		return nil, fmt.Errorf("can't parse port of host:port %q as int: %w", hostPort, err)
+	}
+	return &models.OMServerResponse{
+		IP:   pieces[0],
+		Port: port,
+	}, nil
+}
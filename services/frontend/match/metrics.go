@@ -0,0 +1,77 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of the tickets created by the frontend.
+const (
+	outcomeAssigned  = "assigned"
+	outcomeExpired   = "expired"
+	outcomeCancelled = "cancelled"
+	outcomeAbandoned = "abandoned"
+	outcomeError     = "error"
+)
+
+// unknownRegion labels the tickets of players without pings.
+const unknownRegion = "unknown"
+
+var (
+	// timeToAssignment is how long tickets wait from their creation to their assignment, by the region their players
+	// have the lowest ping to. Tickets wait up to ticketTimeout.
+	timeToAssignment = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "frontend",
+		Subsystem: "matchmaking",
+		Name:      "time_to_assignment_seconds",
+		Help:      "Time from the creation of a ticket to its assignment, by the region its players have the lowest ping to.",
+		Buckets:   []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600},
+	}, []string{"region"})
+
+	// ticketOutcomes counts the tickets that were assigned, expired, cancelled, abandoned, or whose watch failed.
+	ticketOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "frontend",
+		Subsystem: "matchmaking",
+		Name:      "tickets_total",
+		Help:      "Tickets created by the frontend, by outcome: assigned, expired, cancelled, abandoned or error.",
+	}, []string{"outcome"})
+
+	// skillFallbacks counts the tickets created with the fallback skill.
+	skillFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "frontend",
+		Subsystem: "matchmaking",
+		Name:      "skill_fallbacks_total",
+		Help:      "Players matchmade with the fallback skill and tier, because their profile couldn't be retrieved.",
+	})
+)
+
+// ticketRegion returns the region to label the metrics of a ticket with: the one its players have the lowest ping to.
+func ticketRegion(pr *models.PlayRequest) string {
+	if region, ok := closestRegion(pr); ok {
+		return region
+	}
+	return unknownRegion
+}
+
+// observeAssignment records the assignment of a ticket created at created.
+func observeAssignment(region string, created time.Time) {
+	timeToAssignment.WithLabelValues(region).Observe(time.Since(created).Seconds())
+	ticketOutcomes.WithLabelValues(outcomeAssigned).Inc()
+}
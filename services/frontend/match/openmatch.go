@@ -0,0 +1,206 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	om "open-match.dev/open-match/pkg/pb"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/profile"
+)
+
+// Assignment extension with the id of the match, set by the director.
+const matchIDExtension = "match_id"
+
+// OpenMatch is the Matchmaker backed by the Open Match frontend service.
+type OpenMatch struct {
+	conn   *grpc.ClientConn
+	client om.FrontendServiceClient
+}
+
+// NewOpenMatch returns an OpenMatch connected to the Open Match frontend service of the config. Returns an error
+// if Open Match can't be reached within the config's DialTimeout.
+func NewOpenMatch(cfg Config) (*OpenMatch, error) {
+	if cfg.DialTimeout <= 0 {
+		return nil, fmt.Errorf("dial timeout must be positive, got %v", cfg.DialTimeout)
+	}
+	opts, err := cfg.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, cfg.Endpoint, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Open Match at %s within %v: %w", cfg.Endpoint, cfg.DialTimeout, err)
+	}
+	return &OpenMatch{conn: conn, client: om.NewFrontendServiceClient(conn)}, nil
+}
+
+// DeleteTicket implements Matchmaker.
+func (o *OpenMatch) DeleteTicket(ctx context.Context, tid string) error {
+	if _, err := o.client.DeleteTicket(ctx, &om.DeleteTicketRequest{TicketId: tid}); err != nil {
+		return fmt.Errorf("DeleteTicket failed: %w", err)
+	}
+	return nil
+}
+
+// TicketExists implements Matchmaker.
+func (o *OpenMatch) TicketExists(ctx context.Context, tid string) (bool, error) {
+	if _, err := o.client.GetTicket(ctx, &om.GetTicketRequest{TicketId: tid}); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("GetTicket failed: %w", err)
+	}
+	return true, nil
+}
+
+// Ready implements Matchmaker. Open Match is ready while the connection is established, or idle and connecting on
+// the next call.
+func (o *OpenMatch) Ready(ctx context.Context) error {
+	switch state := o.conn.GetState(); state {
+	case connectivity.Ready:
+		return nil
+	case connectivity.Idle:
+		o.conn.Connect()
+		return nil
+	default:
+		return fmt.Errorf("connection to Open Match is %s", state)
+	}
+}
+
+// Close implements Matchmaker.
+func (o *OpenMatch) Close() error {
+	return o.conn.Close()
+}
+
+// CreateTicket implements Matchmaker.
+func (o *OpenMatch) CreateTicket(ctx context.Context, pr *models.PlayRequest, players []*profile.Player) (string, error) {
+	log.Printf("Creating Open Match ticket for /play request: %#v", pr)
+
+	t, err := makeTicket(pr, players)
+	if err != nil {
+		return "", err
+	}
+	req := &om.CreateTicketRequest{
+		Ticket: t,
+	}
+	resp, err := o.client.CreateTicket(ctx, req)
+	if err != nil {
+		log.Printf("CreateTicket failed for ticket %#v: %v", req.Ticket, err)
+		return "", fmt.Errorf("CreateTicket failed: %w", err)
+	}
+	log.Printf("Ticket %s: created: %v", resp.Id, req.Ticket)
+
+	return resp.Id, nil
+}
+
+// WaitForAssignment implements Matchmaker.
+func (o *OpenMatch) WaitForAssignment(ctx context.Context, tid string) (*models.OMServerResponse, error) {
+	stream, err := o.client.WatchAssignments(ctx, &om.WatchAssignmentsRequest{TicketId: tid})
+	if err != nil {
+		log.Printf("Ticket %s: WatchAssignments failed: %v", tid, err)
+		return nil, fmt.Errorf("WatchAssignments failed: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			log.Printf("Ticket %s: WatchAssignments failed: %v", tid, err)
+			return nil, fmt.Errorf("WatchAssignments failed: %w", err)
+		}
+
+		omsr, err := hostPortToModel(resp.Assignment.Connection)
+		if err != nil {
+			log.Printf("Ticket %s: can't parse connection: %v", tid, err)
+			return nil, fmt.Errorf("can't parse connection: %w", err)
+		}
+		omsr.MatchId = matchID(tid, resp.Assignment)
+		return omsr, nil
+	}
+}
+
+// makeTicket returns the ticket for the players, who are matched together. A party's skill is the average skill
+// of its members, and its tier the tier of its most skilled member. The member ids are carried in the "members"
+// extension, and the number of members in the "party_size" search field, so the match function keeps them together.
+func makeTicket(pr *models.PlayRequest, players []*profile.Player) (*om.Ticket, error) {
+	var skill float64
+	best := players[0]
+	ids := make([]interface{}, len(players))
+	for i, p := range players {
+		skill += float64(p.Skill_level)
+		if p.Skill_level > best.Skill_level {
+			best = p
+		}
+		ids[i] = p.Player_google_id
+	}
+
+	members, err := structpb.NewList(ids)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode members: %w", err)
+	}
+	membersAny, err := anypb.New(members)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode members: %w", err)
+	}
+
+	t := &om.Ticket{
+		SearchFields: &om.SearchFields{
+			DoubleArgs: map[string]float64{
+				"skill":      skill / float64(len(players)),
+				"party_size": float64(len(players)),
+			},
+			StringArgs: map[string]string{
+				"tier": best.Tier,
+			},
+		},
+		Extensions: map[string]*anypb.Any{
+			"members": membersAny,
+		},
+	}
+	// Regions and pings are validated by the handlers
+	for region, ping := range pr.PingByRegion {
+		t.SearchFields.DoubleArgs["latency-"+region] = float64(ping)
+	}
+	return t, nil
+}
+
+// matchID returns the id of the match the director put in the assignment, or "" if it has none.
+func matchID(tid string, a *om.Assignment) string {
+	ext, ok := a.GetExtensions()[matchIDExtension]
+	if !ok {
+		log.Printf("Ticket %s: assignment has no match id", tid)
+		return ""
+	}
+	id := &wrapperspb.StringValue{}
+	if err := ext.UnmarshalTo(id); err != nil {
+		log.Printf("Ticket %s: can't decode match id: %v", tid, err)
+		return ""
+	}
+	return id.GetValue()
+}
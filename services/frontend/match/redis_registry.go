@@ -0,0 +1,157 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Prefixes of the keys holding each player's active ticket, and each ticket's record.
+	redisKeyPrefix    = "frontend:active-ticket:"
+	redisTicketPrefix = "frontend:ticket:"
+	// How often UpdateTicket retries when the record is changed concurrently.
+	redisUpdateAttempts = 5
+)
+
+// Scripts run the check and the write in one step, so concurrent /play requests of a player across replicas
+// can't both win.
+var (
+	claimScript = redis.NewScript(`local cur = redis.call('GET', KEYS[1])
+if cur then return cur end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return ARGV[1]`)
+	swapScript = redis.NewScript(`local prev = redis.call('GET', KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return prev`)
+	releaseScript = redis.NewScript(`if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) end
+return 0`)
+	// Replaces KEYS[1] with ARGV[2] for ARGV[3] milliseconds if it still holds ARGV[1]. Returns 1 on success.
+	compareAndSwapScript = redis.NewScript(`if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then return 0 end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1`)
+)
+
+var errConcurrentUpdates = errors.New("ticket is updated too often, try again")
+
+// RedisRegistry is a Registry shared by all frontend replicas, so a player's active ticket is found regardless
+// of which replica serves the request.
+type RedisRegistry struct {
+	client redis.UniversalClient
+}
+
+// NewRedisRegistry returns a RedisRegistry that keeps active tickets in Redis.
+func NewRedisRegistry(client redis.UniversalClient) *RedisRegistry {
+	return &RedisRegistry{client: client}
+}
+
+// Get implements Registry.
+func (r *RedisRegistry) Get(ctx context.Context, playerID string) (string, error) {
+	tid, err := r.client.Get(ctx, redisKeyPrefix+playerID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GET failed: %w", err)
+	}
+	return tid, nil
+}
+
+// Claim implements Registry.
+func (r *RedisRegistry) Claim(ctx context.Context, playerID, tid string, ttl time.Duration) (string, error) {
+	active, err := claimScript.Run(ctx, r.client, []string{redisKeyPrefix + playerID}, tid, ttl.Milliseconds()).Text()
+	if err != nil {
+		return "", fmt.Errorf("redis claim failed: %w", err)
+	}
+	return active, nil
+}
+
+// Swap implements Registry.
+func (r *RedisRegistry) Swap(ctx context.Context, playerID, tid string, ttl time.Duration) (string, error) {
+	prev, err := swapScript.Run(ctx, r.client, []string{redisKeyPrefix + playerID}, tid, ttl.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis swap failed: %w", err)
+	}
+	return prev, nil
+}
+
+// Release implements Registry.
+func (r *RedisRegistry) Release(ctx context.Context, playerID, tid string) error {
+	if err := releaseScript.Run(ctx, r.client, []string{redisKeyPrefix + playerID}, tid).Err(); err != nil {
+		return fmt.Errorf("redis release failed: %w", err)
+	}
+	return nil
+}
+
+// GetTicket implements Registry.
+func (r *RedisRegistry) GetTicket(ctx context.Context, tid string) (*TicketRecord, error) {
+	v, err := r.getTicket(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTicket([]byte(v))
+}
+
+// UpdateTicket implements Registry.
+func (r *RedisRegistry) UpdateTicket(ctx context.Context, tid string, fn func(r *TicketRecord) *TicketRecord) (*TicketRecord, error) {
+	for i := 0; i < redisUpdateAttempts; i++ {
+		v, err := r.getTicket(ctx, tid)
+		if err != nil {
+			return nil, err
+		}
+		old, err := decodeTicket([]byte(v))
+		if err != nil {
+			return nil, err
+		}
+		record := fn(old)
+		if record == nil {
+			return nil, nil
+		}
+
+		nv, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := compareAndSwapScript.Run(ctx, r.client, []string{redisTicketPrefix + tid}, v, string(nv), record.retention().Milliseconds()).Int()
+		if err != nil {
+			return nil, fmt.Errorf("redis ticket update failed: %w", err)
+		}
+		if ok == 1 {
+			return record, nil
+		}
+	}
+	return nil, errConcurrentUpdates
+}
+
+// getTicket returns the encoded record of the ticket, or "" if there is none.
+func (r *RedisRegistry) getTicket(ctx context.Context, tid string) (string, error) {
+	v, err := r.client.Get(ctx, redisTicketPrefix+tid).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GET failed: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisRegistry(t *testing.T) (*RedisRegistry, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rc.Close() })
+	return NewRedisRegistry(rc), mr
+}
+
+func TestRedisRegistry(t *testing.T) {
+	r, mr := newTestRedisRegistry(t)
+	ctx := context.Background()
+
+	tid, err := r.Get(ctx, "p1")
+	assert.Nil(t, err)
+	assert.Equal(t, "", tid)
+
+	// The first claim wins
+	tid, err = r.Claim(ctx, "p1", "t1", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", tid)
+	tid, err = r.Claim(ctx, "p1", "t2", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", tid)
+
+	prev, err := r.Swap(ctx, "p1", "t3", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", prev)
+	prev, err = r.Swap(ctx, "p2", "t4", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "", prev)
+
+	// Stale tickets don't release newer ones
+	assert.Nil(t, r.Release(ctx, "p1", "t1"))
+	tid, _ = r.Get(ctx, "p1")
+	assert.Equal(t, "t3", tid)
+	assert.Nil(t, r.Release(ctx, "p1", "t3"))
+	tid, _ = r.Get(ctx, "p1")
+	assert.Equal(t, "", tid)
+
+	// Entries expire with their ticket
+	mr.FastForward(2 * time.Minute)
+	tid, _ = r.Get(ctx, "p2")
+	assert.Equal(t, "", tid)
+}
+
+func TestRedisRegistryTickets(t *testing.T) {
+	r, mr := newTestRedisRegistry(t)
+	testTicketRecords(t, r)
+
+	// Records are kept for their retention
+	assert.Equal(t, ticketRetention, mr.TTL(redisTicketPrefix+"t1"))
+	mr.FastForward(ticketRetention + time.Second)
+	record, err := r.GetTicket(context.Background(), "t1")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+}
+
+func TestRedisRegistryUnavailable(t *testing.T) {
+	r, mr := newTestRedisRegistry(t)
+	mr.Close()
+
+	_, err := r.Get(context.Background(), "p1")
+	assert.NotNil(t, err)
+	_, err = r.Claim(context.Background(), "p1", "t1", time.Minute)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// What StartSearch does when the player already has an active ticket.
+const (
+	// ReuseActiveTicket returns the player's active ticket instead of creating a new one.
+	ReuseActiveTicket = "reuse"
+	// ReplaceActiveTicket creates a new ticket and cancels the player's active ticket.
+	ReplaceActiveTicket = "replace"
+)
+
+// How long a registry entry outlives its ticket's timeout, in case the frontend that created it goes away.
+const registryGrace = time.Minute
+
+// Registry keeps track of each player's active ticket, so players can't flood Open Match with tickets, and of the
+// status of each ticket, so it can be polled through any frontend replica.
+type Registry interface {
+	// Get returns the player's active ticket id, or "" if there is none.
+	Get(ctx context.Context, playerID string) (string, error)
+	// Claim makes tid the player's active ticket for ttl, unless there already is one.
+	// Returns the player's active ticket id afterwards.
+	Claim(ctx context.Context, playerID, tid string, ttl time.Duration) (string, error)
+	// Swap makes tid the player's active ticket for ttl. Returns the previous active ticket id, or "" if there was none.
+	Swap(ctx context.Context, playerID, tid string, ttl time.Duration) (string, error)
+	// Release clears the player's active ticket, if it is still tid.
+	Release(ctx context.Context, playerID, tid string) error
+	// GetTicket returns the record of the ticket, or nil if there is none.
+	GetTicket(ctx context.Context, tid string) (*TicketRecord, error)
+	// UpdateTicket atomically replaces the record of the ticket with the result of fn, which is given a copy of the
+	// record, or nil if there is none. The record is kept for its retention. A nil result leaves the record as is.
+	// Returns the new record, or nil if it was left as is.
+	UpdateTicket(ctx context.Context, tid string, fn func(r *TicketRecord) *TicketRecord) (*TicketRecord, error)
+}
+
+// ValidateTicketPolicy returns an error if the policy isn't ReuseActiveTicket or ReplaceActiveTicket.
+func ValidateTicketPolicy(policy string) error {
+	switch policy {
+	case ReuseActiveTicket, ReplaceActiveTicket:
+		return nil
+	}
+	return fmt.Errorf("unknown active ticket policy %q, want %q or %q", policy, ReuseActiveTicket, ReplaceActiveTicket)
+}
+
+type registryEntry struct {
+	tid     string
+	expires time.Time
+}
+
+type ticketEntry struct {
+	record  []byte
+	expires time.Time
+}
+
+// MemoryRegistry is a Registry for a single frontend replica.
+type MemoryRegistry struct {
+	mu      sync.Mutex
+	entries map[string]registryEntry
+	tickets map[string]ticketEntry
+}
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{entries: map[string]registryEntry{}, tickets: map[string]ticketEntry{}}
+}
+
+// Get implements Registry.
+func (r *MemoryRegistry) Get(_ context.Context, playerID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.active(playerID), nil
+}
+
+// Claim implements Registry.
+func (r *MemoryRegistry) Claim(_ context.Context, playerID, tid string, ttl time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if active := r.active(playerID); active != "" {
+		return active, nil
+	}
+	r.entries[playerID] = registryEntry{tid: tid, expires: time.Now().Add(ttl)}
+	return tid, nil
+}
+
+// Swap implements Registry.
+func (r *MemoryRegistry) Swap(_ context.Context, playerID, tid string, ttl time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.active(playerID)
+	r.entries[playerID] = registryEntry{tid: tid, expires: time.Now().Add(ttl)}
+	return prev, nil
+}
+
+// Release implements Registry.
+func (r *MemoryRegistry) Release(_ context.Context, playerID, tid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[playerID]; ok && e.tid == tid {
+		delete(r.entries, playerID)
+	}
+	return nil
+}
+
+// GetTicket implements Registry.
+func (r *MemoryRegistry) GetTicket(_ context.Context, tid string) (*TicketRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return decodeTicket(r.ticket(tid))
+}
+
+// UpdateTicket implements Registry.
+func (r *MemoryRegistry) UpdateTicket(_ context.Context, tid string, fn func(r *TicketRecord) *TicketRecord) (*TicketRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Records are kept encoded, so fn can't change them other than through its result
+	old, err := decodeTicket(r.ticket(tid))
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		r.forgetExpiredTickets()
+	}
+	record := fn(old)
+	if record == nil {
+		return nil, nil
+	}
+	v, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	r.tickets[tid] = ticketEntry{record: v, expires: time.Now().Add(record.retention())}
+	return record, nil
+}
+
+// ticket returns the encoded record of the ticket, or nil if there is none. r.mu must be held.
+func (r *MemoryRegistry) ticket(tid string) []byte {
+	e, ok := r.tickets[tid]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	return e.record
+}
+
+// forgetExpiredTickets forgets the records past their retention. r.mu must be held.
+func (r *MemoryRegistry) forgetExpiredTickets() {
+	now := time.Now()
+	for tid, e := range r.tickets {
+		if now.After(e.expires) {
+			delete(r.tickets, tid)
+		}
+	}
+}
+
+// decodeTicket returns the record, or nil if it is empty.
+func decodeTicket(v []byte) (*TicketRecord, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	record := &TicketRecord{}
+	if err := json.Unmarshal(v, record); err != nil {
+		return nil, fmt.Errorf("can't decode ticket record: %w", err)
+	}
+	return record, nil
+}
+
+// active returns the player's unexpired ticket id, forgetting expired ones. r.mu must be held.
+func (r *MemoryRegistry) active(playerID string) string {
+	e, ok := r.entries[playerID]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(e.expires) {
+		delete(r.entries, playerID)
+		return ""
+	}
+	return e.tid
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTicketRecords checks the ticket records of a Registry.
+func testTicketRecords(t *testing.T, r Registry) {
+	ctx := context.Background()
+
+	record, err := r.GetTicket(ctx, "t1")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+
+	now := time.Now()
+	pending := &TicketRecord{
+		Status:    models.TicketStatus{TicketId: "t1", Status: models.TicketPending},
+		PlayerIds: []string{"p1"},
+		Created:   now,
+	}
+	record, err = r.UpdateTicket(ctx, "t1", func(old *TicketRecord) *TicketRecord {
+		assert.Nil(t, old)
+		return pending
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, pending, record)
+
+	// A nil result leaves the record as is
+	record, err = r.UpdateTicket(ctx, "t1", func(old *TicketRecord) *TicketRecord {
+		assert.Equal(t, []string{"p1"}, old.PlayerIds)
+		old.PlayerIds = nil
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+
+	_, err = r.UpdateTicket(ctx, "t1", func(old *TicketRecord) *TicketRecord {
+		old.Status.Status = models.TicketAssigned
+		return old
+	})
+	assert.Nil(t, err)
+	record, err = r.GetTicket(ctx, "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, models.TicketAssigned, record.Status.Status)
+	assert.Equal(t, []string{"p1"}, record.PlayerIds)
+	assert.True(t, record.ownedBy("p1"))
+	assert.False(t, record.ownedBy("p2"))
+}
+
+func TestMemoryRegistryTickets(t *testing.T) {
+	testTicketRecords(t, NewMemoryRegistry())
+}
+
+func TestTicketRecordRetention(t *testing.T) {
+	r := &TicketRecord{Status: models.TicketStatus{Status: models.TicketPending}, Created: time.Now()}
+	assert.InDelta(t, (ticketTimeout + ticketRetention).Seconds(), r.retention().Seconds(), 1)
+
+	// Finished tickets are kept for the retention only, as are pending tickets past their timeout
+	r.Status.Status = models.TicketExpired
+	assert.Equal(t, ticketRetention, r.retention())
+	r.Status.Status = models.TicketPending
+	r.Created = time.Now().Add(-time.Hour)
+	assert.Equal(t, ticketRetention, r.retention())
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	r := NewMemoryRegistry()
+	ctx := context.Background()
+
+	tid, err := r.Claim(ctx, "p1", "t1", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", tid)
+	tid, _ = r.Claim(ctx, "p1", "t2", time.Minute)
+	assert.Equal(t, "t1", tid)
+
+	prev, _ := r.Swap(ctx, "p1", "t3", time.Minute)
+	assert.Equal(t, "t1", prev)
+	assert.Nil(t, r.Release(ctx, "p1", "t1"))
+	tid, _ = r.Get(ctx, "p1")
+	assert.Equal(t, "t3", tid)
+	assert.Nil(t, r.Release(ctx, "p1", "t3"))
+	tid, _ = r.Get(ctx, "p1")
+	assert.Equal(t, "", tid)
+
+	// Expired entries can be claimed again
+	_, _ = r.Claim(ctx, "p2", "t4", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	tid, _ = r.Claim(ctx, "p2", "t5", time.Minute)
+	assert.Equal(t, "t5", tid)
+}
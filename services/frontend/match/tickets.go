@@ -0,0 +1,141 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package match
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+)
+
+const (
+	// How long a ticket waits for an assignment before it expires.
+	ticketTimeout = 10 * time.Minute
+	// How long the status of an assigned, expired or cancelled ticket can still be polled.
+	ticketRetention = 5 * time.Minute
+	// How long a pending ticket can go without being polled before its player is considered gone.
+	ticketAbandonTimeout = time.Minute
+	// How often the replica watching a ticket reports in. Another replica takes over the watch of a pending ticket
+	// that wasn't reported in for ticketAbandonTimeout, e.g. because its replica went away.
+	watchHeartbeat = ticketAbandonTimeout / 2
+	// How stale the time of the last poll of a ticket may get before a poll records it again.
+	pollResolution = 5 * time.Second
+)
+
+// ErrTicketNotFound is returned for tickets that don't exist, or belong to another player.
+var ErrTicketNotFound = errors.New("ticket not found")
+
+// TicketRecord is the status of a ticket, shared by all frontend replicas through the Registry.
+type TicketRecord struct {
+	Status models.TicketStatus `json:"status"`
+	// PlayerIds are the players the ticket was created for. PartyId is set for the tickets of parties, which are
+	// tracked by the party rather than polled by their players.
+	PlayerIds []string  `json:"playerIds"`
+	PartyId   string    `json:"partyId,omitempty"`
+	Region    string    `json:"region"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	// Polled is when the status was last polled, and Watched when the replica watching for the ticket's assignment
+	// last reported in
+	Polled  time.Time `json:"polled"`
+	Watched time.Time `json:"watched"`
+}
+
+// pending reports whether the ticket still waits for an assignment.
+func (r *TicketRecord) pending() bool {
+	return r.Status.Status == models.TicketPending
+}
+
+// ownedBy reports whether the ticket was created for the player, alone or with their party.
+func (r *TicketRecord) ownedBy(playerID string) bool {
+	for _, id := range r.PlayerIds {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// retention returns how long the registry keeps the record: until the ticket could have expired and its status
+// been retained, or for the retention once it is no longer pending.
+func (r *TicketRecord) retention() time.Duration {
+	if r.pending() {
+		if ttl := time.Until(r.Created.Add(ticketTimeout + ticketRetention)); ttl > ticketRetention {
+			return ttl
+		}
+	}
+	return ticketRetention
+}
+
+// watches are the tickets whose assignment this replica watches for.
+type watches struct {
+	mu    sync.Mutex
+	stops map[string]context.CancelFunc
+}
+
+func newWatches() *watches {
+	return &watches{stops: map[string]context.CancelFunc{}}
+}
+
+// add records the watch of the ticket, which is ended with stop.
+func (w *watches) add(tid string, stop context.CancelFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stops[tid] = stop
+}
+
+// stop ends the watch of the ticket, if this replica watches it.
+func (w *watches) stop(tid string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if stop, ok := w.stops[tid]; ok {
+		stop()
+		delete(w.stops, tid)
+	}
+}
+
+// done forgets the watch of the ticket once it ended.
+func (w *watches) done(tid string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.stops, tid)
+}
+
+// has reports whether this replica watches the ticket.
+func (w *watches) has(tid string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.stops[tid]
+	return ok
+}
+
+// ids returns the tickets this replica watches.
+func (w *watches) ids() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ids := make([]string, 0, len(w.stops))
+	for tid := range w.stops {
+		ids = append(ids, tid)
+	}
+	return ids
+}
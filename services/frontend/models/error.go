@@ -0,0 +1,27 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// ErrorResponse is the body of all error responses. Code is a stable, machine-readable error code, and Retryable tells
+// whether the same request may succeed later. RequestId is the id of the request, which is in the logs of all services
+// that handled it.
+type ErrorResponse struct {
+	Error     string       `json:"error"`
+	Code      string       `json:"code"`
+	Context   string       `json:"context"`
+	RequestId string       `json:"requestId,omitempty"`
+	Retryable bool         `json:"retryable"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// Party is a group of players that search for a match together, on a single ticket
+type Party struct {
+	PartyId string        `json:"partyId"`
+	Leader  string        `json:"leader"`
+	Members []PartyMember `json:"members"`
+	Ticket  *TicketStatus `json:"ticket,omitempty"`
+}
+
+// PartyMember is a player in a party, with the pings used for the party's ticket
+type PartyMember struct {
+	PlayerId     string           `json:"playerId"`
+	PingByRegion map[string]int32 `json:"pingByRegion"` // region -> ping time in milliseconds
+}
+
+// Member returns the party's member with the id, or nil
+func (p *Party) Member(playerId string) *PartyMember {
+	for i := range p.Members {
+		if p.Members[i].PlayerId == playerId {
+			return &p.Members[i]
+		}
+	}
+	return nil
+}
+
+// Searching reports whether the party's ticket is waiting for an assignment
+func (p *Party) Searching() bool {
+	return p.Ticket != nil && p.Ticket.Status == TicketPending
+}
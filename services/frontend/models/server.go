@@ -14,9 +14,32 @@
 
 package models
 
+import (
+	"fmt"
+	"sort"
+)
+
 type OMServerResponse struct {
-	IP   string
-	Port int
+	IP      string
+	Port    int
+	MatchId string `json:",omitempty"`
+}
+
+// Matchmaking ticket statuses
+const (
+	TicketPending   = "pending"
+	TicketAssigned  = "assigned"
+	TicketExpired   = "expired"
+	TicketCancelled = "cancelled"
+)
+
+// TicketStatus is the status of a player's matchmaking ticket, with the game server connection once assigned.
+// The join token admitting the player to the game server is minted per request, and never stored.
+type TicketStatus struct {
+	TicketId   string            `json:"ticketId"`
+	Status     string            `json:"status"`
+	Connection *OMServerResponse `json:"connection,omitempty"`
+	JoinToken  string            `json:"joinToken,omitempty"`
 }
 
 type PingServer struct {
@@ -31,3 +54,42 @@ type PingServer struct {
 type PlayRequest struct {
 	PingByRegion map[string]int32 `json:"pingByRegion"` // region -> ping time in milliseconds
 }
+
+// Pings sent by clients must be in this range. The game client pings with a 5 second timeout.
+const (
+	MinPing = 0
+	MaxPing = 5000
+)
+
+// FieldError describes why a field of a request is invalid
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Validate returns the problems with the request's pings, if any. Regions are only checked if known is not nil.
+func (pr *PlayRequest) Validate(known map[string]PingServer) []FieldError {
+	if len(pr.PingByRegion) == 0 {
+		return []FieldError{{Field: "pingByRegion", Reason: "at least one region is required"}}
+	}
+
+	// Report in a stable order
+	regions := make([]string, 0, len(pr.PingByRegion))
+	for region := range pr.PingByRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	var errs []FieldError
+	for _, region := range regions {
+		field := "pingByRegion." + region
+		if _, ok := known[region]; known != nil && !ok {
+			errs = append(errs, FieldError{Field: field, Reason: "unknown region"})
+			continue
+		}
+		if ping := pr.PingByRegion[region]; ping < MinPing || ping > MaxPing {
+			errs = append(errs, FieldError{Field: field, Reason: fmt.Sprintf("ping %d is not between %d and %d ms", ping, MinPing, MaxPing)})
+		}
+	}
+	return errs
+}
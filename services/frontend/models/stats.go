@@ -0,0 +1,29 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// GameServerStats is the end of game payload a game server submits for a single player.
+// Token is the player's own JWT, which identifies the player the stats belong to, and
+// OpponentTokens are the JWTs of the other players in the game.
+type GameServerStats struct {
+	GameId         string   `json:"GameId" binding:"required"`
+	Token          string   `json:"Token" binding:"required"`
+	Region         string   `json:"Region"`
+	Won            bool     `json:"Won"`
+	Score          int64    `json:"Score"`
+	Kills          int64    `json:"Kills"`
+	Deaths         int64    `json:"Deaths"`
+	OpponentTokens []string `json:"OpponentTokens"`
+}
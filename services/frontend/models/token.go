@@ -0,0 +1,46 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// RefreshRequest is the body of POST /token/refresh and POST /logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// CodeExchangeRequest is the body of POST /token/exchange
+type CodeExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// GuestRequest is the body of POST /guest. The device id is a random secret the client generates once, and keeps.
+type GuestRequest struct {
+	DeviceId string `json:"deviceId" binding:"required,min=32,max=256"`
+}
+
+// DeviceAuthorization is the response of POST /device/code
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationUri         string `json:"verificationUri"`
+	VerificationUriComplete string `json:"verificationUriComplete"`
+	// ExpiresIn is the lifetime of the codes, and Interval how often the device code may be polled, in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+	Interval  int64 `json:"interval"`
+}
+
+// DeviceTokenRequest is the body of POST /device/token
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"deviceCode" binding:"required"`
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Party lets players form a group that searches for a match together, on a single ticket
+package party
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+)
+
+var (
+	ErrPartyNotFound  = errors.New("party not found")
+	ErrNotMember      = errors.New("player is not a member of the party")
+	ErrNotLeader      = errors.New("only the party leader can do this")
+	ErrInOtherParty   = errors.New("player is already in another party")
+	ErrPartyFull      = errors.New("party is full")
+	ErrSearching      = errors.New("party is searching for a match")
+	ErrNotSearching   = errors.New("party is not searching for a match")
+	ErrPartyChanged   = errors.New("party changed while starting the search")
+	ErrNoCommonRegion = errors.New("party members have no region with a ping in common")
+)
+
+// Service manages parties in a Store. Players can be in one party at a time, which the Store enforces.
+type Service struct {
+	store   Store
+	maxSize int
+}
+
+// NewService returns a Service for parties of up to maxSize players.
+func NewService(store Store, maxSize int) *Service {
+	return &Service{store: store, maxSize: maxSize}
+}
+
+// Create creates a party led by the player.
+func (s *Service) Create(ctx context.Context, playerID string, pingByRegion map[string]int32) (*models.Party, error) {
+	id, err := newPartyID()
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Update(ctx, id, func(p *models.Party) (*models.Party, error) {
+		if p != nil {
+			return nil, fmt.Errorf("party id %s already taken", id)
+		}
+		return &models.Party{
+			PartyId: id,
+			Leader:  playerID,
+			Members: []models.PartyMember{{PlayerId: playerID, PingByRegion: pingByRegion}},
+		}, nil
+	})
+}
+
+// Get returns the party, if the player is a member.
+func (s *Service) Get(ctx context.Context, partyID, playerID string) (*models.Party, error) {
+	p, err := s.store.Get(ctx, partyID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Member(playerID) == nil {
+		return nil, ErrNotMember
+	}
+	return p, nil
+}
+
+// Join adds the player to the party. Members that join again update their pings.
+func (s *Service) Join(ctx context.Context, partyID, playerID string, pingByRegion map[string]int32) (*models.Party, error) {
+	return s.store.Update(ctx, partyID, func(p *models.Party) (*models.Party, error) {
+		if p == nil {
+			return nil, ErrPartyNotFound
+		}
+		if p.Searching() {
+			return nil, ErrSearching
+		}
+		if m := p.Member(playerID); m != nil {
+			m.PingByRegion = pingByRegion
+			return p, nil
+		}
+		if len(p.Members) >= s.maxSize {
+			return nil, ErrPartyFull
+		}
+		p.Members = append(p.Members, models.PartyMember{PlayerId: playerID, PingByRegion: pingByRegion})
+		return p, nil
+	})
+}
+
+// Leave removes the player from the party. The party is disbanded when its leader leaves. If the party was
+// searching, the search is cancelled and the id of its ticket is returned, so it can be deleted.
+func (s *Service) Leave(ctx context.Context, partyID, playerID string) (*models.Party, string, error) {
+	var cancelled string
+	p, err := s.store.Update(ctx, partyID, func(p *models.Party) (*models.Party, error) {
+		cancelled = ""
+		if p == nil {
+			return nil, ErrPartyNotFound
+		}
+		if p.Member(playerID) == nil {
+			return nil, ErrNotMember
+		}
+		if p.Searching() {
+			cancelled = p.Ticket.TicketId
+		}
+		if playerID == p.Leader {
+			return nil, nil
+		}
+
+		var members []models.PartyMember
+		for _, m := range p.Members {
+			if m.PlayerId != playerID {
+				members = append(members, m)
+			}
+		}
+		p.Members = members
+		if cancelled != "" {
+			p.Ticket.Status = models.TicketCancelled
+		}
+		return p, nil
+	})
+	return p, cancelled, err
+}
+
+// PrepareSearch returns the party if the player is its leader and it isn't searching already, so a ticket can be
+// created for it. The ticket is then recorded with SetTicket.
+func (s *Service) PrepareSearch(ctx context.Context, partyID, playerID string) (*models.Party, error) {
+	p, err := s.Get(ctx, partyID, playerID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Leader != playerID {
+		return nil, ErrNotLeader
+	}
+	if p.Searching() {
+		return nil, ErrSearching
+	}
+	return p, nil
+}
+
+// SetTicket records the ticket created for the party returned by PrepareSearch. Returns ErrPartyChanged if the
+// members changed or another search started in the meantime, in which case the ticket should be deleted.
+func (s *Service) SetTicket(ctx context.Context, prepared *models.Party, status models.TicketStatus) (*models.Party, error) {
+	return s.store.Update(ctx, prepared.PartyId, func(p *models.Party) (*models.Party, error) {
+		if p == nil || p.Searching() || !sameMembers(p, prepared) {
+			return nil, ErrPartyChanged
+		}
+		p.Ticket = &status
+		return p, nil
+	})
+}
+
+// FinishTicket records the final status of the party's ticket, if it is still the party's pending ticket.
+func (s *Service) FinishTicket(ctx context.Context, partyID string, status models.TicketStatus) error {
+	_, err := s.store.Update(ctx, partyID, func(p *models.Party) (*models.Party, error) {
+		if p == nil {
+			return nil, nil
+		}
+		if p.Searching() && p.Ticket.TicketId == status.TicketId {
+			p.Ticket = &status
+		}
+		return p, nil
+	})
+	return err
+}
+
+// CancelSearch cancels the party's search, returning the id of its ticket so it can be deleted.
+// Only the leader can cancel the search.
+func (s *Service) CancelSearch(ctx context.Context, partyID, playerID string) (*models.Party, string, error) {
+	var cancelled string
+	p, err := s.store.Update(ctx, partyID, func(p *models.Party) (*models.Party, error) {
+		if p == nil {
+			return nil, ErrPartyNotFound
+		}
+		if p.Member(playerID) == nil {
+			return nil, ErrNotMember
+		}
+		if p.Leader != playerID {
+			return nil, ErrNotLeader
+		}
+		if !p.Searching() {
+			return nil, ErrNotSearching
+		}
+		cancelled = p.Ticket.TicketId
+		p.Ticket.Status = models.TicketCancelled
+		return p, nil
+	})
+	return p, cancelled, err
+}
+
+// PlayRequest returns the pings to matchmake the party with: for each region all members have a ping for, the
+// worst ping of any member. This way no member ends up on a server with a worse ping than they reported.
+func PlayRequest(p *models.Party) (*models.PlayRequest, error) {
+	pr := &models.PlayRequest{PingByRegion: map[string]int32{}}
+	for region, ping := range p.Members[0].PingByRegion {
+		worst := ping
+		common := true
+		for _, m := range p.Members[1:] {
+			other, ok := m.PingByRegion[region]
+			if !ok {
+				common = false
+				break
+			}
+			if other > worst {
+				worst = other
+			}
+		}
+		if common {
+			pr.PingByRegion[region] = worst
+		}
+	}
+
+	if len(pr.PingByRegion) == 0 {
+		return nil, ErrNoCommonRegion
+	}
+	return pr, nil
+}
+
+func sameMembers(a, b *models.Party) bool {
+	if len(a.Members) != len(b.Members) {
+		return false
+	}
+	for _, m := range b.Members {
+		if a.Member(m.PlayerId) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newPartyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate party id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package party
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var pings = map[string]int32{"us-central1": 20, "europe-west1": 120}
+
+// testStores runs the test against each Store.
+func testStores(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryStore()) })
+	t.Run("redis", func(t *testing.T) { test(t, newTestRedisStore(t)) })
+}
+
+func TestPartyMembership(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		s := NewService(store, 2)
+		ctx := context.Background()
+
+		p, err := s.Create(ctx, "leader", pings)
+		assert.Nil(t, err)
+		assert.Equal(t, "leader", p.Leader)
+
+		_, err = s.Join(ctx, p.PartyId, "p2", pings)
+		assert.Nil(t, err)
+		_, err = s.Join(ctx, p.PartyId, "p3", pings)
+		assert.ErrorIs(t, err, ErrPartyFull)
+		_, err = s.Get(ctx, p.PartyId, "p3")
+		assert.ErrorIs(t, err, ErrNotMember)
+
+		// Players are in one party at a time
+		_, err = s.Create(ctx, "p2", pings)
+		assert.ErrorIs(t, err, ErrInOtherParty)
+		other, err := s.Create(ctx, "p3", pings)
+		assert.Nil(t, err)
+		_, err = s.Join(ctx, other.PartyId, "p2", pings)
+		assert.ErrorIs(t, err, ErrInOtherParty)
+
+		// Members that leave can join another party
+		p, _, err = s.Leave(ctx, p.PartyId, "p2")
+		assert.Nil(t, err)
+		assert.Len(t, p.Members, 1)
+		_, err = s.Join(ctx, other.PartyId, "p2", pings)
+		assert.Nil(t, err)
+
+		// The party is disbanded when its leader leaves
+		p, _, err = s.Leave(ctx, p.PartyId, "leader")
+		assert.Nil(t, err)
+		assert.Nil(t, p)
+		_, err = s.Create(ctx, "leader", pings)
+		assert.Nil(t, err)
+	})
+}
+
+func TestPartyConcurrentJoins(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		s := NewService(store, 3)
+		ctx := context.Background()
+
+		var parties []*models.Party
+		for _, leader := range []string{"l1", "l2", "l3", "l4"} {
+			p, err := s.Create(ctx, leader, pings)
+			assert.Nil(t, err)
+			parties = append(parties, p)
+		}
+
+		// The player joins all parties at once, and ends up in exactly one
+		var wg sync.WaitGroup
+		joined := make(chan string, len(parties))
+		for _, p := range parties {
+			wg.Add(1)
+			go func(partyID string) {
+				defer wg.Done()
+				if _, err := s.Join(ctx, partyID, "p", pings); err == nil {
+					joined <- partyID
+				} else {
+					assert.ErrorIs(t, err, ErrInOtherParty)
+				}
+			}(p.PartyId)
+		}
+		wg.Wait()
+		close(joined)
+
+		assert.Len(t, joined, 1)
+		partyID, err := store.PartyOf(ctx, "p")
+		assert.Nil(t, err)
+		assert.Equal(t, <-joined, partyID)
+	})
+}
+
+func TestPartySearch(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		s := NewService(store, 3)
+		ctx := context.Background()
+
+		p, err := s.Create(ctx, "leader", pings)
+		assert.Nil(t, err)
+		_, err = s.Join(ctx, p.PartyId, "p2", pings)
+		assert.Nil(t, err)
+
+		_, err = s.PrepareSearch(ctx, p.PartyId, "p2")
+		assert.ErrorIs(t, err, ErrNotLeader)
+		prepared, err := s.PrepareSearch(ctx, p.PartyId, "leader")
+		assert.Nil(t, err)
+
+		// The ticket isn't recorded if the members changed in the meantime
+		_, err = s.Join(ctx, p.PartyId, "p3", pings)
+		assert.Nil(t, err)
+		_, err = s.SetTicket(ctx, prepared, models.TicketStatus{TicketId: "t1", Status: models.TicketPending})
+		assert.ErrorIs(t, err, ErrPartyChanged)
+
+		prepared, err = s.PrepareSearch(ctx, p.PartyId, "leader")
+		assert.Nil(t, err)
+		p, err = s.SetTicket(ctx, prepared, models.TicketStatus{TicketId: "t1", Status: models.TicketPending})
+		assert.Nil(t, err)
+		assert.True(t, p.Searching())
+		_, err = s.Join(ctx, p.PartyId, "p4", pings)
+		assert.ErrorIs(t, err, ErrSearching)
+		_, err = s.PrepareSearch(ctx, p.PartyId, "leader")
+		assert.ErrorIs(t, err, ErrSearching)
+
+		// Only the final status of the party's current ticket is recorded
+		assert.Nil(t, s.FinishTicket(ctx, p.PartyId, models.TicketStatus{TicketId: "t0", Status: models.TicketExpired}))
+		p, _ = s.Get(ctx, p.PartyId, "p2")
+		assert.True(t, p.Searching())
+		conn := &models.OMServerResponse{IP: "127.0.0.1", Port: 7777}
+		assert.Nil(t, s.FinishTicket(ctx, p.PartyId, models.TicketStatus{TicketId: "t1", Status: models.TicketAssigned, Connection: conn}))
+		p, _ = s.Get(ctx, p.PartyId, "p2")
+		assert.Equal(t, models.TicketAssigned, p.Ticket.Status)
+		_, _, err = s.CancelSearch(ctx, p.PartyId, "leader")
+		assert.ErrorIs(t, err, ErrNotSearching)
+
+		// Members leaving cancel the search
+		prepared, _ = s.PrepareSearch(ctx, p.PartyId, "leader")
+		_, err = s.SetTicket(ctx, prepared, models.TicketStatus{TicketId: "t2", Status: models.TicketPending})
+		assert.Nil(t, err)
+		p, cancelled, err := s.Leave(ctx, p.PartyId, "p3")
+		assert.Nil(t, err)
+		assert.Equal(t, "t2", cancelled)
+		assert.Equal(t, models.TicketCancelled, p.Ticket.Status)
+	})
+}
+
+func TestPlayRequest(t *testing.T) {
+	p := &models.Party{Members: []models.PartyMember{
+		{PlayerId: "p1", PingByRegion: map[string]int32{"us-central1": 20, "europe-west1": 120, "asia-east1": 200}},
+		{PlayerId: "p2", PingByRegion: map[string]int32{"us-central1": 60, "europe-west1": 30}},
+	}}
+
+	// Regions all members pinged, with the worst ping
+	pr, err := PlayRequest(p)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int32{"us-central1": 60, "europe-west1": 120}, pr.PingByRegion)
+
+	p.Members = append(p.Members, models.PartyMember{PlayerId: "p3", PingByRegion: map[string]int32{"asia-east1": 50}})
+	_, err = PlayRequest(p)
+	assert.ErrorIs(t, err, ErrNoCommonRegion)
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package party
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Prefixes of the keys holding each party, and each player's party id.
+	redisPartyPrefix  = "frontend:party:"
+	redisMemberPrefix = "frontend:party-member:"
+
+	// Parties that aren't updated for this long are forgotten.
+	redisPartyTTL = 24 * time.Hour
+	// How often Update retries when the party is changed concurrently.
+	redisUpdateAttempts = 5
+)
+
+// Scripts run the check and the write in one step, so concurrent updates across replicas don't overwrite each other.
+var (
+	// Replaces the party KEYS[1] with ARGV[2] if it still holds ARGV[1], deleting it if ARGV[2] is empty, and
+	// moves the members along: the ARGV[5] member keys following KEYS[1] are pointed to the party ARGV[4], unless
+	// one of them is in another party, and the remaining member keys are cleared if they still point to the party.
+	// Returns 1 on success, 0 if the party changed, and -1 if a member is in another party.
+	updatePartyScript = redis.NewScript(`if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then return 0 end
+local n = tonumber(ARGV[5])
+for i = 2, n + 1 do
+  local current = redis.call('GET', KEYS[i])
+  if current and current ~= ARGV[4] then return -1 end
+end
+if ARGV[2] == '' then redis.call('DEL', KEYS[1]) else redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3]) end
+for i = 2, n + 1 do redis.call('SET', KEYS[i], ARGV[4], 'PX', ARGV[3]) end
+for i = n + 2, #KEYS do
+  if redis.call('GET', KEYS[i]) == ARGV[4] then redis.call('DEL', KEYS[i]) end
+end
+return 1`)
+)
+
+var errConcurrentUpdates = errors.New("party is updated too often, try again")
+
+// RedisStore is a Store shared by all frontend replicas, so party members can be served by any replica.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisStore returns a RedisStore that keeps parties in Redis.
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Get implements Store.
+func (s *RedisStore) Get(ctx context.Context, partyID string) (*models.Party, error) {
+	v, err := s.get(ctx, redisPartyPrefix+partyID)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode([]byte(v))
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrPartyNotFound
+	}
+	return p, nil
+}
+
+// PartyOf implements Store.
+func (s *RedisStore) PartyOf(ctx context.Context, playerID string) (string, error) {
+	return s.get(ctx, redisMemberPrefix+playerID)
+}
+
+// Update implements Store.
+func (s *RedisStore) Update(ctx context.Context, partyID string, fn func(p *models.Party) (*models.Party, error)) (*models.Party, error) {
+	ttl := redisPartyTTL.Milliseconds()
+	for i := 0; i < redisUpdateAttempts; i++ {
+		v, err := s.get(ctx, redisPartyPrefix+partyID)
+		if err != nil {
+			return nil, err
+		}
+		old, err := decode([]byte(v))
+		if err != nil {
+			return nil, err
+		}
+		oldMembers := memberIDs(old)
+		p, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+
+		var nv []byte
+		if p != nil {
+			if nv, err = json.Marshal(p); err != nil {
+				return nil, err
+			}
+		}
+		// The keys of the members, followed by those of the players that left
+		keys := []string{redisPartyPrefix + partyID}
+		for _, id := range memberIDs(p) {
+			keys = append(keys, redisMemberPrefix+id)
+		}
+		for _, id := range oldMembers {
+			if p == nil || p.Member(id) == nil {
+				keys = append(keys, redisMemberPrefix+id)
+			}
+		}
+		res, err := updatePartyScript.Run(ctx, s.client, keys, v, string(nv), ttl, partyID, len(memberIDs(p))).Int()
+		if err != nil {
+			return nil, fmt.Errorf("redis party update failed: %w", err)
+		}
+		switch res {
+		case 1:
+			return p, nil
+		case -1:
+			return nil, ErrInOtherParty
+		}
+	}
+	return nil, errConcurrentUpdates
+}
+
+// get returns the value of the key, or "" if there is none.
+func (s *RedisStore) get(ctx context.Context, key string) (string, error) {
+	v, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GET failed: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package party
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisStore(t *testing.T) *RedisStore {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rc.Close() })
+	return NewRedisStore(rc)
+}
+
+func TestRedisStore(t *testing.T) {
+	s := newTestRedisStore(t)
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "party1")
+	assert.ErrorIs(t, err, ErrPartyNotFound)
+
+	p, err := s.Update(ctx, "party1", func(p *models.Party) (*models.Party, error) {
+		assert.Nil(t, p)
+		return &models.Party{PartyId: "party1", Leader: "p1", Members: []models.PartyMember{{PlayerId: "p1"}, {PlayerId: "p2"}}}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", p.Leader)
+
+	p, err = s.Get(ctx, "party1")
+	assert.Nil(t, err)
+	assert.Len(t, p.Members, 2)
+	id, err := s.PartyOf(ctx, "p2")
+	assert.Nil(t, err)
+	assert.Equal(t, "party1", id)
+
+	// Members that leave are no longer in the party
+	_, err = s.Update(ctx, "party1", func(p *models.Party) (*models.Party, error) {
+		p.Members = p.Members[:1]
+		return p, nil
+	})
+	assert.Nil(t, err)
+	id, _ = s.PartyOf(ctx, "p2")
+	assert.Equal(t, "", id)
+
+	// Errors of fn leave the party as is
+	errTest := errors.New("test")
+	_, err = s.Update(ctx, "party1", func(p *models.Party) (*models.Party, error) { return nil, errTest })
+	assert.ErrorIs(t, err, errTest)
+	_, err = s.Get(ctx, "party1")
+	assert.Nil(t, err)
+
+	_, err = s.Update(ctx, "party1", func(p *models.Party) (*models.Party, error) { return nil, nil })
+	assert.Nil(t, err)
+	_, err = s.Get(ctx, "party1")
+	assert.ErrorIs(t, err, ErrPartyNotFound)
+	id, _ = s.PartyOf(ctx, "p1")
+	assert.Equal(t, "", id)
+}
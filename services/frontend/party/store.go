@@ -0,0 +1,137 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package party
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+)
+
+// Store keeps parties, and which party each player is in.
+type Store interface {
+	// Get returns the party, or ErrPartyNotFound.
+	Get(ctx context.Context, partyID string) (*models.Party, error)
+	// PartyOf returns the id of the player's party, or "" if they aren't in one.
+	PartyOf(ctx context.Context, playerID string) (string, error)
+	// Update atomically replaces the party with the result of fn, which is given a copy of the party, or nil
+	// if it doesn't exist. A nil result deletes the party. Errors of fn are returned as is. Players are in one party
+	// at a time: if the result has a member that is in another party, ErrInOtherParty is returned and nothing is
+	// changed.
+	Update(ctx context.Context, partyID string, fn func(p *models.Party) (*models.Party, error)) (*models.Party, error)
+}
+
+// MemoryStore is a Store for a single frontend replica.
+type MemoryStore struct {
+	mu      sync.Mutex
+	parties map[string][]byte
+	members map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{parties: map[string][]byte{}, members: map[string]string{}}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(_ context.Context, partyID string) (*models.Party, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := decode(s.parties[partyID])
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrPartyNotFound
+	}
+	return p, nil
+}
+
+// PartyOf implements Store.
+func (s *MemoryStore) PartyOf(_ context.Context, playerID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.members[playerID], nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(_ context.Context, partyID string, fn func(p *models.Party) (*models.Party, error)) (*models.Party, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Parties are kept encoded, so fn can't change them other than through its result
+	old, err := decode(s.parties[partyID])
+	if err != nil {
+		return nil, err
+	}
+	oldMembers := memberIDs(old)
+	p, err := fn(old)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range memberIDs(p) {
+		if current := s.members[id]; current != "" && current != partyID {
+			return nil, ErrInOtherParty
+		}
+	}
+
+	for _, id := range oldMembers {
+		if s.members[id] == partyID {
+			delete(s.members, id)
+		}
+	}
+	if p == nil {
+		delete(s.parties, partyID)
+		return nil, nil
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	s.parties[partyID] = b
+	for _, m := range p.Members {
+		s.members[m.PlayerId] = partyID
+	}
+	return p, nil
+}
+
+// memberIDs returns the ids of the party's members, if any.
+func memberIDs(p *models.Party) []string {
+	if p == nil {
+		return nil
+	}
+	ids := make([]string, len(p.Members))
+	for i, m := range p.Members {
+		ids[i] = m.PlayerId
+	}
+	return ids
+}
+
+// decode returns the encoded party, or nil if there is none.
+func decode(b []byte) (*models.Party, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	p := &models.Party{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
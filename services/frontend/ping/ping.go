@@ -0,0 +1,98 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Ping keeps the regions served by the ping discovery service, to validate the pings sent by clients against
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+)
+
+const (
+	// How long the list of ping servers is used before it is fetched again.
+	cacheTTL = time.Minute
+	// How long to wait for the ping discovery service.
+	fetchTimeout = 2 * time.Second
+)
+
+// Servers caches the ping servers per region from the ping discovery service's /list endpoint.
+type Servers struct {
+	endpoint string
+
+	mu      sync.Mutex
+	servers map[string]models.PingServer
+	fetched time.Time
+}
+
+// NewServers returns Servers for the ping discovery service at endpoint, e.g. http://ping-discovery.
+func NewServers(endpoint string) *Servers {
+	return &Servers{endpoint: endpoint}
+}
+
+// List returns the ping servers by region. The cached list is refreshed once it is older than cacheTTL; if the
+// ping discovery service can't be reached, the previous list is returned until it can.
+func (s *Servers) List(ctx context.Context) (map[string]models.PingServer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.servers != nil && time.Since(s.fetched) < cacheTTL {
+		return s.servers, nil
+	}
+
+	servers, err := s.fetch(ctx)
+	if err != nil {
+		if s.servers != nil {
+			log.Printf("Using ping servers fetched at %v: %v", s.fetched, err)
+			return s.servers, nil
+		}
+		return nil, err
+	}
+
+	s.servers = servers
+	s.fetched = time.Now()
+	return servers, nil
+}
+
+func (s *Servers) fetch(ctx context.Context) (map[string]models.PingServer, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch ping servers: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch ping servers, error code: %d", response.StatusCode)
+	}
+
+	var servers map[string]models.PingServer
+	if err := json.NewDecoder(response.Body).Decode(&servers); err != nil {
+		return nil, fmt.Errorf("unable to decode ping servers: %w", err)
+	}
+	return servers, nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profile is the client of the profile service API.
+package profile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
+)
+
+var (
+	// ErrNotFound is returned when the player or identity does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when the request conflicts with the current state, e.g. when linking an identity that
+	// is linked to another player
+	ErrConflict = errors.New("conflict")
+	// ErrBadRequest is returned when the profile service rejects the request as invalid
+	ErrBadRequest = errors.New("bad request")
+)
+
+// StatusError is returned when the profile service responds with an unexpected status code. It matches ErrNotFound,
+// ErrConflict and ErrBadRequest with errors.Is, according to its status code. Code and Message are those of the
+// error response, if any.
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("profile service: %s: status code %d: %s", e.Op, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("profile service: %s: status code %d", e.Op, e.StatusCode)
+}
+
+// Is reports whether the status code is the one of target.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Timeout reports whether the profile service timed out.
+func (e *StatusError) Timeout() bool {
+	return e.StatusCode == http.StatusGatewayTimeout
+}
+
+// Config configures the client of the profile service.
+type Config struct {
+	// BaseURL is the URL of the profile service.
+	BaseURL string
+	// Timeout is how long each attempt of a request may take.
+	Timeout time.Duration
+	// Retries is how often idempotent requests are retried after temporary failures, waiting Backoff before the first
+	// retry, and twice as long before each next one.
+	Retries int
+	Backoff time.Duration
+}
+
+// DefaultConfig returns the configuration for the profile service in the cluster.
+func DefaultConfig() Config {
+	return Config{
+		BaseURL: "http://profile",
+		Timeout: 5 * time.Second,
+		Retries: 2,
+		Backoff: 100 * time.Millisecond,
+	}
+}
+
+// Client calls the profile service API.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the profile service.
+func NewClient(cfg Config) *Client {
+	return &Client{cfg: cfg, httpClient: &http.Client{}}
+}
+
+// GetPlayer returns the player, or ErrNotFound.
+func (c *Client) GetPlayer(ctx context.Context, id string) (*Player, error) {
+	var p Player
+	if err := c.do(ctx, "get player", http.MethodGet, "/players/"+url.PathEscape(id), true, nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreatePlayer creates the player. Creating a player that already exists fails with ErrConflict. Creating players
+// isn't idempotent, so it isn't retried.
+func (c *Client) CreatePlayer(ctx context.Context, p *Player) error {
+	return c.do(ctx, "create player", http.MethodPost, "/players", false, p, nil)
+}
+
+// GetPlayerStats returns the stats, skill and tier of the player, or ErrNotFound.
+func (c *Client) GetPlayerStats(ctx context.Context, id string) (*Player, error) {
+	var p Player
+	if err := c.do(ctx, "get player stats", http.MethodGet, "/players/"+url.PathEscape(id)+"/stats", true, nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdatePlayerStats applies the outcome of a game to the player's stats, returning the updated stats. Each game is
+// only applied once, so this is retried.
+func (c *Client) UpdatePlayerStats(ctx context.Context, id string, stats *SingleGameStats) (*Player, error) {
+	var p Player
+	if err := c.do(ctx, "update player stats", http.MethodPut, "/players/"+url.PathEscape(id)+"/stats", true, stats, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPlayerGames returns a page of the player's game history, most recent first, or ErrNotFound.
+func (c *Client) GetPlayerGames(ctx context.Context, id string, limit, offset int64) (*PlayerGames, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.FormatInt(offset, 10))
+	}
+
+	var g PlayerGames
+	if err := c.do(ctx, "get player games", http.MethodGet, "/players/"+url.PathEscape(id)+"/matches?"+query.Encode(), true, nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GetIdentity returns the link of the identity to its player, or ErrNotFound.
+func (c *Client) GetIdentity(ctx context.Context, identity string) (*PlayerIdentity, error) {
+	var i PlayerIdentity
+	if err := c.do(ctx, "get identity", http.MethodGet, "/identities/"+url.PathEscape(identity), true, nil, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// LinkIdentity links the identity to the player. Returns ErrConflict if it is linked to another player, and
+// ErrNotFound if the player does not exist. Linking an identity again is a no-op, so this is retried.
+func (c *Client) LinkIdentity(ctx context.Context, identity, playerID string) (*PlayerIdentity, error) {
+	var i PlayerIdentity
+	if err := c.do(ctx, "link identity", http.MethodPost, "/identities", true, PlayerIdentity{Identity: identity, Player_google_id: playerID}, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// GetPlayerIdentities returns the identities linked to the player, oldest first, or ErrNotFound.
+func (c *Client) GetPlayerIdentities(ctx context.Context, id string) ([]PlayerIdentity, error) {
+	var identities []PlayerIdentity
+	if err := c.do(ctx, "get player identities", http.MethodGet, "/players/"+url.PathEscape(id)+"/identities", true, nil, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// do sends the request, encoding in as JSON body if set, and decoding the response into out if set. Idempotent
+// requests are retried with exponential backoff after network errors and temporary failures, as long as ctx allows.
+func (c *Client) do(ctx context.Context, op, method, path string, idempotent bool, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, op, method, path, body, out)
+		if err == nil || !idempotent || attempt >= c.cfg.Retries || !temporary(err) {
+			return err
+		}
+
+		// Full jitter, so retries of concurrent requests spread out
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, op, method, path string, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.BaseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	}
+	if id := shared.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(shared.RequestIDHeader, id)
+	}
+
+	response, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("profile service: %s: %w", op, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		se := &StatusError{Op: op, StatusCode: response.StatusCode}
+		var e models.ErrorResponse
+		if json.NewDecoder(response.Body).Decode(&e) == nil {
+			se.Code, se.Message = e.Code, e.Error
+		}
+		return se
+	}
+	if out != nil {
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			return fmt.Errorf("profile service: %s: can't decode response: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// temporary reports whether a failed request may succeed if retried
+func temporary(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	// Timeouts of single attempts are worth retrying, unlike requests whose context is done
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var oe *net.OpError
+	return errors.As(err, &oe)
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient returns a client of a profile service served by handler, and counts the requests it gets
+func newTestClient(t *testing.T, cfg Config, handler http.HandlerFunc) (*Client, *int32) {
+	t.Helper()
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	cfg.BaseURL = ts.URL
+	return NewClient(cfg), &requests
+}
+
+func testConfig() Config {
+	return Config{Timeout: time.Second, Retries: 2, Backoff: time.Millisecond}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestGetPlayer(t *testing.T) {
+	c, _ := newTestClient(t, testConfig(), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/players/a%2Fb", r.URL.RawPath)
+		assert.Equal(t, "req-1", r.Header.Get(shared.RequestIDHeader))
+		writeJSON(w, http.StatusOK, Player{Player_google_id: "a/b", Player_name: "Alice", Skill_level: 42})
+	})
+
+	// The id of the frontend's request is passed on
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = httptest.NewRequest(http.MethodGet, "/profile", nil)
+	gc.Request.Header.Set(shared.RequestIDHeader, "req-1")
+	shared.RequestID()(gc)
+
+	p, err := c.GetPlayer(gc.Request.Context(), "a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, "Alice", p.Player_name)
+	assert.Equal(t, int64(42), p.Skill_level)
+}
+
+func TestStatusErrors(t *testing.T) {
+	tests := []struct {
+		code     int
+		is       error
+		retryErr bool
+	}{
+		{code: http.StatusNotFound, is: ErrNotFound},
+		{code: http.StatusConflict, is: ErrConflict},
+		{code: http.StatusBadRequest, is: ErrBadRequest},
+		{code: http.StatusInternalServerError},
+		{code: http.StatusServiceUnavailable, retryErr: true},
+	}
+	for _, tt := range tests {
+		c, requests := newTestClient(t, testConfig(), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, tt.code, models.ErrorResponse{Error: "nope", Code: "some_code"})
+		})
+
+		_, err := c.GetPlayer(context.Background(), "p1")
+		var se *StatusError
+		if assert.ErrorAs(t, err, &se, tt.code) {
+			assert.Equal(t, tt.code, se.StatusCode)
+			assert.Equal(t, "some_code", se.Code)
+			assert.Equal(t, "nope", se.Message)
+		}
+		for _, target := range []error{ErrNotFound, ErrConflict, ErrBadRequest} {
+			assert.Equal(t, target == tt.is, errors.Is(err, target), "%d is %v", tt.code, target)
+		}
+
+		// Only temporary failures are retried
+		want := int32(1)
+		if tt.retryErr {
+			want = 3
+		}
+		assert.Equal(t, want, atomic.LoadInt32(requests), tt.code)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts int32
+	c, requests := newTestClient(t, testConfig(), func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			writeJSON(w, http.StatusBadGateway, models.ErrorResponse{Error: "unavailable"})
+			return
+		}
+		writeJSON(w, http.StatusOK, Player{Player_google_id: "p1"})
+	})
+
+	p, err := c.GetPlayer(context.Background(), "p1")
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", p.Player_google_id)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestNoRetriesOfCreate(t *testing.T) {
+	c, requests := newTestClient(t, testConfig(), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		writeJSON(w, http.StatusServiceUnavailable, models.ErrorResponse{Error: "unavailable"})
+	})
+
+	err := c.CreatePlayer(context.Background(), &Player{Player_google_id: "p1"})
+	var se *StatusError
+	assert.ErrorAs(t, err, &se)
+	assert.True(t, se.Temporary())
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	cfg := testConfig()
+	cfg.Retries, cfg.Backoff = 3, 20*time.Millisecond
+	c, requests := newTestClient(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		writeJSON(w, http.StatusServiceUnavailable, models.ErrorResponse{Error: "unavailable"})
+	})
+
+	_, err := c.GetPlayer(context.Background(), "p1")
+	assert.ErrorAs(t, err, new(*StatusError))
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests))
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Waits are jittered, but never longer than the backoff, which doubles
+	if assert.Len(t, times, 4) {
+		for i, max := range []time.Duration{20, 40, 80} {
+			assert.LessOrEqual(t, times[i+1].Sub(times[i]), max*time.Millisecond+50*time.Millisecond, i)
+		}
+	}
+}
+
+func TestBackoffStopsWithContext(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backoff = time.Hour
+	c, requests := newTestClient(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, models.ErrorResponse{Error: "unavailable"})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.GetPlayer(ctx, "p1")
+	assert.ErrorAs(t, err, new(*StatusError))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.LessOrEqual(t, atomic.LoadInt32(requests), int32(2))
+}
+
+func TestTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	cfg := testConfig()
+	cfg.Timeout = 20 * time.Millisecond
+	c, requests := newTestClient(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	// Attempts that time out are retried
+	_, err := c.GetPlayer(context.Background(), "p1")
+	var ne interface{ Timeout() bool }
+	if assert.ErrorAs(t, err, &ne) {
+		assert.True(t, ne.Timeout())
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+
+	// Requests whose context is done are not
+	atomic.StoreInt32(requests, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetPlayer(ctx, "p1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.LessOrEqual(t, atomic.LoadInt32(requests), int32(1))
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit limits how often clients can call unauthenticated endpoints, such as guest sign in.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
+)
+
+// ErrRateLimited is returned to clients that made too many requests
+var ErrRateLimited = errors.New("too many requests, try again later")
+
+// Limiter counts the requests of each key, e.g. a client IP, in fixed windows.
+type Limiter interface {
+	// Allow counts a request of the key, and reports whether it is within the limit of the window. If not, it also
+	// returns how long until the window ends.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// MemoryLimiter is a Limiter for a single frontend replica.
+type MemoryLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+}
+
+type memoryWindow struct {
+	count int
+	ends  time.Time
+}
+
+// NewMemoryLimiter returns a MemoryLimiter allowing limit requests per window and key.
+func NewMemoryLimiter(limit int, window time.Duration) *MemoryLimiter {
+	return &MemoryLimiter{limit: limit, window: window, windows: map[string]*memoryWindow{}}
+}
+
+// Allow implements Limiter.
+func (l *MemoryLimiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.ends) {
+		l.forgetEnded(now)
+		w = &memoryWindow{ends: now.Add(l.window)}
+		l.windows[key] = w
+	}
+	w.count++
+	if w.count > l.limit {
+		return false, w.ends.Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// forgetEnded forgets the windows that ended. l.mu must be held.
+func (l *MemoryLimiter) forgetEnded(now time.Time) {
+	for key, w := range l.windows {
+		if !now.Before(w.ends) {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// PerIP only passes requests on to the handler while their client IP is within the limit of the limiter. Others
+// get a 429 with a Retry-After header, and a 503 if the limiter fails.
+func PerIP(l Limiter, context string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, retryAfter, err := l.Allow(c.Request.Context(), context+":"+c.ClientIP())
+		if err != nil {
+			shared.HandleError(c, http.StatusServiceUnavailable, context, fmt.Errorf("can't check rate limit: %w", err))
+			return
+		}
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			shared.HandleErrorCode(c, http.StatusTooManyRequests, shared.CodeRateLimited, context, ErrRateLimited)
+			return
+		}
+		handler(c)
+	}
+}
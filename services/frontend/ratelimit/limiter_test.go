@@ -0,0 +1,112 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLimiters runs the test against both limiters, allowing limit requests per window. advance moves the clock of
+// the limiter past the window.
+func testLimiters(t *testing.T, limit int, window time.Duration, test func(t *testing.T, l Limiter, advance func())) {
+	t.Run("memory", func(t *testing.T) {
+		l := NewMemoryLimiter(limit, window)
+		test(t, l, func() {
+			for _, w := range l.windows {
+				w.ends = time.Now()
+			}
+		})
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer rc.Close()
+		test(t, NewRedisLimiter(rc, limit, window), func() { mr.FastForward(window) })
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	testLimiters(t, 2, time.Minute, func(t *testing.T, l Limiter, advance func()) {
+		ctx := context.Background()
+
+		for i := 0; i < 2; i++ {
+			ok, _, err := l.Allow(ctx, "a")
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+		ok, retryAfter, err := l.Allow(ctx, "a")
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.InDelta(t, time.Minute.Seconds(), retryAfter.Seconds(), 1)
+
+		// Keys are limited separately
+		ok, _, err = l.Allow(ctx, "b")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// The limit resets with the next window
+		advance()
+		ok, _, err = l.Allow(ctx, "a")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func TestPerIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/guest", PerIP(NewMemoryLimiter(1, time.Minute), "guest login", func(c *gin.Context) { c.Status(http.StatusOK) }))
+	r.POST("/failing", PerIP(failingLimiter{}, "guest login", func(c *gin.Context) { c.Status(http.StatusOK) }))
+
+	post := func(path, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, post("/guest", "10.0.0.1").Code)
+	w := post("/guest", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	var resp models.ErrorResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "rate_limited", resp.Code)
+	assert.True(t, resp.Retryable)
+
+	// Other clients have their own limit
+	assert.Equal(t, http.StatusOK, post("/guest", "10.0.0.2").Code)
+
+	assert.Equal(t, http.StatusServiceUnavailable, post("/failing", "10.0.0.1").Code)
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Prefix of the keys counting the requests of each key in the current window.
+const redisLimitPrefix = "frontend:ratelimit:"
+
+// Counts a request of KEYS[1], starting a window of ARGV[1] milliseconds with the first one. Returns the count, and
+// the milliseconds left in the window.
+var countScript = redis.NewScript(`local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return {n, redis.call('PTTL', KEYS[1])}`)
+
+// RedisLimiter is a Limiter shared by all frontend replicas, so clients get the same limit whichever replica serves
+// them.
+type RedisLimiter struct {
+	client redis.UniversalClient
+	limit  int
+	window time.Duration
+}
+
+// NewRedisLimiter returns a RedisLimiter allowing limit requests per window and key.
+func NewRedisLimiter(client redis.UniversalClient, limit int, window time.Duration) *RedisLimiter {
+	return &RedisLimiter{client: client, limit: limit, window: window}
+}
+
+// Allow implements Limiter.
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	res, err := countScript.Run(ctx, l.client, []string{redisLimitPrefix + key}, l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("redis rate limit count failed: %w", err)
+	}
+	if res[0] > int64(l.limit) {
+		return false, time.Duration(res[1]) * time.Millisecond, nil
+	}
+	return true, 0, nil
+}
@@ -15,38 +15,49 @@
 package auth
 
 import (
-	"fmt"
-	"log"
+	"crypto/subtle"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/shared"
 )
 
+// Leeway for the clocks of the frontend replicas being out of sync.
+const clockSkew = 30 * time.Second
+
 type Claims struct {
-	Id string `json:"id"`
+	Id        string `json:"id"`
+	SessionId string `json:"sid,omitempty"`
+	// Guest is set on the tokens of guest sessions
+	Guest bool `json:"guest,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(id string, days int) (string, error) {
-	expirationTime := time.Now().Add(24 * 31 * time.Hour)
+// GenerateJWT returns an access token of the player's session, valid for ttl
+func GenerateJWT(id, sessionID string, guest bool, ttl time.Duration) (string, error) {
+	now := time.Now()
 
-	// Create the JWT claims, which includes google's profile id and expiry time
+	// Create the JWT claims, which includes the player id, the session and expiry time
 	claims := &Claims{
-		Id: id,
+		Id:        id,
+		SessionId: sessionID,
+		Guest:     guest,
 		RegisteredClaims: jwt.RegisteredClaims{
-			// In JWT, the expiry time is expressed as unix milliseconds
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_KEY")))
+	// Sign with the current signing key of the KeySet
+	ks, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+	tokenString, err := ks.sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -54,6 +65,61 @@ func GenerateJWT(id string, days int) (string, error) {
 	return tokenString, nil
 }
 
+// ErrInvalidToken is returned by ParseJWT when a token parses but is not valid
+var ErrInvalidToken = errors.New("invalid token")
+
+// ParseJWT parses and validates a JWT issued by GenerateJWT, returning its claims.
+// This returns an error if the token is invalid (if it has expired according to the
+// expiry time we set on sign in), if its key is unknown or retired, or if the signature does not match.
+func ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	ks, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	tkn, err := jwt.ParseWithClaims(tokenString, claims, ks.keyFunc)
+	if err != nil {
+		// Accept tokens that only failed the time checks within the clock skew of the replicas
+		var verr *jwt.ValidationError
+		if !errors.As(err, &verr) || verr.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorIssuedAt) != 0 || !withinSkew(claims) {
+			return nil, err
+		}
+	} else if !tkn.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// withinSkew reports whether the token's time claims are valid, allowing for clockSkew.
+func withinSkew(c *Claims) bool {
+	now := time.Now()
+	return c.VerifyExpiresAt(now.Add(-clockSkew), true) && c.VerifyIssuedAt(now.Add(clockSkew), false)
+}
+
+// ParseExpiredJWT is like ParseJWT, but also accepts tokens that expired less than grace ago. This is for tokens
+// relayed by game servers, which clients keep for their whole play session.
+func ParseExpiredJWT(tokenString string, grace time.Duration) (*Claims, error) {
+	claims := &Claims{}
+
+	ks, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(tokenString, claims, ks.keyFunc); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Add(-grace), true) {
+		return nil, jwt.ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// VerifyJWT only calls the endpoint handler for requests with a valid access token of an active session. Sessions
+// must be configured with UseSessions.
 func VerifyJWT(endpointHandler func(id string, c *gin.Context)) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		prefix := "Bearer "
@@ -61,41 +127,50 @@ func VerifyJWT(endpointHandler func(id string, c *gin.Context)) gin.HandlerFunc
 		reqToken := strings.TrimPrefix(authHeader, prefix)
 
 		if len(reqToken) != 0 {
-			claims := &Claims{}
-
-			// Parse the JWT string and store the result in `claims`.
-			// Note that we are passing the key in this method as well. This method will return an error
-			// if the token is invalid (if it has expired according to the expiry time we set on sign in),
-			// or if the signature does not match
-			tkn, err := jwt.ParseWithClaims(reqToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_KEY")), nil
-			})
+			claims, err := ParseJWT(reqToken)
 			if err != nil {
-				log.Println(err.Error())
-				if err == jwt.ErrSignatureInvalid {
-					c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "context": "auth"})
+				// Expired tokens get a 401 too, with their own code so clients know to refresh them
+				if errors.Is(err, jwt.ErrTokenExpired) {
+					shared.HandleErrorCode(c, http.StatusUnauthorized, shared.CodeTokenExpired, "auth", err)
 					return
 				}
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "context": "auth"})
+				if errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrUnknownKey) {
+					shared.HandleError(c, http.StatusUnauthorized, "auth", err)
+					return
+				}
+				shared.HandleError(c, http.StatusBadRequest, "auth", err)
+				return
+			}
+
+			// Tokens of sessions that ended are no longer accepted
+			sessions := currentSessions()
+			if sessions == nil {
+				shared.HandleError(c, http.StatusInternalServerError, "auth", errors.New("sessions are not configured"))
 				return
 			}
-			if !tkn.Valid {
-				log.Println("Invalid token")
-				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "context": "auth"})
+			if HandleSessionError(c, "auth", sessions.Check(c.Request.Context(), claims)) {
 				return
 			}
 
 			endpointHandler(claims.Id, c)
 		} else {
-			err := fmt.Errorf("authorization token is not present")
-			log.Println(err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "context": "auth"})
+			shared.HandleError(c, http.StatusUnauthorized, "auth", errors.New("authorization token is not present"))
 			return
 		}
 	})
 }
 
-func VerifyApiKey(endpointHandler func(c *gin.Context)) gin.HandlerFunc {
+// HandleSessionError responds to errors of Sessions.Check: revoked sessions get a 401, and failures of the session
+// store a 503. Returns true if there was an error.
+func HandleSessionError(c *gin.Context, context string, err error) bool {
+	if errors.Is(err, ErrSessionRevoked) {
+		return shared.HandleErrorCode(c, http.StatusUnauthorized, shared.CodeSessionRevoked, context, err)
+	}
+	return shared.HandleError(c, http.StatusServiceUnavailable, context, err)
+}
+
+// VerifyApiKey only passes requests with the API key on to the endpoint handler
+func VerifyApiKey(apiKey string, endpointHandler func(c *gin.Context)) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		prefix := "Basic "
 		authHeader := c.Request.Header.Get("Authorization")
@@ -103,17 +178,14 @@ func VerifyApiKey(endpointHandler func(c *gin.Context)) gin.HandlerFunc {
 
 		if len(reqApi) != 0 {
 
-			if reqApi != os.Getenv("API_ACCESS_KEY") {
-				log.Println("Invalid api key")
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key", "context": "auth"})
+			if subtle.ConstantTimeCompare([]byte(reqApi), []byte(apiKey)) != 1 {
+				shared.HandleError(c, http.StatusUnauthorized, "auth", errors.New("invalid api key"))
 				return
 			}
 
 			endpointHandler(c)
 		} else {
-			err := fmt.Errorf("authorization token is not present")
-			log.Println(err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "context": "auth"})
+			shared.HandleError(c, http.StatusUnauthorized, "auth", errors.New("authorization token is not present"))
 			return
 		}
 	})
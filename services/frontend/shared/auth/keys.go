@@ -0,0 +1,280 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Smallest RSA key accepted for RS256
+const minRSABits = 2048
+
+// ErrUnknownKey is returned by ParseJWT when a token is signed with a key that is unknown or retired
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// signingKey is a key JWTs are signed or verified with, identified by its kid
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// private is nil for keys that are only used for verification
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeyConfig configures the keys JWTs are signed and verified with.
+type KeyConfig struct {
+	// Secret is the shared HS256 secret of tokens without a kid.
+	Secret string
+	// Dir is the directory of the PEM files of the asymmetric keys.
+	Dir string
+	// SigningKid is the kid of the private key new tokens are signed with. It can be left empty if Dir has only one
+	// private key.
+	SigningKid string
+	// RetiredKids are the kids of the keys of Dir that are no longer accepted.
+	RetiredKids []string
+}
+
+// KeySet holds the keys JWTs are signed and verified with.
+//
+// Asymmetric keys are PEM files named <kid>.pem in KeyConfig.Dir: RSA keys are used with RS256, and P-256 keys with
+// ES256. New tokens are signed with the private key KeyConfig.SigningKid, which can be left unset if there is only one
+// private key. Tokens are accepted if they are signed with any key of the directory, so keys can be rotated without
+// logging players out: add the new key, then sign with it, and once the tokens of the old key have expired, retire
+// it by listing it in KeyConfig.RetiredKids or removing it. Public key files can be added to only verify tokens with
+// a key.
+//
+// Tokens without a kid are HS256 tokens signed with the shared KeyConfig.Secret. They are signed if there is no
+// directory, and accepted as long as the secret is set, so deployments can move to asymmetric keys without logging
+// players out.
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	// legacy is the shared secret, if any
+	legacy []byte
+}
+
+var (
+	keysMu sync.Mutex
+	keys   *KeySet
+)
+
+// LoadKeys loads the KeySet of the configuration, replacing the one used by GenerateJWT, ParseJWT and VerifyJWT.
+// It must be called before any of them.
+func LoadKeys(cfg KeyConfig) error {
+	ks, err := NewKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	keys = ks
+	return nil
+}
+
+// currentKeys returns the loaded KeySet.
+func currentKeys() (*KeySet, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if keys == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	return keys, nil
+}
+
+// NewKeySet returns the KeySet of the configuration.
+func NewKeySet(cfg KeyConfig) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*signingKey{}}
+	if cfg.Secret != "" {
+		ks.legacy = []byte(cfg.Secret)
+	}
+
+	if cfg.Dir == "" {
+		if ks.legacy == nil {
+			return nil, errors.New("JWT_KEYS_DIR or JWT_KEY must be set")
+		}
+		return ks, nil
+	}
+
+	retired := map[string]bool{}
+	for _, kid := range cfg.RetiredKids {
+		if kid = strings.TrimSpace(kid); kid != "" {
+			retired[kid] = true
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("can't list JWT keys: %w", err)
+	}
+	var private []*signingKey
+	for _, f := range files {
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		if retired[kid] {
+			continue
+		}
+		k, err := loadKey(kid, f)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[kid] = k
+		if k.private != nil {
+			private = append(private, k)
+		}
+	}
+
+	switch kid := cfg.SigningKid; {
+	case kid != "":
+		k, ok := ks.keys[kid]
+		if !ok || k.private == nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KID %q is not a private key of JWT_KEYS_DIR, or is retired", kid)
+		}
+		ks.signing = k
+	case len(private) == 1:
+		ks.signing = private[0]
+	default:
+		return nil, fmt.Errorf("JWT_KEYS_DIR has %d private keys, set JWT_SIGNING_KID to pick the one to sign with", len(private))
+	}
+	return ks, nil
+}
+
+// loadKey loads a PEM encoded RSA or P-256 private or public key.
+func loadKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read JWT key %s: %w", kid, err)
+	}
+
+	k := &signingKey{kid: kid}
+	if strings.Contains(string(data), "PRIVATE KEY") {
+		if rk, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			k.private, k.public = rk, &rk.PublicKey
+		} else if ek, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+			k.private, k.public = ek, &ek.PublicKey
+		} else {
+			return nil, fmt.Errorf("JWT key %s is not an RSA or EC private key", kid)
+		}
+	} else {
+		if rk, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+			k.public = rk
+		} else if ek, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+			k.public = ek
+		} else {
+			return nil, fmt.Errorf("JWT key %s is not an RSA or EC public key", kid)
+		}
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("JWT key %s has %d bits, want at least %d", kid, pub.N.BitLen(), minRSABits)
+		}
+		k.method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("JWT key %s must be on P-256, got %s", kid, pub.Curve.Params().Name)
+		}
+		k.method = jwt.SigningMethodES256
+	}
+	return k, nil
+}
+
+// sign returns the token with the claims, signed with the signing key, or with the shared secret if there is none.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.legacy)
+	}
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.kid
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc returns the key to verify the token with, according to its kid. The token must use the algorithm of the
+// key, so a public key can't be used as an HMAC secret.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if ks.legacy == nil || token.Method != jwt.SigningMethodHS256 {
+			return nil, ErrUnknownKey
+		}
+		return ks.legacy, nil
+	}
+
+	k, ok := ks.keys[kid]
+	if !ok || token.Method != k.method {
+		return nil, ErrUnknownKey
+	}
+	return k.public, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served on /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKeys returns the public keys tokens are verified with, in kid order. The shared secret is never included.
+func PublicKeys() (JWKS, error) {
+	ks, err := currentKeys()
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		jwk := JWK{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty = "EC"
+			jwk.Crv = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKey writes the PEM encoded private key, or its public key if public is set, as dir/<kid>.pem.
+func writeKey(t *testing.T, dir, kid string, key interface{}, public bool) {
+	t.Helper()
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(key.(interface{ Public() crypto.PublicKey }).Public())
+		assert.Nil(t, err)
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		assert.Nil(t, err)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0600))
+}
+
+func ecKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(curve, rand.Reader)
+	assert.Nil(t, err)
+	return k
+}
+
+// useKeys loads the configuration as the current KeySet, and restores the previous one at the end of the test.
+func useKeys(t *testing.T, cfg KeyConfig) {
+	t.Helper()
+	keysMu.Lock()
+	prev := keys
+	keysMu.Unlock()
+	t.Cleanup(func() {
+		keysMu.Lock()
+		keys = prev
+		keysMu.Unlock()
+	})
+	assert.Nil(t, LoadKeys(cfg))
+}
+
+// signClaims signs a token with the claims, and a kid header unless kid is empty.
+func signClaims(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return s
+}
+
+func claimsAt(iat time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		Id:        "p1",
+		SessionId: "s1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(iat),
+			ExpiresAt: jwt.NewNumericDate(iat.Add(ttl)),
+		},
+	}
+}
+
+func TestNewKeySetErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewKeySet(KeyConfig{})
+	assert.EqualError(t, err, "JWT_KEYS_DIR or JWT_KEY must be set")
+
+	// Two private keys need a signing kid, which must be a private key that is not retired
+	writeKey(t, dir, "a", ecKey(t, elliptic.P256()), false)
+	writeKey(t, dir, "b", ecKey(t, elliptic.P256()), false)
+	writeKey(t, dir, "pub", ecKey(t, elliptic.P256()), true)
+	_, err = NewKeySet(KeyConfig{Dir: dir})
+	assert.ErrorContains(t, err, "has 2 private keys")
+	_, err = NewKeySet(KeyConfig{Dir: dir, SigningKid: "c"})
+	assert.ErrorContains(t, err, `JWT_SIGNING_KID "c"`)
+	_, err = NewKeySet(KeyConfig{Dir: dir, SigningKid: "pub"})
+	assert.ErrorContains(t, err, `JWT_SIGNING_KID "pub"`)
+	_, err = NewKeySet(KeyConfig{Dir: dir, SigningKid: "a", RetiredKids: []string{"a"}})
+	assert.ErrorContains(t, err, `JWT_SIGNING_KID "a"`)
+
+	// Retiring one of the keys leaves a single private key to sign with
+	ks, err := NewKeySet(KeyConfig{Dir: dir, RetiredKids: []string{" a "}})
+	assert.Nil(t, err)
+	assert.Equal(t, "b", ks.signing.kid)
+
+	// Weak and unsupported keys are refused
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	dir = t.TempDir()
+	writeKey(t, dir, "small", small, false)
+	_, err = NewKeySet(KeyConfig{Dir: dir})
+	assert.ErrorContains(t, err, "JWT key small has 1024 bits")
+
+	dir = t.TempDir()
+	writeKey(t, dir, "p384", ecKey(t, elliptic.P384()), false)
+	_, err = NewKeySet(KeyConfig{Dir: dir})
+	assert.ErrorContains(t, err, "JWT key p384 must be on P-256")
+
+	dir = t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "junk.pem"), []byte("junk"), 0600))
+	_, err = NewKeySet(KeyConfig{Dir: dir})
+	assert.ErrorContains(t, err, "JWT key junk is not an RSA or EC public key")
+}
+
+func TestSignAndParse(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	for name, key := range map[string]interface{}{"RS256": rk, "ES256": ecKey(t, elliptic.P256())} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeKey(t, dir, "k1", key, false)
+			useKeys(t, KeyConfig{Dir: dir})
+
+			token, err := GenerateJWT("p1", "s1", true, time.Minute)
+			assert.Nil(t, err)
+			parsed, _ := jwt.Parse(token, nil)
+			assert.Equal(t, name, parsed.Method.Alg())
+			assert.Equal(t, "k1", parsed.Header["kid"])
+
+			claims, err := ParseJWT(token)
+			assert.Nil(t, err)
+			assert.Equal(t, "p1", claims.Id)
+			assert.Equal(t, "s1", claims.SessionId)
+			assert.True(t, claims.Guest)
+		})
+	}
+}
+
+func TestParseRejectsWrongKeys(t *testing.T) {
+	dir := t.TempDir()
+	ek := ecKey(t, elliptic.P256())
+	writeKey(t, dir, "k1", ek, false)
+	useKeys(t, KeyConfig{Dir: dir})
+	claims := claimsAt(time.Now(), time.Minute)
+
+	// A kid that was never known
+	token := signClaims(t, jwt.SigningMethodES256, "k2", ek, claims)
+	_, err := ParseJWT(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// A token of the right kid signed by another key
+	token = signClaims(t, jwt.SigningMethodES256, "k1", ecKey(t, elliptic.P256()), claims)
+	_, err = ParseJWT(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	// The public key can't be used as an HMAC secret
+	pub, err := x509.MarshalPKIXPublicKey(&ek.PublicKey)
+	assert.Nil(t, err)
+	token = signClaims(t, jwt.SigningMethodHS256, "k1", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), claims)
+	_, err = ParseJWT(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Tokens without a kid are only accepted with the legacy secret
+	token = signClaims(t, jwt.SigningMethodHS256, "", []byte("secret"), claims)
+	_, err = ParseJWT(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Unsigned tokens are never accepted
+	token = signClaims(t, jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType, claims)
+	_, err = ParseJWT(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestLegacySecret(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "k1", ecKey(t, elliptic.P256()), false)
+	useKeys(t, KeyConfig{Secret: "secret", Dir: dir})
+	claims := claimsAt(time.Now(), time.Minute)
+
+	// Tokens signed before the move to asymmetric keys are still accepted, but not with another secret or algorithm
+	_, err := ParseJWT(signClaims(t, jwt.SigningMethodHS256, "", []byte("secret"), claims))
+	assert.Nil(t, err)
+	_, err = ParseJWT(signClaims(t, jwt.SigningMethodHS256, "", []byte("other"), claims))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+	_, err = ParseJWT(signClaims(t, jwt.SigningMethodHS512, "", []byte("secret"), claims))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// New tokens are signed with the key of the directory
+	token, err := GenerateJWT("p1", "s1", false, time.Minute)
+	assert.Nil(t, err)
+	parsed, _ := jwt.Parse(token, nil)
+	assert.Equal(t, "k1", parsed.Header["kid"])
+
+	// Without a directory, the secret signs new tokens too
+	useKeys(t, KeyConfig{Secret: "secret"})
+	token, err = GenerateJWT("p1", "s1", false, time.Minute)
+	assert.Nil(t, err)
+	parsed, _ = jwt.Parse(token, nil)
+	assert.Equal(t, "HS256", parsed.Method.Alg())
+	assert.Nil(t, parsed.Header["kid"])
+	_, err = ParseJWT(token)
+	assert.Nil(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old", ecKey(t, elliptic.P256()), false)
+	useKeys(t, KeyConfig{Dir: dir})
+	oldToken, err := GenerateJWT("p1", "s1", false, time.Minute)
+	assert.Nil(t, err)
+
+	// Signing with the new key keeps the tokens of the old key valid
+	writeKey(t, dir, "new", ecKey(t, elliptic.P256()), false)
+	useKeys(t, KeyConfig{Dir: dir, SigningKid: "new"})
+	newToken, err := GenerateJWT("p1", "s1", false, time.Minute)
+	assert.Nil(t, err)
+	_, err = ParseJWT(oldToken)
+	assert.Nil(t, err)
+	_, err = ParseJWT(newToken)
+	assert.Nil(t, err)
+
+	// Once retired, the old key is no longer accepted
+	useKeys(t, KeyConfig{Dir: dir, SigningKid: "new", RetiredKids: []string{"old"}})
+	_, err = ParseJWT(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = ParseJWT(newToken)
+	assert.Nil(t, err)
+}
+
+func TestExpiryAndClockSkew(t *testing.T) {
+	dir := t.TempDir()
+	ek := ecKey(t, elliptic.P256())
+	writeKey(t, dir, "k1", ek, false)
+	useKeys(t, KeyConfig{Dir: dir})
+	now := time.Now()
+
+	// Tokens issued by a replica whose clock is slightly ahead, or that just expired, are accepted
+	_, err := ParseJWT(signClaims(t, jwt.SigningMethodES256, "k1", ek, claimsAt(now.Add(clockSkew/2), time.Minute)))
+	assert.Nil(t, err)
+	_, err = ParseJWT(signClaims(t, jwt.SigningMethodES256, "k1", ek, claimsAt(now.Add(-time.Minute-clockSkew/2), time.Minute)))
+	assert.Nil(t, err)
+
+	// Beyond the skew, they are not
+	_, err = ParseJWT(signClaims(t, jwt.SigningMethodES256, "k1", ek, claimsAt(now.Add(2*clockSkew), time.Minute)))
+	assert.ErrorIs(t, err, jwt.ErrTokenUsedBeforeIssued)
+	expired := signClaims(t, jwt.SigningMethodES256, "k1", ek, claimsAt(now.Add(-time.Minute-2*clockSkew), time.Minute))
+	_, err = ParseJWT(expired)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	// The skew doesn't hide a bad signature
+	_, err = ParseJWT(signClaims(t, jwt.SigningMethodES256, "k1", ecKey(t, elliptic.P256()), claimsAt(now.Add(-time.Minute-clockSkew/2), time.Minute)))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	// ParseExpiredJWT accepts expired tokens within the grace period only
+	claims, err := ParseExpiredJWT(expired, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", claims.Id)
+	_, err = ParseExpiredJWT(expired, clockSkew)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+	_, err = ParseExpiredJWT(signClaims(t, jwt.SigningMethodES256, "k2", ek, claimsAt(now, time.Minute)), time.Hour)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	writeKey(t, dir, "b", rk, true)
+	writeKey(t, dir, "a", ecKey(t, elliptic.P256()), false)
+	writeKey(t, dir, "c", ecKey(t, elliptic.P256()), false)
+	useKeys(t, KeyConfig{Secret: "secret", Dir: dir, SigningKid: "a", RetiredKids: []string{"c"}})
+
+	set, err := PublicKeys()
+	assert.Nil(t, err)
+	if assert.Len(t, set.Keys, 2) {
+		assert.Equal(t, JWK{Kty: "EC", Kid: "a", Use: "sig", Alg: "ES256", Crv: "P-256", X: set.Keys[0].X, Y: set.Keys[0].Y}, set.Keys[0])
+		assert.Len(t, set.Keys[0].X, 43)
+		assert.Equal(t, "b", set.Keys[1].Kid)
+		assert.Equal(t, "RSA", set.Keys[1].Kty)
+		assert.Equal(t, "RS256", set.Keys[1].Alg)
+		assert.Equal(t, "AQAB", set.Keys[1].E)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Cookie binding an OAuth login to the browser that started it
+	oauthStateCookie = "oauth_state"
+	// How long players have to sign in with the identity provider
+	oauthStateTTL = 10 * time.Minute
+	// How long players have to start linking an identity provider, once they asked to
+	linkTicketTTL = 5 * time.Minute
+)
+
+// ErrOAuthStateMismatch is returned by OAuthStates.Finish when the callback doesn't belong to a login started by
+// the same browser, e.g. because of a CSRF attempt, or because the login took too long.
+var ErrOAuthStateMismatch = errors.New("oauth state mismatch")
+
+// ErrInvalidLinkTicket is returned by OAuthStates.LinkTicketPlayer for link tickets that are invalid or expired
+var ErrInvalidLinkTicket = errors.New("invalid link ticket")
+
+// OAuthStates binds each OAuth login to the browser that started it. Every login gets a random state and PKCE code
+// verifier, which are kept in a short-lived signed cookie until the callback.
+type OAuthStates struct {
+	key    []byte
+	secure bool
+}
+
+// oauthState is the content of the state cookie
+type oauthState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Provider string `json:"provider"`
+	Link     string `json:"link,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Expires  int64  `json:"expires"`
+}
+
+// linkTicket is the content of a link ticket
+type linkTicket struct {
+	PlayerId string `json:"player"`
+	Expires  int64  `json:"expires"`
+}
+
+// Login is a login started by OAuthStates.Begin, as recovered by OAuthStates.Finish
+type Login struct {
+	// Identity provider the player signs in with
+	Provider string
+	// PKCE code verifier to redeem the authorization code with, generated by Begin
+	Verifier string
+	// Player to link the identity to, if the login links an identity provider
+	LinkPlayerId string
+	// User code of the device authorization to approve, if the login signs in a device
+	DeviceUserCode string
+}
+
+// NewOAuthStates returns OAuthStates signing their cookies with the key. If key is empty, a random key is used, so
+// logins have to finish on the replica that started them. Cookies are only sent over HTTPS if secure is true.
+func NewOAuthStates(key []byte, secure bool) (*OAuthStates, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("can't generate oauth state key: %w", err)
+		}
+	}
+	return &OAuthStates{key: key, secure: secure}, nil
+}
+
+// Begin starts the login, setting the state cookie. Returns the state and the PKCE code challenge to send to the
+// identity provider.
+func (s *OAuthStates) Begin(c *gin.Context, login Login) (string, string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	value, err := s.seal(oauthState{State: state, Verifier: verifier, Provider: login.Provider, Link: login.LinkPlayerId,
+		Device: login.DeviceUserCode, Expires: time.Now().Add(oauthStateTTL).Unix()})
+	if err != nil {
+		return "", "", err
+	}
+	s.setCookie(c, value, int(oauthStateTTL.Seconds()))
+
+	// S256 code challenge, per RFC 7636
+	sum := sha256.Sum256([]byte(verifier))
+	return state, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// LinkTicket returns a short-lived ticket to link an identity provider to the player with, so players can link
+// providers in their browser while signed in elsewhere. Link tickets are posted by the browser, never passed in URLs,
+// where they would leak through its history and the Referer header.
+func (s *OAuthStates) LinkTicket(playerID string) (string, error) {
+	return s.seal(linkTicket{PlayerId: playerID, Expires: time.Now().Add(linkTicketTTL).Unix()})
+}
+
+// LinkTicketPlayer returns the player of the link ticket, or ErrInvalidLinkTicket
+func (s *OAuthStates) LinkTicketPlayer(ticket string) (string, error) {
+	var lt linkTicket
+	if err := s.open(ticket, &lt); err != nil || lt.PlayerId == "" || time.Now().Unix() > lt.Expires {
+		return "", ErrInvalidLinkTicket
+	}
+	return lt.PlayerId, nil
+}
+
+// Finish checks the state of the callback against the state cookie, and clears the cookie, so each state is only
+// used once. Returns the login the state belongs to, or ErrOAuthStateMismatch.
+func (s *OAuthStates) Finish(c *gin.Context, state string) (*Login, error) {
+	cookie, err := c.Cookie(oauthStateCookie)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no state cookie", ErrOAuthStateMismatch)
+	}
+	s.setCookie(c, "", -1)
+
+	var st oauthState
+	if err := s.open(cookie, &st); err != nil {
+		return nil, fmt.Errorf("%w: invalid state cookie", ErrOAuthStateMismatch)
+	}
+
+	if time.Now().Unix() > st.Expires {
+		return nil, fmt.Errorf("%w: login expired", ErrOAuthStateMismatch)
+	}
+	if state == "" || !hmac.Equal([]byte(state), []byte(st.State)) {
+		return nil, ErrOAuthStateMismatch
+	}
+	return &Login{Provider: st.Provider, Verifier: st.Verifier, LinkPlayerId: st.Link, DeviceUserCode: st.Device}, nil
+}
+
+// seal encodes v as base64 JSON, signed with the key
+func (s *OAuthStates) seal(v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	return value + "." + s.sign(value), nil
+}
+
+// open decodes a value sealed by seal into v, checking its signature
+func (s *OAuthStates) open(sealed string, v any) error {
+	value, sig, ok := strings.Cut(sealed, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(value))) {
+		return errors.New("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (s *OAuthStates) sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *OAuthStates) setCookie(c *gin.Context, value string, maxAge int) {
+	// Lax, so the cookie is sent on the redirect back from the identity provider
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
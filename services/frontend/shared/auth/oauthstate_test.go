@@ -0,0 +1,101 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// beginLogin begins the login, returning its state and the cookies the browser would send back
+func beginLogin(t *testing.T, s *OAuthStates, login Login) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login/google", nil)
+	state, challenge, err := s.Begin(c, login)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, challenge)
+	return state, w.Result().Cookies()
+}
+
+// finishLogin finishes the login of the callback with the state and cookies
+func finishLogin(s *OAuthStates, state string, cookies []*http.Cookie) (*Login, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/callback", nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+	return s.Finish(c, state)
+}
+
+func TestOAuthStates(t *testing.T) {
+	s, err := NewOAuthStates([]byte("key"), true)
+	assert.Nil(t, err)
+
+	state, cookies := beginLogin(t, s, Login{Provider: "google", LinkPlayerId: "p1"})
+	if assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	}
+
+	// Only the browser that began the login can finish it, with the state of the login
+	_, err = finishLogin(s, state, nil)
+	assert.ErrorIs(t, err, ErrOAuthStateMismatch)
+	_, err = finishLogin(s, "other", cookies)
+	assert.ErrorIs(t, err, ErrOAuthStateMismatch)
+	login, err := finishLogin(s, state, cookies)
+	assert.Nil(t, err)
+	assert.Equal(t, "google", login.Provider)
+	assert.Equal(t, "p1", login.LinkPlayerId)
+	assert.NotEmpty(t, login.Verifier)
+
+	// Cookies of other keys are rejected
+	other, err := NewOAuthStates([]byte("other"), true)
+	assert.Nil(t, err)
+	_, err = finishLogin(other, state, cookies)
+	assert.ErrorIs(t, err, ErrOAuthStateMismatch)
+}
+
+func TestLinkTickets(t *testing.T) {
+	s, err := NewOAuthStates([]byte("key"), false)
+	assert.Nil(t, err)
+
+	ticket, err := s.LinkTicket("p1")
+	assert.Nil(t, err)
+	playerID, err := s.LinkTicketPlayer(ticket)
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", playerID)
+
+	// Tickets can't be forged, and expire
+	_, err = s.LinkTicketPlayer("")
+	assert.ErrorIs(t, err, ErrInvalidLinkTicket)
+	_, err = s.LinkTicketPlayer(ticket + "x")
+	assert.ErrorIs(t, err, ErrInvalidLinkTicket)
+	other, err := NewOAuthStates([]byte("other"), false)
+	assert.Nil(t, err)
+	_, err = other.LinkTicketPlayer(ticket)
+	assert.ErrorIs(t, err, ErrInvalidLinkTicket)
+	expired, err := s.seal(linkTicket{PlayerId: "p1", Expires: time.Now().Add(-time.Second).Unix()})
+	assert.Nil(t, err)
+	_, err = s.LinkTicketPlayer(expired)
+	assert.ErrorIs(t, err, ErrInvalidLinkTicket)
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Prefix of the keys holding each session.
+	redisSessionPrefix = "frontend:session:"
+	// Prefix of the sets of the session ids of each player, which may include sessions that ended.
+	redisPlayerSessionsPrefix = "frontend:player-sessions:"
+)
+
+// Stores the session ARGV[1] at KEYS[1] for ARGV[2] milliseconds, and adds its id ARGV[3] to the set KEYS[2] of its
+// player, which lasts as long as its last session.
+var createSessionScript = redis.NewScript(`redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('SADD', KEYS[2], ARGV[3])
+if redis.call('PTTL', KEYS[2]) < tonumber(ARGV[2]) then redis.call('PEXPIRE', KEYS[2], ARGV[2]) end
+return 1`)
+
+// Replaces KEYS[1] with ARGV[2] if it still holds ARGV[1], keeping its expiry. Returns 1 on success.
+var rotateSessionScript = redis.NewScript(`if redis.call('GET', KEYS[1]) ~= ARGV[1] then return 0 end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', redis.call('PTTL', KEYS[1]))
+return 1`)
+
+// RedisSessionStore is a SessionStore shared by all frontend replicas, so sessions can be refreshed and revoked
+// through any replica.
+type RedisSessionStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisSessionStore returns a RedisSessionStore that keeps sessions in Redis.
+func NewRedisSessionStore(client redis.UniversalClient) *RedisSessionStore {
+	return &RedisSessionStore{client: client}
+}
+
+// Create implements SessionStore.
+func (s *RedisSessionStore) Create(ctx context.Context, session *Session) error {
+	v, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	keys := []string{redisSessionPrefix + session.Id, redisPlayerSessionsPrefix + session.PlayerId}
+	if err := createSessionScript.Run(ctx, s.client, keys, v, time.Until(session.Expires).Milliseconds(), session.Id).Err(); err != nil {
+		return fmt.Errorf("redis session creation failed: %w", err)
+	}
+	return nil
+}
+
+// Get implements SessionStore.
+func (s *RedisSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	v, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(v)
+}
+
+// Rotate implements SessionStore.
+func (s *RedisSessionStore) Rotate(ctx context.Context, id, prev, next string) (bool, error) {
+	v, err := s.get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	session, err := decodeSession(v)
+	if err != nil || session == nil || session.RefreshHash != prev {
+		return false, err
+	}
+
+	session.PrevRefreshHash, session.RefreshHash = prev, next
+	nv, err := json.Marshal(session)
+	if err != nil {
+		return false, err
+	}
+	ok, err := rotateSessionScript.Run(ctx, s.client, []string{redisSessionPrefix + id}, v, string(nv)).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis session rotation failed: %w", err)
+	}
+	return ok == 1, nil
+}
+
+// Delete implements SessionStore.
+func (s *RedisSessionStore) Delete(ctx context.Context, id string) error {
+	if err := s.client.Del(ctx, redisSessionPrefix+id).Err(); err != nil {
+		return fmt.Errorf("redis DEL failed: %w", err)
+	}
+	return nil
+}
+
+// PlayerSessions implements SessionStore. Sessions that ended are removed from the set of the player.
+func (s *RedisSessionStore) PlayerSessions(ctx context.Context, playerID string) ([]*Session, error) {
+	key := redisPlayerSessionsPrefix + playerID
+	ids, err := s.client.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis SMEMBERS failed: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisSessionPrefix + id
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis MGET failed: %w", err)
+	}
+
+	var sessions []*Session
+	var ended []interface{}
+	for i, v := range values {
+		encoded, ok := v.(string)
+		if !ok {
+			ended = append(ended, ids[i])
+			continue
+		}
+		session, err := decodeSession(encoded)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if len(ended) > 0 {
+		if err := s.client.SRem(ctx, key, ended...).Err(); err != nil {
+			return nil, fmt.Errorf("redis SREM failed: %w", err)
+		}
+	}
+	return sessions, nil
+}
+
+// get returns the encoded session, or "" if there is none.
+func (s *RedisSessionStore) get(ctx context.Context, id string) (string, error) {
+	v, err := s.client.Get(ctx, redisSessionPrefix+id).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis GET failed: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisSessionStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rc.Close()
+	s := NewRedisSessionStore(rc)
+	ctx := context.Background()
+
+	session, err := s.Get(ctx, "s1")
+	assert.Nil(t, err)
+	assert.Nil(t, session)
+
+	assert.Nil(t, s.Create(ctx, &Session{Id: "s1", PlayerId: "p1", Expires: time.Now().Add(time.Hour), RefreshHash: "h1"}))
+	session, err = s.Get(ctx, "s1")
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", session.PlayerId)
+
+	// Only the current refresh token rotates, and the session keeps its expiry
+	ok, err := s.Rotate(ctx, "s1", "h0", "h2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = s.Rotate(ctx, "s1", "h1", "h2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	session, _ = s.Get(ctx, "s1")
+	assert.Equal(t, "h2", session.RefreshHash)
+	assert.Equal(t, "h1", session.PrevRefreshHash)
+	assert.InDelta(t, time.Hour.Seconds(), mr.TTL(redisSessionPrefix+"s1").Seconds(), 5)
+
+	assert.Nil(t, s.Delete(ctx, "s1"))
+	session, err = s.Get(ctx, "s1")
+	assert.Nil(t, err)
+	assert.Nil(t, session)
+
+	// Sessions are forgotten once they expire
+	assert.Nil(t, s.Create(ctx, &Session{Id: "s2", PlayerId: "p1", Expires: time.Now().Add(time.Minute)}))
+	mr.FastForward(2 * time.Minute)
+	session, _ = s.Get(ctx, "s2")
+	assert.Nil(t, session)
+
+	mr.Close()
+	_, err = s.Get(ctx, "s2")
+	assert.NotNil(t, err)
+}
+
+func TestRedisPlayerSessions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rc.Close()
+	s := NewRedisSessionStore(rc)
+	ctx := context.Background()
+
+	assert.Nil(t, s.Create(ctx, &Session{Id: "s1", PlayerId: "p1", Expires: time.Now().Add(time.Hour)}))
+	assert.Nil(t, s.Create(ctx, &Session{Id: "s2", PlayerId: "p1", Guest: true, Expires: time.Now().Add(time.Minute)}))
+	assert.Nil(t, s.Create(ctx, &Session{Id: "s3", PlayerId: "p2", Expires: time.Now().Add(time.Hour)}))
+
+	// The set of the player lasts as long as its last session
+	assert.InDelta(t, time.Hour.Seconds(), mr.TTL(redisPlayerSessionsPrefix+"p1").Seconds(), 5)
+	sessions, err := s.PlayerSessions(ctx, "p1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"s1", "s2"}, sessionIDs(sessions))
+
+	// Sessions that ended are forgotten
+	assert.Nil(t, s.Delete(ctx, "s1"))
+	mr.FastForward(2 * time.Minute)
+	sessions, err = s.PlayerSessions(ctx, "p1")
+	assert.Nil(t, err)
+	assert.Empty(t, sessions)
+	assert.False(t, mr.Exists(redisPlayerSessionsPrefix+"p1"))
+
+	sessions, err = s.PlayerSessions(ctx, "unknown")
+	assert.Nil(t, err)
+	assert.Empty(t, sessions)
+}
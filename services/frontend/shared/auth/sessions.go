@@ -0,0 +1,439 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAccessTokenTTL is how long access tokens are valid for by default. Game clients keep the token they
+	// were launched with, so it has to outlast a play session.
+	DefaultAccessTokenTTL = time.Hour
+	// DefaultSessionTTL is how long players stay signed in by default, refreshing their access token as needed.
+	DefaultSessionTTL = 30 * 24 * time.Hour
+	// DefaultGuestSessionTTL is how long guests stay signed in by default. Guests sign in again with their device.
+	DefaultGuestSessionTTL = 24 * time.Hour
+
+	// Login codes are exchanged by the launcher right after the browser is redirected to it.
+	loginCodeTTL = time.Minute
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is malformed, expired, revoked or already used.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrInvalidLoginCode is returned when a login code is malformed, expired or already used.
+	ErrInvalidLoginCode = errors.New("invalid login code")
+	// ErrSessionRevoked is returned by VerifyJWT when the session of an access token has ended.
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+// Session is a signed in player. Access tokens carry the id of their session, and are only accepted while it is
+// in the SessionStore. Refresh tokens are kept hashed.
+type Session struct {
+	Id       string    `json:"id"`
+	PlayerId string    `json:"playerId"`
+	Guest    bool      `json:"guest,omitempty"`
+	Expires  time.Time `json:"expires"`
+	// RefreshHash is the hash of the current refresh token, and PrevRefreshHash that of the one it replaced
+	RefreshHash     string `json:"refreshHash"`
+	PrevRefreshHash string `json:"prevRefreshHash,omitempty"`
+	// LoginCode is set on the placeholder sessions of login codes, whose RefreshHash is the hash of the code
+	LoginCode bool `json:"loginCode,omitempty"`
+}
+
+// SessionStore keeps the sessions of signed in players. Sessions are revoked by deleting them.
+type SessionStore interface {
+	// Create stores a new session until it expires.
+	Create(ctx context.Context, s *Session) error
+	// Get returns the session, or nil if it expired or was revoked.
+	Get(ctx context.Context, id string) (*Session, error)
+	// Rotate makes next the refresh token hash of the session, if prev still is. Returns false otherwise.
+	Rotate(ctx context.Context, id, prev, next string) (bool, error)
+	// Delete revokes the session.
+	Delete(ctx context.Context, id string) error
+	// PlayerSessions returns the sessions of the player that haven't expired or been revoked.
+	PlayerSessions(ctx context.Context, playerID string) ([]*Session, error)
+}
+
+// TokenPair is what players get when they sign in or refresh: an access token for the API, and the refresh token
+// to get the next pair with
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	// ExpiresIn is the lifetime of the access token, in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// Sessions issues short-lived access tokens with rotating refresh tokens.
+type Sessions struct {
+	store      SessionStore
+	accessTTL  time.Duration
+	sessionTTL time.Duration
+	guestTTL   time.Duration
+}
+
+// NewSessions returns Sessions kept in the store, with access tokens valid for accessTTL, and refresh tokens until
+// sessionTTL after sign in, or guestTTL for guests.
+func NewSessions(store SessionStore, accessTTL, sessionTTL, guestTTL time.Duration) *Sessions {
+	return &Sessions{store: store, accessTTL: accessTTL, sessionTTL: sessionTTL, guestTTL: guestTTL}
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   *Sessions
+)
+
+// UseSessions makes VerifyJWT only accept access tokens of sessions that are still active.
+func UseSessions(s *Sessions) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions = s
+}
+
+func currentSessions() *Sessions {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return sessions
+}
+
+// Start starts a session for the player, returning its first token pair.
+func (s *Sessions) Start(ctx context.Context, playerID string) (TokenPair, error) {
+	return s.start(ctx, playerID, false)
+}
+
+// StartGuest starts a guest session for the player. Guest sessions end after the guest session lifetime, and their
+// access tokens are marked as guest tokens.
+func (s *Sessions) StartGuest(ctx context.Context, playerID string) (TokenPair, error) {
+	return s.start(ctx, playerID, true)
+}
+
+func (s *Sessions) start(ctx context.Context, playerID string, guest bool) (TokenPair, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	ttl := s.sessionTTL
+	if guest {
+		ttl = s.guestTTL
+	}
+	session := &Session{Id: id, PlayerId: playerID, Guest: guest, Expires: time.Now().Add(ttl), RefreshHash: hash}
+	if err := s.store.Create(ctx, session); err != nil {
+		return TokenPair{}, fmt.Errorf("can't store session: %w", err)
+	}
+	return s.tokens(session, refresh)
+}
+
+// IssueLoginCode returns a one-time code the launcher exchanges for the player's first token pair with
+// ExchangeLoginCode, so tokens are never put in URLs. The code is valid for a minute.
+func (s *Sessions) IssueLoginCode(ctx context.Context, playerID string) (string, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	code, hash, err := newRefreshToken(id)
+	if err != nil {
+		return "", err
+	}
+
+	session := &Session{Id: id, PlayerId: playerID, Expires: time.Now().Add(loginCodeTTL), RefreshHash: hash, LoginCode: true}
+	if err := s.store.Create(ctx, session); err != nil {
+		return "", fmt.Errorf("can't store login code: %w", err)
+	}
+	return code, nil
+}
+
+// ExchangeLoginCode starts the session of the player of the login code, returning its first token pair. Each code
+// can only be exchanged once.
+func (s *Sessions) ExchangeLoginCode(ctx context.Context, code string) (TokenPair, error) {
+	session, hash, err := s.lookup(ctx, code, true)
+	if errors.Is(err, ErrInvalidRefreshToken) || err == nil && !equalHashes(hash, session.RefreshHash) {
+		return TokenPair{}, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// Only one exchange wins the code
+	ok, err := s.store.Rotate(ctx, session.Id, hash, "")
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("can't redeem login code: %w", err)
+	}
+	if !ok {
+		return TokenPair{}, ErrInvalidLoginCode
+	}
+	if err := s.store.Delete(ctx, session.Id); err != nil {
+		log.Printf("Session %s: can't delete redeemed login code: %s", session.Id, err)
+	}
+	return s.Start(ctx, session.PlayerId)
+}
+
+// Refresh exchanges the refresh token for a new token pair. The refresh token can only be used once: if a refresh
+// token that was already exchanged is used again, it was likely stolen, and the session is revoked.
+func (s *Sessions) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	session, hash, err := s.lookup(ctx, refreshToken, false)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !equalHashes(hash, session.RefreshHash) {
+		if equalHashes(hash, session.PrevRefreshHash) {
+			log.Printf("Session %s: refresh token of player %s reused, revoking session", session.Id, session.PlayerId)
+			if err := s.store.Delete(ctx, session.Id); err != nil {
+				return TokenPair{}, err
+			}
+		}
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	refresh, next, err := newRefreshToken(session.Id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	ok, err := s.store.Rotate(ctx, session.Id, hash, next)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("can't rotate refresh token: %w", err)
+	}
+	if !ok {
+		// Refreshed concurrently with the same token
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	return s.tokens(session, refresh)
+}
+
+// End revokes the session of the refresh token, so neither it nor the session's access tokens are accepted anymore.
+func (s *Sessions) End(ctx context.Context, refreshToken string) error {
+	session, hash, err := s.lookup(ctx, refreshToken, false)
+	if err != nil {
+		return err
+	}
+	if !equalHashes(hash, session.RefreshHash) && !equalHashes(hash, session.PrevRefreshHash) {
+		return ErrInvalidRefreshToken
+	}
+	return s.store.Delete(ctx, session.Id)
+}
+
+// EndGuestSessions revokes the guest sessions of the player, once they upgraded to a full player.
+func (s *Sessions) EndGuestSessions(ctx context.Context, playerID string) error {
+	sessions, err := s.store.PlayerSessions(ctx, playerID)
+	if err != nil {
+		return fmt.Errorf("can't list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if !session.Guest {
+			continue
+		}
+		if err := s.store.Delete(ctx, session.Id); err != nil {
+			return fmt.Errorf("can't revoke guest session %s: %w", session.Id, err)
+		}
+	}
+	return nil
+}
+
+// Active reports whether the session is still active.
+func (s *Sessions) Active(ctx context.Context, id string) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	session, err := s.store.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return session != nil && !session.LoginCode, nil
+}
+
+// Check returns ErrSessionRevoked if the session of the access token's claims has ended. Tokens issued before
+// sessions were introduced have no session, and are accepted until they expire.
+func (s *Sessions) Check(ctx context.Context, claims *Claims) error {
+	if claims.SessionId == "" {
+		return nil
+	}
+	active, err := s.Active(ctx, claims.SessionId)
+	if err != nil {
+		return fmt.Errorf("can't look up session %s: %w", claims.SessionId, err)
+	}
+	if !active {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// lookup returns the active session of the refresh token, or of the login code if loginCode is set, and the
+// token's hash.
+func (s *Sessions) lookup(ctx context.Context, refreshToken string, loginCode bool) (*Session, string, error) {
+	id, _, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	session, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if session == nil || session.LoginCode != loginCode || time.Now().After(session.Expires) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	return session, hashRefreshToken(refreshToken), nil
+}
+
+// tokens returns the token pair of the session, with a new access token. Access tokens of guests don't outlive
+// their session.
+func (s *Sessions) tokens(session *Session, refresh string) (TokenPair, error) {
+	ttl := s.accessTTL
+	if session.Guest && s.guestTTL < ttl {
+		ttl = s.guestTTL
+	}
+	access, err := GenerateJWT(session.PlayerId, session.Id, session.Guest, ttl)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: int64(ttl.Seconds())}, nil
+}
+
+// newRefreshToken returns a refresh token of the session, and its hash. The session id is in the clear, so the
+// session can be found without storing the token.
+func newRefreshToken(sessionID string) (string, string, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	token := sessionID + "." + secret
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func equalHashes(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// MemorySessionStore is a SessionStore for a single frontend replica.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]Session{}}
+}
+
+// Create implements SessionStore.
+func (s *MemorySessionStore) Create(_ context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forgetExpired()
+	s.sessions[session.Id] = *session
+	return nil
+}
+
+// Get implements SessionStore.
+func (s *MemorySessionStore) Get(_ context.Context, id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// Rotate implements SessionStore.
+func (s *MemorySessionStore) Rotate(_ context.Context, id, prev, next string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.RefreshHash != prev {
+		return false, nil
+	}
+	session.PrevRefreshHash, session.RefreshHash = prev, next
+	s.sessions[id] = session
+	return true, nil
+}
+
+// Delete implements SessionStore.
+func (s *MemorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// PlayerSessions implements SessionStore.
+func (s *MemorySessionStore) PlayerSessions(_ context.Context, playerID string) ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forgetExpired()
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if session.PlayerId == playerID {
+			session := session
+			sessions = append(sessions, &session)
+		}
+	}
+	return sessions, nil
+}
+
+// forgetExpired forgets expired sessions. s.mu must be held.
+func (s *MemorySessionStore) forgetExpired() {
+	now := time.Now()
+	for id, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func decodeSession(v string) (*Session, error) {
+	if v == "" {
+		return nil, nil
+	}
+	session := &Session{}
+	if err := json.Unmarshal([]byte(v), session); err != nil {
+		return nil, fmt.Errorf("can't decode session: %w", err)
+	}
+	return session, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSessions(t *testing.T) *Sessions {
+	t.Helper()
+	useKeys(t, KeyConfig{Secret: "secret"})
+	return NewSessions(NewMemorySessionStore(), time.Minute, time.Hour, time.Hour)
+}
+
+func TestSessionRefresh(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	first, err := s.Start(ctx, "p1")
+	assert.Nil(t, err)
+	claims, err := ParseJWT(first.AccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Check(ctx, claims))
+
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// Reusing a refresh token revokes the session
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = s.Refresh(ctx, second.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.ErrorIs(t, s.Check(ctx, claims), ErrSessionRevoked)
+}
+
+func TestSessionEnd(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	tokens, err := s.Start(ctx, "p1")
+	assert.Nil(t, err)
+	claims, err := ParseJWT(tokens.AccessToken)
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, s.End(ctx, "unknown.token"), ErrInvalidRefreshToken)
+	assert.Nil(t, s.End(ctx, tokens.RefreshToken))
+	assert.ErrorIs(t, s.Check(ctx, claims), ErrSessionRevoked)
+	assert.ErrorIs(t, s.End(ctx, tokens.RefreshToken), ErrInvalidRefreshToken)
+}
+
+func TestLoginCode(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	code, err := s.IssueLoginCode(ctx, "p1")
+	assert.Nil(t, err)
+
+	// Codes are neither refresh tokens nor sessions
+	_, err = s.Refresh(ctx, code)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.ErrorIs(t, s.End(ctx, code), ErrInvalidRefreshToken)
+
+	tokens, err := s.ExchangeLoginCode(ctx, code)
+	assert.Nil(t, err)
+	claims, err := ParseJWT(tokens.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", claims.Id)
+	assert.Nil(t, s.Check(ctx, claims))
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	assert.Nil(t, err)
+
+	// Each code can only be exchanged once, and refresh tokens can't be exchanged
+	_, err = s.ExchangeLoginCode(ctx, code)
+	assert.ErrorIs(t, err, ErrInvalidLoginCode)
+	_, err = s.ExchangeLoginCode(ctx, tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidLoginCode)
+	_, err = s.ExchangeLoginCode(ctx, "malformed")
+	assert.ErrorIs(t, err, ErrInvalidLoginCode)
+
+	// A code with the wrong secret doesn't burn the real one
+	code, err = s.IssueLoginCode(ctx, "p2")
+	assert.Nil(t, err)
+	id, _, _ := strings.Cut(code, ".")
+	_, err = s.ExchangeLoginCode(ctx, id+".wrong")
+	assert.ErrorIs(t, err, ErrInvalidLoginCode)
+	_, err = s.ExchangeLoginCode(ctx, code)
+	assert.Nil(t, err)
+}
+
+func TestLoginCodeExpiry(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	code, err := s.IssueLoginCode(ctx, "p1")
+	assert.Nil(t, err)
+	id, _, _ := strings.Cut(code, ".")
+	session, err := s.store.Get(ctx, id)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(loginCodeTTL), session.Expires, time.Second)
+	assert.True(t, session.LoginCode)
+
+	// Placeholder sessions of codes don't make access tokens with their id valid
+	active, err := s.Active(ctx, id)
+	assert.Nil(t, err)
+	assert.False(t, active)
+
+	session.Expires = time.Now().Add(-time.Second)
+	s.store.(*MemorySessionStore).sessions[id] = *session
+	_, err = s.ExchangeLoginCode(ctx, code)
+	assert.ErrorIs(t, err, ErrInvalidLoginCode)
+}
+
+func TestEndGuestSessions(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	guest, err := s.StartGuest(ctx, "p1")
+	assert.Nil(t, err)
+	guestClaims, err := ParseJWT(guest.AccessToken)
+	assert.Nil(t, err)
+	assert.True(t, guestClaims.Guest)
+	full, err := s.Start(ctx, "p1")
+	assert.Nil(t, err)
+	fullClaims, err := ParseJWT(full.AccessToken)
+	assert.Nil(t, err)
+	other, err := s.StartGuest(ctx, "p2")
+	assert.Nil(t, err)
+	otherClaims, err := ParseJWT(other.AccessToken)
+	assert.Nil(t, err)
+
+	// Only the guest sessions of the upgraded player end
+	assert.Nil(t, s.EndGuestSessions(ctx, "p1"))
+	assert.ErrorIs(t, s.Check(ctx, guestClaims), ErrSessionRevoked)
+	_, err = s.Refresh(ctx, guest.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.Nil(t, s.Check(ctx, fullClaims))
+	assert.Nil(t, s.Check(ctx, otherClaims))
+}
+
+func sessionIDs(sessions []*Session) []string {
+	var ids []string
+	for _, s := range sessions {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestCheckLegacyTokens(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+
+	// Tokens issued before sessions have no session, and are accepted until they expire
+	assert.Nil(t, s.Check(ctx, &Claims{Id: "p1"}))
+	assert.ErrorIs(t, s.Check(ctx, &Claims{Id: "p1", SessionId: "unknown"}), ErrSessionRevoked)
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleforgames/global-multiplayer-demo/services/frontend-api/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error codes of error responses. Clients can rely on them, unlike on error messages.
+const (
+	// Generic codes, one per status code
+	CodeInvalidRequest      = "invalid_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeRateLimited         = "rate_limited"
+	CodeInternal            = "internal"
+	CodeUnavailable         = "unavailable"
+	CodeUpstreamError       = "upstream_error"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+
+	// Authentication
+	CodeTokenExpired        = "token_expired"
+	CodeSessionRevoked      = "session_revoked"
+	CodeInvalidRefreshToken = "invalid_refresh_token"
+	CodeInvalidLoginCode    = "invalid_login_code"
+	CodeGuestUpgraded       = "guest_upgraded"
+	CodeIdentityLinked      = "identity_linked"
+
+	// Matchmaking and parties
+	CodeTicketNotFound    = "ticket_not_found"
+	CodePartyNotFound     = "party_not_found"
+	CodeNotPartyMember    = "not_party_member"
+	CodeNotPartyLeader    = "not_party_leader"
+	CodeInOtherParty      = "in_other_party"
+	CodePartyFull         = "party_full"
+	CodePartySearching    = "party_searching"
+	CodePartyNotSearching = "party_not_searching"
+	CodePartyChanged      = "party_changed"
+	CodeNoCommonRegion    = "no_common_region"
+)
+
+// CodeForStatus returns the generic error code of the status code
+func CodeForStatus(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusServiceUnavailable:
+		return CodeUpstreamUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	}
+	if code >= 400 && code < 500 {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
+
+// Retryable reports whether requests that failed with the status code may succeed if sent again unchanged
+func Retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// HandleError responds with the error and the generic error code of the status code, if there is an error
+func HandleError(c *gin.Context, code int, context string, err error) bool {
+	return HandleErrorCode(c, code, CodeForStatus(code), context, err)
+}
+
+// HandleErrorCode responds with the error and the error code, if there is an error
+func HandleErrorCode(c *gin.Context, code int, errorCode string, context string, err error) bool {
+	if err == nil {
+		return false
+	}
+	writeError(c, code, models.ErrorResponse{Error: err.Error(), Code: errorCode, Context: context})
+	log.Printf("error occured @ %s [%s]: %s\n", context, RequestIDFromContext(c.Request.Context()), err.Error())
+	return true
+}
+
+// HandleUpstreamError responds with the status code matching the failure of a service we depend on, if there is an
+// error: 504 if it timed out, 503 if it can't be reached or is temporarily unavailable, else 502
+func HandleUpstreamError(c *gin.Context, context string, err error) bool {
+	if err == nil {
+		return false
+	}
+	return HandleError(c, UpstreamStatus(err), context, err)
+}
+
+// UpstreamStatus returns the status code matching the failure of a service we depend on
+func UpstreamStatus(err error) int {
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+
+	// Errors of gRPC services, such as Open Match
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch grpcErr.GRPCStatus().Code() {
+		case codes.DeadlineExceeded:
+			return http.StatusGatewayTimeout
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusBadGateway
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return http.StatusServiceUnavailable
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// UpstreamStatusCode returns the status code matching an error response of a service we depend on
+func UpstreamStatusCode(code int) int {
+	switch code {
+	case http.StatusGatewayTimeout:
+		return http.StatusGatewayTimeout
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// HandleValidationError responds with a 400 listing the invalid fields of the request, if there are any
+func HandleValidationError(c *gin.Context, context string, fields []models.FieldError) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	writeError(c, http.StatusBadRequest, models.ErrorResponse{Error: "invalid request", Code: CodeInvalidRequest, Context: context, Fields: fields})
+	log.Printf("invalid request @ %s [%s]: %v\n", context, RequestIDFromContext(c.Request.Context()), fields)
+	return true
+}
+
+// writeError responds with the error, completing it with the request id and whether to retry
+func writeError(c *gin.Context, code int, resp models.ErrorResponse) {
+	resp.RequestId = RequestIDFromContext(c.Request.Context())
+	resp.Retryable = Retryable(code)
+	c.AbortWithStatusJSON(code, resp)
+}
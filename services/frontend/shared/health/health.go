@@ -0,0 +1,216 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health provides the liveness and readiness endpoints of the frontend API, and serves it until it is told to
+// shut down, draining requests in flight first.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long each readiness check may take
+const checkTimeout = 2 * time.Second
+
+// Status values of the health endpoints
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	StatusDraining    = "draining"
+)
+
+// Check returns an error if a dependency of the service isn't ready
+type Check func(ctx context.Context) error
+
+// Response is the body of the health endpoints. Checks holds the result of each readiness check.
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Health tracks whether the service is ready to serve requests
+type Health struct {
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]Check
+	onDrain  []func()
+	draining atomic.Bool
+}
+
+// New returns a Health without any readiness checks
+func New() *Health {
+	return &Health{checks: map[string]Check{}}
+}
+
+// AddCheck adds a readiness check. The service is only ready while all of its checks pass.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// OnDrain adds a function that is called when the service starts draining, e.g. to cancel long waits of requests in
+// flight
+func (h *Health) OnDrain(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDrain = append(h.onDrain, fn)
+}
+
+// Drain makes the service unready for good, so load balancers stop sending it requests, and calls the functions added
+// with OnDrain the first time
+func (h *Health) Drain() {
+	if !h.draining.CompareAndSwap(false, true) {
+		return
+	}
+	h.mu.Lock()
+	onDrain := append([]func(){}, h.onDrain...)
+	h.mu.Unlock()
+	for _, fn := range onDrain {
+		fn()
+	}
+}
+
+// Draining returns whether Drain was called
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready runs the readiness checks, returning whether all of them passed and the result of each
+func (h *Health) Ready(ctx context.Context) (bool, map[string]string) {
+	h.mu.Lock()
+	names := append([]string{}, h.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.Unlock()
+
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			results[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	ready := true
+	statuses := map[string]string{}
+	for i, err := range results {
+		statuses[names[i]] = StatusOK
+		if err != nil {
+			ready = false
+			statuses[names[i]] = err.Error()
+		}
+	}
+	return ready, statuses
+}
+
+// Register adds the /healthz liveness and /readyz readiness endpoints to the router
+func (h *Health) Register(r gin.IRoutes) {
+	r.GET("/healthz", h.handleLive)
+	r.GET("/readyz", h.handleReady)
+}
+
+// Liveness handler. The service is alive as long as it responds, even while draining
+func (h *Health) handleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, Response{Status: StatusOK})
+}
+
+// Readiness handler. Responds with 503 while draining, or if any of the readiness checks fails
+func (h *Health) handleReady(c *gin.Context) {
+	if h.Draining() {
+		c.JSON(http.StatusServiceUnavailable, Response{Status: StatusDraining})
+		return
+	}
+
+	ready, checks := h.Ready(c.Request.Context())
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, Response{Status: StatusUnavailable, Checks: checks})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Status: StatusOK, Checks: checks})
+}
+
+// ShutdownConfig contains how the service shuts down
+type ShutdownConfig struct {
+	// DrainDelay is how long the service keeps serving new requests while unready, until load balancers noticed it is
+	// going away
+	DrainDelay time.Duration
+	// Timeout is how long requests in flight then have to complete
+	Timeout time.Duration
+}
+
+// ListenAndServe listens on the address of the server, and serves it like Serve
+func ListenAndServe(ctx context.Context, srv *http.Server, h *Health, cfg ShutdownConfig) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, ln, srv, h, cfg)
+}
+
+// Serve serves the server on the listener until ctx is done, then drains it: the service becomes unready, keeps
+// serving for the drain delay, and is shut down, waiting for requests in flight, and their calls to Open Match and
+// the profile service, to complete. Returns nil once all requests completed, or an error if the server failed or
+// requests were still in flight after the timeout.
+func Serve(ctx context.Context, ln net.Listener, srv *http.Server, h *Health, cfg ShutdownConfig) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining for %s", cfg.DrainDelay)
+	h.Drain()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(cfg.DrainDelay):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not drain requests in flight: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Print("Shut down")
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redis connects the frontend to the Redis server it shares tickets, parties, sessions and device codes
+// through across replicas.
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// How long to wait for Redis when the request context has no deadline.
+const defaultTimeout = 2 * time.Second
+
+// Config configures the connection to Redis.
+type Config struct {
+	// Addr is the host:port of the Redis server.
+	Addr string
+	// Password is sent with AUTH, if set.
+	Password string
+	// TLS enables TLS, verifying the server against CACert, or the system's roots if empty.
+	TLS    bool
+	CACert string
+}
+
+// NewClient returns a client for the Redis server of the config. Commands are sent over a pool of connections,
+// which are dialled on demand and replaced when they fail.
+func NewClient(cfg Config) (*goredis.Client, error) {
+	opts := &goredis.Options{
+		Addr:         cfg.Addr,
+		Password:     cfg.Password,
+		DialTimeout:  defaultTimeout,
+		ReadTimeout:  defaultTimeout,
+		WriteTimeout: defaultTimeout,
+		// Commands give up when their request does, rather than after the timeouts above
+		ContextTimeoutEnabled: true,
+	}
+
+	if cfg.TLS || cfg.CACert != "" {
+		tc := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CACert != "" {
+			pem, err := os.ReadFile(cfg.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("can't read Redis CA certificate: %w", err)
+			}
+			tc.RootCAs = x509.NewCertPool()
+			if !tc.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in Redis CA certificate %s", cfg.CACert)
+			}
+		}
+		opts.TLSConfig = tc
+	}
+
+	return goredis.NewClient(opts), nil
+}
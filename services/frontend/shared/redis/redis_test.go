@@ -0,0 +1,64 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("secret")
+
+	rc, err := NewClient(Config{Addr: mr.Addr()})
+	assert.Nil(t, err)
+	assert.NotNil(t, rc.Ping(context.Background()).Err())
+	rc.Close()
+
+	rc, err = NewClient(Config{Addr: mr.Addr(), Password: "secret"})
+	assert.Nil(t, err)
+	defer rc.Close()
+	assert.Nil(t, rc.Ping(context.Background()).Err())
+}
+
+func TestNewClientReconnects(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc, err := NewClient(Config{Addr: mr.Addr()})
+	assert.Nil(t, err)
+	defer rc.Close()
+	ctx := context.Background()
+	assert.Nil(t, rc.Set(ctx, "k", "v", 0).Err())
+
+	mr.Close()
+	assert.NotNil(t, rc.Get(ctx, "k").Err())
+
+	// Broken connections are replaced once Redis is back
+	assert.Nil(t, mr.Restart())
+	v, err := rc.Get(ctx, "k").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "v", v)
+}
+
+func TestNewClientCACert(t *testing.T) {
+	_, err := NewClient(Config{Addr: "localhost:6379", TLS: true, CACert: "does-not-exist.pem"})
+	assert.NotNil(t, err)
+
+	_, err = NewClient(Config{Addr: "localhost:6379", TLS: true})
+	assert.Nil(t, err)
+}
@@ -16,16 +16,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	allocation "github.com/googleforgames/global-multiplayer-demo/services/open-match/director/agones/swagger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"open-match.dev/open-match/pkg/pb"
 )
 
@@ -45,6 +55,12 @@ const (
 
 	// Namespace to allocate from
 	gameNamespace = "default"
+
+	// Assignment extension with the id of the match, which the frontend puts in the join tokens of the players
+	matchIDExtension = "match_id"
+
+	// Address of the health and metrics endpoints
+	healthAddr = ":8080"
 )
 
 // TODO: This should be an environment variable.
@@ -53,6 +69,10 @@ var regions = []string{"us-central1", "europe-west1", "asia-east1"}
 func main() {
 	ctx := context.Background()
 
+	// Stop fetching matches on SIGTERM, which Kubernetes sends before killing the pod, or on interrupt
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Connect to Open Match Backend.
 	conn, err := grpc.NewClient(omBackendEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -78,7 +98,15 @@ func main() {
 	profiles := generateProfiles()
 	log.Printf("Fetching matches for %v profiles", len(profiles))
 
-	for range time.Tick(time.Second * 5) {
+	var stopping atomic.Bool
+	srv := &http.Server{Addr: healthAddr, Handler: healthHandler(conn, &stopping)}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve health endpoints, got %s", err.Error())
+		}
+	}()
+
+	runRounds(stopCtx, time.Second*5, func() {
 		// Fetch matches for each profile and make random assignments for Tickets in
 		// the matches returned.
 		var wg sync.WaitGroup
@@ -88,9 +116,11 @@ func main() {
 				defer wg.Done()
 				matches, err := fetch(be, p)
 				if err != nil {
+					fetchFailures.WithLabelValues(p.GetName()).Inc()
 					log.Printf("Failed to fetch matches for profile %v, got %s", p.GetName(), err.Error())
 					return
 				}
+				matchesFetched.WithLabelValues(p.GetName()).Add(float64(len(matches)))
 
 				log.Printf("Generated %v matches for profile %v", len(matches), p.GetName())
 				for _, match := range matches {
@@ -100,9 +130,64 @@ func main() {
 		}
 
 		wg.Wait()
+	})
+
+	log.Printf("Shutting down")
+	stopping.Store(true)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		log.Printf("Failed to shut down health endpoints, got %s", err.Error())
+	}
+}
+
+// runRounds runs round every interval until ctx is done. A round in progress is finished first, so no game server is
+// allocated without its players.
+func runRounds(ctx context.Context, interval time.Duration, round func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Both are picked at random when ready at once
+		if ctx.Err() != nil {
+			return
+		}
+		round()
 	}
 }
 
+// healthHandler serves the /healthz liveness endpoint, the /readyz readiness endpoint, which fails while the
+// director is stopping or can't reach the Open Match Backend, and the Prometheus metrics on /metrics.
+func healthHandler(conn *grpc.ClientConn, stopping *atomic.Bool) http.Handler {
+	respond := func(w http.ResponseWriter, code int, status string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if stopping.Load() {
+			respond(w, http.StatusServiceUnavailable, "draining")
+			return
+		}
+		// The connection is idle until the first fetch, and connects on the next one
+		switch state := conn.GetState(); state {
+		case connectivity.Ready, connectivity.Idle:
+			respond(w, http.StatusOK, "ok")
+		default:
+			respond(w, http.StatusServiceUnavailable, fmt.Sprintf("connection to Open Match Backend is %s", state))
+		}
+	})
+	return mux
+}
+
 func fetch(be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error) {
 	req := &pb.FetchMatchesRequest{
 		Config: &pb.FunctionConfig{
@@ -137,15 +222,23 @@ func fetch(be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error)
 
 // assignMatch assigns `match`. If we fail, abandon the tickets - we'll catch it next loop.
 func assignMatch(ctx context.Context, be pb.BackendServiceClient, aas *allocation.APIClient, match *pb.Match) {
+	region := match.GetMatchProfile()
+	start := time.Now()
 	aar, _, err := aas.AllocationServiceApi.Allocate(ctx, allocation.AllocationAllocationRequest{Namespace: gameNamespace})
+	allocationDuration.WithLabelValues(region).Observe(time.Since(start).Seconds())
 	if err != nil {
 		var swErr allocation.GenericSwaggerError
 		if errors.As(err, &swErr) {
+			allocationFailures.WithLabelValues(region, causeRejected).Inc()
 			log.Printf("Could not allocate game server from Agones for match %s: %s: %s", match.GetMatchId(), swErr.Error(), swErr.Body())
+			return
 		}
+		allocationFailures.WithLabelValues(region, causeUnreachable).Inc()
+		log.Printf("Could not reach Agones to allocate game server for match %s: %s", match.GetMatchId(), err.Error())
 		return
 	}
 	if len(aar.Ports) < 1 {
+		allocationFailures.WithLabelValues(region, causeNoPorts).Inc()
 		log.Printf("Expecting at least one port from Agones allocation. Response: %v", aar)
 		return
 	}
@@ -153,31 +246,40 @@ func assignMatch(ctx context.Context, be pb.BackendServiceClient, aas *allocatio
 	conn := fmt.Sprintf("%s:%d", aar.Address, aar.Ports[0].Port)
 	log.Printf("Allocated %s for match %s. Payload: %v", conn, match.GetMatchId(), aar)
 
-	if err := assignConnToTickets(be, conn, match.GetTickets()); err != nil {
+	if err := assignConnToTickets(be, conn, match.GetMatchId(), match.GetTickets()); err != nil {
+		allocationFailures.WithLabelValues(region, causeAssignment).Inc()
 		log.Printf("Could not assign connection %s to match %s: %v", conn, match.GetMatchId(), err)
+		return
 	}
 
+	matchesAssigned.WithLabelValues(region).Inc()
 	log.Printf("Assigned %s to match %s", conn, match.GetMatchId())
 }
 
-func assignConnToTickets(be pb.BackendServiceClient, conn string, tickets []*pb.Ticket) error {
+func assignConnToTickets(be pb.BackendServiceClient, conn, matchID string, tickets []*pb.Ticket) error {
 	var ticketIDs []string
 	for _, t := range tickets {
 		ticketIDs = append(ticketIDs, t.Id)
 	}
 
+	id, err := anypb.New(wrapperspb.String(matchID))
+	if err != nil {
+		return fmt.Errorf("can't encode match id: %w", err)
+	}
+
 	req := &pb.AssignTicketsRequest{
 		Assignments: []*pb.AssignmentGroup{
 			{
 				TicketIds: ticketIDs,
 				Assignment: &pb.Assignment{
 					Connection: conn,
+					Extensions: map[string]*anypb.Any{matchIDExtension: id},
 				},
 			},
 		},
 	}
 
-	_, err := be.AssignTickets(context.Background(), req)
+	_, err = be.AssignTickets(context.Background(), req)
 	return err
 }
 
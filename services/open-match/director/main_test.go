@@ -0,0 +1,99 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestRunRoundsFinishesRoundInProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var rounds, finished int32
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runRounds(ctx, 10*time.Millisecond, func() {
+			if atomic.AddInt32(&rounds, 1) == 1 {
+				close(started)
+				<-release
+			}
+			atomic.AddInt32(&finished, 1)
+		})
+	}()
+	<-started
+
+	// Stopping waits for the round in progress, and starts no other
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("runRounds returned before the round in progress finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	assert.Equal(t, int32(1), atomic.LoadInt32(&rounds))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+}
+
+func TestRunRoundsStopsWhileIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runRounds(ctx, time.Hour, func() { t.Error("no round runs once stopped") })
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runRounds didn't stop")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	// Not connected until used, so it is idle
+	conn, err := grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	var stopping atomic.Bool
+	h := healthHandler(conn, &stopping)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/metrics"))
+
+	// Unready while stopping, but still alive
+	stopping.Store(true)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Causes of allocation failures
+const (
+	// The Agones allocator could not be reached, or timed out
+	causeUnreachable = "unreachable"
+	// The Agones allocator rejected the allocation, e.g. because there is no ready GameServer in the region
+	causeRejected = "rejected"
+	// The allocated GameServer has no port to connect to
+	causeNoPorts = "no_ports"
+	// The tickets of the match could not be assigned the allocated GameServer
+	causeAssignment = "assignment"
+)
+
+var (
+	// matchesFetched counts the matches fetched from Open Match, for each profile.
+	matchesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "director",
+		Name:      "matches_fetched_total",
+		Help:      "Matches fetched from Open Match, by match profile.",
+	}, []string{"profile"})
+
+	// fetchFailures counts the failures to fetch matches from Open Match, for each profile.
+	fetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "director",
+		Name:      "fetch_failures_total",
+		Help:      "Failures to fetch matches from Open Match, by match profile.",
+	}, []string{"profile"})
+
+	// matchesAssigned counts the matches whose tickets were assigned a GameServer, for each region.
+	matchesAssigned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "director",
+		Name:      "matches_assigned_total",
+		Help:      "Matches whose tickets were assigned a GameServer, by region.",
+	}, []string{"region"})
+
+	// allocationFailures counts the matches that could not be assigned a GameServer, for each region and cause.
+	allocationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "director",
+		Name:      "allocation_failures_total",
+		Help:      "Matches that could not be assigned a GameServer, by region and cause: unreachable, rejected, no_ports or assignment.",
+	}, []string{"region", "cause"})
+
+	// allocationDuration is how long the Agones allocator takes to allocate a GameServer, for each region.
+	allocationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "director",
+		Name:      "allocation_duration_seconds",
+		Help:      "Time taken by the Agones allocator to allocate a GameServer, by region.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"region"})
+)
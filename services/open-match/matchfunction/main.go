@@ -34,10 +34,11 @@ import (
 const (
 	queryServiceAddress = "open-match-query.open-match.svc.cluster.local:50503" // Address of the QueryService endpoint.
 	serverPort          = 50502                                                 // The port for hosting the Match Function.
+	metricsPort         = 8080                                                  // The port for serving the Prometheus metrics.
 )
 
 func main() {
-	mmf.Start(queryServiceAddress, serverPort, playersPerMatch())
+	mmf.Start(queryServiceAddress, serverPort, metricsPort, playersPerMatch())
 }
 
 func playersPerMatch() int {
@@ -35,11 +35,13 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 	p := req.GetProfile()
 	log.Printf("Generating proposals for profile %v", p.GetName())
 
-	tickets, err := matchfunction.QueryPool(stream.Context(), s.queryServiceClient, p.GetPools()[0])
+	pool := p.GetPools()[0]
+	tickets, err := matchfunction.QueryPool(stream.Context(), s.queryServiceClient, pool)
 	if err != nil {
-		log.Printf("Failed to query tickets pool %q: %v", p.GetPools()[0], err)
+		log.Printf("Failed to query tickets pool %q: %v", pool, err)
 		return err
 	}
+	poolTickets.WithLabelValues(pool.GetName()).Set(float64(len(tickets)))
 
 	// Generate proposals.
 	idPrefix := fmt.Sprintf("profile-%v-time-%v", p.GetName(), time.Now().Format("2006-01-02T15:04:05.00"))
@@ -49,6 +51,13 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 		return err
 	}
 
+	matchesProposed.WithLabelValues(p.GetName()).Add(float64(len(proposals)))
+	matched := 0
+	for _, proposal := range proposals {
+		matched += len(proposal.GetTickets())
+	}
+	unmatchedTickets.WithLabelValues(p.GetName()).Set(float64(len(tickets) - matched))
+
 	// Stream the generated proposals back to Open Match.
 	log.Printf("Streaming %v proposals to Open Match", len(proposals))
 	for _, proposal := range proposals {
@@ -61,9 +70,14 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 	return nil
 }
 
-// Find all matches for the given profile.
+// Find all matches for the given profile. A party ticket takes as many of the match's player slots as it has
+// members, and is never split across matches.
 func (s *MatchFunctionService) makeMatches(profileName, idPrefix string, tickets []*pb.Ticket) ([]*pb.Match, error) {
-	if len(tickets) < s.playersPerMatch {
+	slots := 0
+	for _, ticket := range tickets {
+		slots += partySize(ticket)
+	}
+	if slots < s.playersPerMatch {
 		return nil, nil
 	}
 
@@ -78,9 +92,24 @@ func (s *MatchFunctionService) makeMatches(profileName, idPrefix string, tickets
 
 	var matches []*pb.Match
 	count := 0
-	for len(tickets) >= s.playersPerMatch {
-		matchTickets := tickets[:s.playersPerMatch]
-		tickets = tickets[s.playersPerMatch:]
+	for {
+		// Fill the match in score order, skipping the parties that don't fit in the slots left. They are
+		// considered again for the next match.
+		var matchTickets, rest []*pb.Ticket
+		filled := 0
+		for _, ticket := range tickets {
+			size := partySize(ticket)
+			if filled+size > s.playersPerMatch {
+				rest = append(rest, ticket)
+				continue
+			}
+			matchTickets = append(matchTickets, ticket)
+			filled += size
+		}
+		if filled < s.playersPerMatch {
+			break
+		}
+		tickets = rest
 
 		var matchScore float64
 		for _, ticket := range matchTickets {
@@ -102,11 +131,28 @@ func (s *MatchFunctionService) makeMatches(profileName, idPrefix string, tickets
 		})
 		count++
 	}
+
+	for _, ticket := range tickets {
+		if partySize(ticket) > s.playersPerMatch {
+			log.Printf("Ticket %s: party of %d can't fit in a match of %d players", ticket.Id, partySize(ticket), s.playersPerMatch)
+		}
+	}
 	return matches, nil
 }
 
+// partySize returns the number of players on the ticket. Tickets without a party size are for a single player.
+func partySize(ticket *pb.Ticket) int {
+	size := int(ticket.SearchFields.DoubleArgs["party_size"])
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
 func score(skill, latency float64) float64 {
-	// skill is kill/death, latency is in milliseconds - aggregate in a way that the higher the score, the better
-	// (so we subtract latency, since lower latency is better).
+	// skill is the Glicko-2 rating of the ticket's players (1500 for new players, the average of the members for a
+	// party), latency is in milliseconds. Tickets are matched in score order, so players of a similar rating end
+	// up together. Latency is subtracted in seconds, since lower latency is better: next to the spread of ratings it
+	// only breaks ties between tickets of about the same rating.
 	return skill - (latency / 1000.0)
 }
@@ -0,0 +1,102 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+const testProfile = "us-central1"
+
+func ticket(id string, skill float64, partySize int) *pb.Ticket {
+	args := map[string]float64{"skill": skill, "latency-" + testProfile: 20}
+	if partySize > 0 {
+		args["party_size"] = float64(partySize)
+	}
+	return &pb.Ticket{Id: id, SearchFields: &pb.SearchFields{DoubleArgs: args}}
+}
+
+func ticketIDs(m *pb.Match) []string {
+	var ids []string
+	for _, t := range m.GetTickets() {
+		ids = append(ids, t.Id)
+	}
+	return ids
+}
+
+func TestMakeMatchesKeepsPartiesTogether(t *testing.T) {
+	s := &MatchFunctionService{playersPerMatch: 3}
+	tickets := []*pb.Ticket{
+		ticket("solo-1", 1600, 0),
+		ticket("party-2", 1700, 2),
+		ticket("solo-2", 1500, 1),
+		ticket("party-3", 1400, 3),
+	}
+
+	matches, err := s.makeMatches(testProfile, "test", tickets)
+	assert.Nil(t, err)
+	// The second party doesn't fit next to the first one, nor next to the solo player left, and isn't split
+	assert.Len(t, matches, 1)
+	assert.Equal(t, []string{"party-2", "solo-1"}, ticketIDs(matches[0]))
+	assert.Equal(t, "test-0", matches[0].MatchId)
+}
+
+func TestMakeMatchesFillsWithParties(t *testing.T) {
+	s := &MatchFunctionService{playersPerMatch: 4}
+	tickets := []*pb.Ticket{
+		ticket("party-a", 1500, 2),
+		ticket("party-b", 1500, 2),
+		ticket("party-c", 1500, 3),
+		ticket("solo", 1500, 0),
+	}
+
+	matches, err := s.makeMatches(testProfile, "test", tickets)
+	assert.Nil(t, err)
+	assert.Len(t, matches, 2)
+	for _, m := range matches {
+		size := 0
+		for _, t := range m.GetTickets() {
+			size += partySize(t)
+		}
+		assert.Equal(t, 4, size)
+	}
+}
+
+func TestMakeMatchesSkipsOversizedParties(t *testing.T) {
+	s := &MatchFunctionService{playersPerMatch: 2}
+	tickets := []*pb.Ticket{
+		ticket("party", 1500, 3),
+		ticket("solo", 1500, 0),
+	}
+
+	matches, err := s.makeMatches(testProfile, "test", tickets)
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestPartySize(t *testing.T) {
+	assert.Equal(t, 1, partySize(ticket("t", 1500, 0)))
+	assert.Equal(t, 1, partySize(ticket("t", 1500, 1)))
+	assert.Equal(t, 3, partySize(ticket("t", 1500, 3)))
+}
+
+func TestScore(t *testing.T) {
+	// Rating decides, latency breaks ties
+	assert.Greater(t, score(1600, 200), score(1500, 20))
+	assert.Greater(t, score(1500, 20), score(1500, 200))
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmf
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// poolTickets is the number of tickets in each pool, as of the last run for the pool.
+	poolTickets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mmf",
+		Name:      "pool_tickets",
+		Help:      "Tickets in the pool, as of the last run of the match function for the pool.",
+	}, []string{"pool"})
+
+	// matchesProposed counts the matches proposed to Open Match, for each profile.
+	matchesProposed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mmf",
+		Name:      "matches_total",
+		Help:      "Matches proposed to Open Match, by match profile.",
+	}, []string{"profile"})
+
+	// unmatchedTickets is the number of tickets left without a match, as of the last run for each profile.
+	unmatchedTickets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mmf",
+		Name:      "unmatched_tickets",
+		Help:      "Tickets left without a match, as of the last run of the match function for the profile.",
+	}, []string{"profile"})
+)
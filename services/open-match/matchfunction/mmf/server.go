@@ -18,7 +18,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"open-match.dev/open-match/pkg/pb"
@@ -35,8 +37,9 @@ type MatchFunctionService struct {
 
 // Start creates and starts the Match Function server and also connects to Open
 // Match's queryService service. This connection is used at runtime to fetch tickets
-// for pools specified in MatchProfile.
-func Start(queryServiceAddr string, serverPort int, playersPerMatch int) {
+// for pools specified in MatchProfile. Prometheus metrics are served on /metrics
+// of the metrics port.
+func Start(queryServiceAddr string, serverPort int, metricsPort int, playersPerMatch int) {
 	// Connect to QueryService.
 
 	conn, err := grpc.NewClient(queryServiceAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -50,6 +53,16 @@ func Start(queryServiceAddr string, serverPort int, playersPerMatch int) {
 		playersPerMatch:    playersPerMatch,
 	}
 
+	// Serve the metrics of the match function.
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		log.Printf("Metrics listener initialized for port %v", metricsPort)
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", metricsPort), mux); err != nil {
+			log.Fatalf("Metrics serve failed, got %s", err.Error())
+		}
+	}()
+
 	// Create and host a new gRPC service on the configured port.
 	server := grpc.NewServer()
 	pb.RegisterMatchFunctionServer(server, &mmfService)
@@ -23,10 +23,15 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
@@ -41,22 +46,75 @@ const (
 	cacheKey        = "json"
 	external        = "EXTERNAL"
 	pingServiceName = "agones-ping-udp-service"
+
+	// On shutdown, keep serving while unready until load balancers noticed, then give requests in flight time to
+	// complete. Kubernetes kills pods 30 seconds after asking them to stop by default.
+	drainDelay      = 5 * time.Second
+	shutdownTimeout = 20 * time.Second
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the ping discovery service until it is told to shut down. Returns an error if it couldn't be served,
+// once the forwarding client is closed
+func run() error {
 	ctx := context.Background()
 
 	forwardingClient, err := compute.NewForwardingRulesRESTClient(ctx)
 	if err != nil {
-		log.Fatalf("could not get forwarding client: %s", err)
+		return fmt.Errorf("could not get forwarding client: %w", err)
 	}
 	defer forwardingClient.Close()
 	cache := newCache(ctx, forwardingClient)
 
+	// Shut down on SIGTERM, which Kubernetes sends before killing the pod, or on interrupt
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	var draining atomic.Bool
+
+	r, err := newRouter(cache, &draining)
+	if err != nil {
+		return err
+	}
+
+	// Listens on PORT, like gin does by default
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serve(stopCtx, ln, &http.Server{Handler: r}, &draining, drainDelay, shutdownTimeout)
+}
+
+// newRouter returns the router of the ping discovery service, which is unready while draining
+func newRouter(cache *ttlcache.Cache[string, []Service], draining *atomic.Bool) (*gin.Engine, error) {
 	r := gin.Default()
 	if err := r.SetTrustedProxies(nil); err != nil {
-		log.Fatalf("error setting trusted proxy: %s", err)
+		return nil, fmt.Errorf("error setting trusted proxy: %w", err)
 	}
+
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	// Ready once the ping services are cached, so /list can be served
+	r.GET("/readyz", func(c *gin.Context) {
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		if cache.Get(cacheKey) == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "cached service list not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
 	r.GET("/list", func(c *gin.Context) {
 		services, err := getPingServicePerRegion(cache)
 		if err != nil {
@@ -67,10 +125,41 @@ func main() {
 		log.Printf("%v", services)
 		c.JSON(http.StatusOK, services)
 	})
+	return r, nil
+}
 
-	if err = r.Run(); err != nil {
-		log.Fatal(err)
+// serve serves srv on the listener until ctx is done, then drains it: it becomes unready, keeps serving for the
+// drain delay, and is shut down, waiting up to the shutdown timeout for requests in flight. Returns nil once all
+// requests completed, or an error if the server failed or requests were still in flight after the timeout.
+func serve(ctx context.Context, ln net.Listener, srv *http.Server, draining *atomic.Bool, drainDelay, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining for %s", drainDelay)
+	draining.Store(true)
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(drainDelay):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not drain requests in flight: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
 }
 
 // Service is the details of an individual Ping Service endpoint.
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func get(r http.Handler, path string) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cache := ttlcache.New[string, []Service]()
+	var draining atomic.Bool
+	r, err := newRouter(cache, &draining)
+	assert.Nil(t, err)
+
+	// Unready until the ping services are cached
+	assert.Equal(t, http.StatusServiceUnavailable, get(r, "/readyz"))
+	cache.Set(cacheKey, []Service{{Name: pingServiceName, Region: "us-central1"}}, ttlcache.DefaultTTL)
+	assert.Equal(t, http.StatusOK, get(r, "/readyz"))
+
+	// Unready for good while draining, but still alive
+	draining.Store(true)
+	assert.Equal(t, http.StatusServiceUnavailable, get(r, "/readyz"))
+	assert.Equal(t, http.StatusOK, get(r, "/healthz"))
+}
+
+// serveSlow serves a handler blocking until release is closed, until ctx is done
+func serveSlow(t *testing.T, ctx context.Context, draining *atomic.Bool, drainDelay, timeout time.Duration, started chan<- struct{}, release <-chan struct{}) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve(ctx, ln, &http.Server{Handler: handler}, draining, drainDelay, timeout)
+	}()
+	return "http://" + ln.Addr().String(), errs
+}
+
+func TestServeDrainsRequestsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var draining atomic.Bool
+	started, release := make(chan struct{}), make(chan struct{})
+	url, errs := serveSlow(t, ctx, &draining, 200*time.Millisecond, 5*time.Second, started, release)
+
+	responses := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			responses <- 0
+			return
+		}
+		res.Body.Close()
+		responses <- res.StatusCode
+	}()
+	<-started
+
+	cancel()
+	assert.Eventually(t, draining.Load, time.Second, 10*time.Millisecond)
+
+	// Still serving new requests during the drain delay
+	res, err := http.Get(url + "/other")
+	assert.Nil(t, err)
+	res.Body.Close()
+
+	// Shutting down waits for the request in flight
+	select {
+	case err := <-errs:
+		t.Fatalf("serve returned before the request in flight completed: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-responses)
+	assert.Nil(t, <-errs)
+	_, err = http.Get(url + "/other")
+	assert.NotNil(t, err)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var draining atomic.Bool
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, errs := serveSlow(t, ctx, &draining, 0, 100*time.Millisecond, started, release)
+
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+}
+
+func TestServeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ln.Close()
+
+	var draining atomic.Bool
+	err = serve(context.Background(), ln, &http.Server{}, &draining, 0, time.Second)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apierror provides the error responses of the profile-service. All error responses have a
+// stable, machine-readable error code, the id of the request and whether the request may succeed if
+// retried.
+package apierror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/models"
+	"google.golang.org/grpc/codes"
+)
+
+// Error codes of the error responses
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeNotFound       = "not_found"
+	CodePlayerNotFound = "player_not_found"
+	CodeConflict       = "conflict"
+	CodeIdentityLinked = "identity_linked"
+	CodeInternal       = "internal"
+	CodeUnavailable    = "unavailable"
+	CodeTimeout        = "timeout"
+)
+
+// RequestIDHeader carries the id of a request, from the calling service and back to it
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDKey is the key of the request id in gin
+const requestIDKey = "request_id"
+
+// Request ids set by callers are kept if they are reasonably short, and safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Response is the body of all error responses
+type Response struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	Request_id string `json:"requestId,omitempty"`
+	Retryable  bool   `json:"retryable"`
+}
+
+// RequestID is a middleware giving each request an id, the one set by the caller if any, else a
+// random one. The id is set in the response's header too.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err == nil {
+				id = hex.EncodeToString(b)
+			}
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Abort responds with the error, with the provided status code and error code
+func Abort(c *gin.Context, status int, code string, err error) {
+	requestID := c.GetString(requestIDKey)
+	log.Printf("error [%s]: %d %s: %s", requestID, status, code, err)
+
+	c.AbortWithStatusJSON(status, Response{
+		Error:      err.Error(),
+		Code:       code,
+		Request_id: requestID,
+		Retryable:  Retryable(status),
+	})
+}
+
+// Handle responds with the error, with the status code and error code matching it
+func Handle(c *gin.Context, err error) {
+	status, code := Status(err)
+	Abort(c, status, code, err)
+}
+
+// Status returns the status code and error code matching the error. Invalid requests and missing
+// or conflicting data are the caller's mistakes, while Spanner being unavailable or timing out
+// may be resolved by retrying.
+func Status(err error) (int, string) {
+	var validationErrors validator.ValidationErrors
+	switch {
+	case errors.Is(err, models.ErrPlayerNotFound):
+		return http.StatusNotFound, CodePlayerNotFound
+	case errors.Is(err, models.ErrIdentityLinked):
+		return http.StatusConflict, CodeIdentityLinked
+	case errors.As(err, &validationErrors):
+		return http.StatusBadRequest, CodeInvalidRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, CodeTimeout
+	}
+
+	switch spanner.ErrCode(err) {
+	case codes.NotFound:
+		return http.StatusNotFound, CodeNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict, CodeConflict
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest, CodeInvalidRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, CodeTimeout
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return http.StatusServiceUnavailable, CodeUnavailable
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// Retryable reports whether requests that failed with the status code may succeed if retried
+func Retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
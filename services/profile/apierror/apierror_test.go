@@ -0,0 +1,109 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/models"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	invalid := validator.New().Struct(models.PlayerIdentity{})
+	assert.NotNil(t, invalid)
+
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{models.ErrPlayerNotFound, http.StatusNotFound, CodePlayerNotFound},
+		{fmt.Errorf("linking: %w", models.ErrIdentityLinked), http.StatusConflict, CodeIdentityLinked},
+		{invalid, http.StatusBadRequest, CodeInvalidRequest},
+		{spanner.ToSpannerError(status.Error(codes.NotFound, "row not found")), http.StatusNotFound, CodeNotFound},
+		{spanner.ToSpannerError(status.Error(codes.AlreadyExists, "row exists")), http.StatusConflict, CodeConflict},
+		{spanner.ToSpannerError(status.Error(codes.Unavailable, "unavailable")), http.StatusServiceUnavailable, CodeUnavailable},
+		{spanner.ToSpannerError(status.Error(codes.DeadlineExceeded, "deadline")), http.StatusGatewayTimeout, CodeTimeout},
+		{errors.New("something else"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, test := range tests {
+		s, code := Status(test.err)
+		assert.Equal(t, test.status, s, test.err.Error())
+		assert.Equal(t, test.code, code, test.err.Error())
+	}
+}
+
+func serve(requestID string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandle(t *testing.T) {
+	w := serve("abc-123", func(c *gin.Context) {
+		Handle(c, spanner.ToSpannerError(status.Error(codes.Unavailable, "unavailable")))
+	})
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+
+	var resp Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeUnavailable, resp.Code)
+	assert.Equal(t, "abc-123", resp.Request_id)
+	assert.True(t, resp.Retryable)
+	assert.NotEmpty(t, resp.Error)
+
+	w = serve("", func(c *gin.Context) {
+		Abort(c, http.StatusBadRequest, CodeInvalidRequest, errors.New("invalid"))
+	})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Code)
+	assert.Equal(t, "invalid", resp.Error)
+	assert.False(t, resp.Retryable)
+}
+
+func TestRequestID(t *testing.T) {
+	// Generated if missing or unsafe to log
+	for _, id := range []string{"", "bad id\n"} {
+		w := serve(id, func(c *gin.Context) { c.Status(http.StatusOK) })
+		assert.Regexp(t, "^[0-9a-f]{32}$", w.Header().Get(RequestIDHeader))
+	}
+}
@@ -21,6 +21,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -29,12 +30,18 @@ import (
 type Config struct {
 	Server  ServerConfig
 	Spanner SpannerConfig
+	Rating  RatingConfig
+	Tiers   TiersConfig
 }
 
 // ServerConfig contains the information to expose the profile service as a server
 type ServerConfig struct {
 	Host string
 	Port int
+	// Drain_delay is how long the server keeps serving while unready when shutting down, and
+	// Shutdown_timeout how long requests in flight then have to complete
+	Drain_delay      time.Duration `mapstructure:"DRAIN_DELAY" yaml:"drain_delay,omitempty"`
+	Shutdown_timeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout,omitempty"`
 }
 
 // SpannerConfig contains information to connect to a Cloud Spanner database
@@ -45,6 +52,26 @@ type SpannerConfig struct {
 	CredentialsFile string `mapstructure:"CREDENTIALS_FILE" yaml:"credentials_file,omitempty"`
 }
 
+// RatingConfig contains the skill rating strategy used to rate players, and its parameters
+type RatingConfig struct {
+	Strategy string
+	Tau      float64
+}
+
+// TiersConfig contains the tiers players are assigned to based on their skill level
+type TiersConfig struct {
+	Placement_games int64        `mapstructure:"PLACEMENT_GAMES" yaml:"placement_games,omitempty"`
+	Hysteresis      int64        `mapstructure:"HYSTERESIS" yaml:"hysteresis,omitempty"`
+	Bands           []TierConfig `mapstructure:"BANDS" yaml:"bands,omitempty"`
+}
+
+// TierConfig contains a single tier, and the minimum skill level of players in it
+type TierConfig struct {
+	Tier      string `mapstructure:"TIER" yaml:"tier,omitempty"`
+	Name      string `mapstructure:"NAME" yaml:"name,omitempty"`
+	Min_skill int64  `mapstructure:"MIN_SKILL" yaml:"min_skill,omitempty"`
+}
+
 // NewConfig initializes the configuration with default values and binds
 // environment variables and reads from any supplied config.yml file
 func NewConfig() (Config, error) {
@@ -57,6 +84,24 @@ func NewConfig() (Config, error) {
 	// Server defaults
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("server.port", 8080)
+	// Kubernetes kills pods 30 seconds after asking them to stop by default
+	viper.SetDefault("server.drain_delay", 5*time.Second)
+	viper.SetDefault("server.shutdown_timeout", 20*time.Second)
+
+	// Rating defaults
+	viper.SetDefault("rating.strategy", "glicko2")
+	viper.SetDefault("rating.tau", 0.5)
+
+	// Tier defaults, based on the Glicko-2 rating scale
+	viper.SetDefault("tiers.placement_games", 5)
+	viper.SetDefault("tiers.hysteresis", 50)
+	viper.SetDefault("tiers.bands", []map[string]interface{}{
+		{"tier": "B", "name": "Bronze", "min_skill": 0},
+		{"tier": "S", "name": "Silver", "min_skill": 1400},
+		{"tier": "G", "name": "Gold", "min_skill": 1550},
+		{"tier": "P", "name": "Platinum", "min_skill": 1700},
+		{"tier": "D", "name": "Diamond", "min_skill": 1850},
+	})
 
 	// Bind environment variable override
 	if err := viper.BindEnv("server.host", "SERVICE_HOST"); err != nil {
@@ -65,6 +110,12 @@ func NewConfig() (Config, error) {
 	if err := viper.BindEnv("server.port", "SERVICE_PORT"); err != nil {
 		return Config{}, fmt.Errorf("could not set environment variable 'server.port': %s", err)
 	}
+	if err := viper.BindEnv("server.drain_delay", "SERVICE_DRAIN_DELAY"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'server.drain_delay': %s", err)
+	}
+	if err := viper.BindEnv("server.shutdown_timeout", "SERVICE_SHUTDOWN_TIMEOUT"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'server.shutdown_timeout': %s", err)
+	}
 	if err := viper.BindEnv("spanner.project_id", "SPANNER_PROJECT_ID"); err != nil {
 		return Config{}, fmt.Errorf("could not set environment variable 'spanner.project_id': %s", err)
 	}
@@ -75,6 +126,20 @@ func NewConfig() (Config, error) {
 		return Config{}, fmt.Errorf("could not set environment variable 'spanner.database_id': %s", err)
 	}
 
+	if err := viper.BindEnv("rating.strategy", "RATING_STRATEGY"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'rating.strategy': %s", err)
+	}
+	if err := viper.BindEnv("rating.tau", "RATING_TAU"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'rating.tau': %s", err)
+	}
+
+	if err := viper.BindEnv("tiers.placement_games", "TIER_PLACEMENT_GAMES"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'tiers.placement_games': %s", err)
+	}
+	if err := viper.BindEnv("tiers.hysteresis", "TIER_HYSTERESIS"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'tiers.hysteresis': %s", err)
+	}
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Printf("[WARNING] could not read config %s\n", err.Error())
 	}
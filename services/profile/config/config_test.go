@@ -21,6 +21,7 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -79,3 +80,77 @@ func TestEnvironmentVariables(t *testing.T) {
 
 	assert.Equal(t, "projects/test-project/instances/test-instance/databases/test-database", c.Spanner.DB())
 }
+
+func TestRatingDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "glicko2", c.Rating.Strategy)
+	assert.Equal(t, 0.5, c.Rating.Tau)
+}
+
+func TestRatingConfig(t *testing.T) {
+	cfgExample := []byte(`
+rating:
+  strategy: killdeath
+  tau: 0.3
+`)
+
+	c, err := readConfig(cfgExample)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "killdeath", c.Rating.Strategy)
+	assert.Equal(t, 0.3, c.Rating.Tau)
+}
+
+func TestTiersDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(5), c.Tiers.Placement_games)
+	assert.Equal(t, int64(50), c.Tiers.Hysteresis)
+	assert.Len(t, c.Tiers.Bands, 5)
+	assert.Equal(t, TierConfig{Tier: "B", Name: "Bronze", Min_skill: 0}, c.Tiers.Bands[0])
+	assert.Equal(t, TierConfig{Tier: "D", Name: "Diamond", Min_skill: 1850}, c.Tiers.Bands[4])
+}
+
+func TestTiersConfig(t *testing.T) {
+	cfgExample := []byte(`
+tiers:
+  placement_games: 3
+  hysteresis: 1
+  bands:
+  - tier: L
+    name: Low
+    min_skill: 0
+  - tier: H
+    name: High
+    min_skill: 2
+`)
+
+	c, err := readConfig(cfgExample)
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(3), c.Tiers.Placement_games)
+	assert.Equal(t, int64(1), c.Tiers.Hysteresis)
+	assert.Equal(t, []TierConfig{{Tier: "L", Name: "Low", Min_skill: 0}, {Tier: "H", Name: "High", Min_skill: 2}}, c.Tiers.Bands)
+}
+
+func TestShutdownDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 5*time.Second, c.Server.Drain_delay)
+	assert.Equal(t, 20*time.Second, c.Server.Shutdown_timeout)
+}
+
+func TestShutdownEnvironmentVariables(t *testing.T) {
+	t.Setenv("SERVICE_DRAIN_DELAY", "1s")
+	t.Setenv("SERVICE_SHUTDOWN_TIMEOUT", "2m")
+
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, time.Second, c.Server.Drain_delay)
+	assert.Equal(t, 2*time.Minute, c.Server.Shutdown_timeout)
+}
@@ -0,0 +1,195 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, r http.Handler, path string) (int, Response) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := New()
+	r := gin.New()
+	h.Register(r)
+
+	code, body := get(t, r, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, body.Status)
+
+	var spannerErr error
+	h.AddCheck("spanner", func(ctx context.Context) error { return spannerErr })
+	h.AddCheck("other", func(ctx context.Context) error { return nil })
+
+	code, body = get(t, r, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"spanner": StatusOK, "other": StatusOK}, body.Checks)
+
+	spannerErr = errors.New("no session available")
+	code, body = get(t, r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusUnavailable, body.Status)
+	assert.Equal(t, map[string]string{"spanner": "no session available", "other": StatusOK}, body.Checks)
+
+	code, body = get(t, r, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, body.Status)
+}
+
+func TestReadinessCheckTimeout(t *testing.T) {
+	h := New()
+	h.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ready, checks := h.Ready(ctx)
+	assert.False(t, ready)
+	assert.Equal(t, context.DeadlineExceeded.Error(), checks["slow"])
+}
+
+func TestDrain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := New()
+	r := gin.New()
+	h.Register(r)
+
+	h.Drain()
+	assert.True(t, h.Draining())
+
+	code, body := get(t, r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusDraining, body.Status)
+
+	// Still alive while draining, so it isn't restarted
+	code, _ = get(t, r, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+// serve serves a router with a /slow endpoint, which blocks until release is closed, until ctx is done
+func serve(t *testing.T, ctx context.Context, h *Health, cfg ShutdownConfig, started chan<- struct{}, release <-chan struct{}) (string, <-chan error) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h.Register(r)
+	r.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Serve(ctx, ln, &http.Server{Handler: r}, h, cfg)
+	}()
+	return "http://" + ln.Addr().String(), errs
+}
+
+func TestServeDrainsRequestsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := New()
+	started, release := make(chan struct{}), make(chan struct{})
+	url, errs := serve(t, ctx, h, ShutdownConfig{DrainDelay: 200 * time.Millisecond, Timeout: 5 * time.Second}, started, release)
+
+	responses := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			responses <- 0
+			return
+		}
+		res.Body.Close()
+		responses <- res.StatusCode
+	}()
+	<-started
+
+	cancel()
+	assert.Eventually(t, h.Draining, time.Second, 10*time.Millisecond)
+
+	// Unready, but still serving during the drain delay
+	res, err := http.Get(url + "/readyz")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	// Shutting down waits for the request in flight
+	select {
+	case err := <-errs:
+		t.Fatalf("Serve returned before the request in flight completed: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-responses)
+	assert.Nil(t, <-errs)
+
+	// No longer serving
+	_, err = http.Get(url + "/healthz")
+	assert.NotNil(t, err)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, errs := serve(t, ctx, New(), ShutdownConfig{Timeout: 100 * time.Millisecond}, started, release)
+
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	cancel()
+	err := <-errs
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ln.Close()
+
+	err = Serve(context.Background(), ln, &http.Server{Handler: gin.New()}, New(), ShutdownConfig{})
+	assert.NotNil(t, err)
+}
@@ -20,22 +20,23 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/apierror"
 	"github.com/googleforgames/global-multiplayer-demo/profile-service/config"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/health"
 	"github.com/googleforgames/global-multiplayer-demo/profile-service/models"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/rating"
 
 	"github.com/gin-gonic/gin"
 )
 
-// setSpannerConnection is a mutator to create spanner context and client, and set them in gin
-func setSpannerConnection(c config.Config) gin.HandlerFunc {
+// setSpannerConnection is a mutator to set the spanner context and client in gin
+func setSpannerConnection(client *spanner.Client) gin.HandlerFunc {
 	ctx := context.Background()
-	client, err := spanner.NewClient(ctx, c.Spanner.DB())
-
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	return func(c *gin.Context) {
 		c.Set("spanner_client", *client)
@@ -44,6 +45,17 @@ func setSpannerConnection(c config.Config) gin.HandlerFunc {
 	}
 }
 
+// spannerCheck is a readiness check that Spanner can be queried, which needs a session from the
+// client's session pool
+func spannerCheck(client *spanner.Client) health.Check {
+	return func(ctx context.Context) error {
+		iter := client.Single().Query(ctx, spanner.Statement{SQL: "SELECT 1"})
+		defer iter.Stop()
+		_, err := iter.Next()
+		return err
+	}
+}
+
 // getSpannerConnection is a helper function to retrieve spanner client and context
 func getSpannerConnection(c *gin.Context) (context.Context, spanner.Client) {
 	return c.MustGet("spanner_context").(context.Context),
@@ -51,6 +63,49 @@ func getSpannerConnection(c *gin.Context) (context.Context, spanner.Client) {
 
 }
 
+// setRatingStrategy is a mutator to create the configured rating strategy, and set it in gin
+func setRatingStrategy(c config.Config) (gin.HandlerFunc, error) {
+	strategy, err := rating.NewStrategy(c.Rating.Strategy, c.Rating.Tau)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		c.Set("rating_strategy", strategy)
+		c.Next()
+	}, nil
+}
+
+// getRatingStrategy is a helper function to retrieve the rating strategy
+func getRatingStrategy(c *gin.Context) rating.Strategy {
+	return c.MustGet("rating_strategy").(rating.Strategy)
+}
+
+// setTiers is a mutator to create the configured player tiers, and set them in gin
+func setTiers(c config.Config) (gin.HandlerFunc, error) {
+	var bands []rating.Tier
+	for _, b := range c.Tiers.Bands {
+		bands = append(bands, rating.Tier{Tier: b.Tier, Name: b.Name, Min_skill: b.Min_skill})
+	}
+
+	tiers, err := rating.NewTiers(bands, c.Tiers.Placement_games, c.Tiers.Hysteresis)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		c.Set("tiers", tiers)
+		c.Next()
+	}, nil
+}
+
+// getTiers is a helper function to retrieve the player tiers
+func getTiers(c *gin.Context) rating.Tiers {
+	return c.MustGet("tiers").(rating.Tiers)
+}
+
 // getPlayerID responds to the GET /players/:id endpoint
 // Returns a player's information when provided a valid player_google_id
 func getPlayerByID(c *gin.Context) {
@@ -60,7 +115,7 @@ func getPlayerByID(c *gin.Context) {
 
 	player, err := models.GetPlayerByGoogleId(ctx, client, playerGoogleId)
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		apierror.Handle(c, err)
 		return
 	}
 
@@ -72,19 +127,15 @@ func getPlayerByID(c *gin.Context) {
 func createPlayer(c *gin.Context) {
 	var player models.Player
 
-	if err := c.BindJSON(&player); err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+	if err := c.ShouldBindJSON(&player); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
 		return
 	}
 
 	ctx, client := getSpannerConnection(c)
-	err := player.AddPlayer(ctx, client)
+	err := player.AddPlayer(ctx, client, getRatingStrategy(c))
 	if err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+		apierror.Handle(c, err)
 		return
 	}
 
@@ -97,19 +148,15 @@ func createPlayer(c *gin.Context) {
 func updatePlayer(c *gin.Context) {
 	var player models.Player
 
-	if err := c.BindJSON(&player); err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+	if err := c.ShouldBindJSON(&player); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
 		return
 	}
 
 	ctx, client := getSpannerConnection(c)
 	err := player.UpdatePlayer(ctx, client)
 	if err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+		apierror.Handle(c, err)
 		return
 	}
 
@@ -118,10 +165,19 @@ func updatePlayer(c *gin.Context) {
 
 // ReturnPlayerStats provides player's identifier and their stats
 type ReturnPlayerStats struct {
-	Player_google_id string           `json:"player_google_id"`
-	Stats            spanner.NullJSON `json:"stats"`
-	Skill_level      int64            `json:"skill_level"`
-	Tier             string           `json:"tier"`
+	Player_google_id  string              `json:"player_google_id"`
+	Stats             spanner.NullJSON    `json:"stats"`
+	Skill_level       int64               `json:"skill_level"`
+	Tier              string              `json:"tier"`
+	Rating            spanner.NullFloat64 `json:"rating"`
+	Rating_deviation  spanner.NullFloat64 `json:"rating_deviation"`
+	Rating_volatility spanner.NullFloat64 `json:"rating_volatility"`
+}
+
+// newReturnPlayerStats returns the stats and skill information of a player
+func newReturnPlayerStats(player models.Player) ReturnPlayerStats {
+	return ReturnPlayerStats{Player_google_id: player.Player_google_id, Stats: player.Stats, Skill_level: player.Skill_level,
+		Tier: player.Tier, Rating: player.Rating, Rating_deviation: player.Rating_deviation, Rating_volatility: player.Rating_volatility}
 }
 
 // getPlayerStats responds to the GET /players/:id/stats endpoint
@@ -133,14 +189,11 @@ func getPlayerStats(c *gin.Context) {
 
 	player, err := models.GetPlayerStats(ctx, client, playerGoogleId)
 	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		apierror.Handle(c, err)
 		return
 	}
 
-	rStats := ReturnPlayerStats{Player_google_id: player.Player_google_id, Stats: player.Stats, Skill_level: player.Skill_level,
-		Tier: player.Tier}
-
-	c.IndentedJSON(http.StatusOK, rStats)
+	c.IndentedJSON(http.StatusOK, newReturnPlayerStats(player))
 }
 
 // updatePlayerStats responds to the PUT /player/stats endpoint
@@ -148,60 +201,193 @@ func getPlayerStats(c *gin.Context) {
 func updatePlayerStats(c *gin.Context) {
 	game_stats := models.SingleGameStats{}
 
-	err := c.BindUri(&game_stats)
+	err := c.ShouldBindUri(&game_stats)
 
 	// Error binding the google_id from the URI
 	if err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&game_stats); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+
+	player, err := models.UpdateStats(ctx, client, getRatingStrategy(c), getTiers(c), game_stats)
+	if err != nil {
+		apierror.Handle(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newReturnPlayerStats(player))
+}
+
+// ReturnPlayerGames provides a page of a player's game history
+type ReturnPlayerGames struct {
+	Player_google_id string              `json:"player_google_id"`
+	Games            []models.PlayerGame `json:"games"`
+	Next_offset      *int64              `json:"next_offset,omitempty"`
+}
+
+// playerGamesQuery are the pagination parameters of the GET /players/:id/matches endpoint
+type playerGamesQuery struct {
+	Limit  int64 `form:"limit,default=20" binding:"min=1,max=100"`
+	Offset int64 `form:"offset,default=0" binding:"min=0"`
+}
+
+// getPlayerGames responds to the GET /players/:id/matches endpoint
+// Returns a page of the player's game history, most recent first. When there are more games to
+// fetch, next_offset is set to the offset of the next page.
+func getPlayerGames(c *gin.Context) {
+	var playerGoogleId = c.Param("id")
+	var query playerGamesQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
 		return
 	}
 
-	if err := c.BindJSON(&game_stats); err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+	ctx, client := getSpannerConnection(c)
+
+	// Fetch one more than requested, to know if there is a next page
+	games, err := models.GetPlayerGames(ctx, client, playerGoogleId, query.Limit+1, query.Offset)
+	if err != nil {
+		apierror.Handle(c, err)
 		return
 	}
 
+	rGames := ReturnPlayerGames{Player_google_id: playerGoogleId, Games: games}
+	if int64(len(games)) > query.Limit {
+		nextOffset := query.Offset + query.Limit
+		rGames.Games = games[:query.Limit]
+		rGames.Next_offset = &nextOffset
+	}
+
+	c.IndentedJSON(http.StatusOK, rGames)
+}
+
+// getIdentity responds to the GET /identities/:identity endpoint
+// Returns the player an identity provider's identity is linked to
+func getIdentity(c *gin.Context) {
+	var identity = c.Param("identity")
+
 	ctx, client := getSpannerConnection(c)
 
-	player, err := models.UpdateStats(ctx, client, game_stats)
+	playerIdentity, err := models.GetPlayerIdentity(ctx, client, identity)
 	if err != nil {
-		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
-			fmt.Printf("could not abort: %s", err)
-		}
+		apierror.Handle(c, err)
 		return
 	}
 
-	rStats := ReturnPlayerStats{Player_google_id: player.Player_google_id, Stats: player.Stats, Skill_level: player.Skill_level,
-		Tier: player.Tier}
+	c.IndentedJSON(http.StatusOK, playerIdentity)
+}
+
+// linkIdentity responds to the POST /identities endpoint
+// Links an identity to a player. Linking an identity to the player it is already linked to succeeds,
+// linking it to another player is a conflict.
+func linkIdentity(c *gin.Context) {
+	var playerIdentity models.PlayerIdentity
+
+	if err := c.ShouldBindJSON(&playerIdentity); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err)
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := playerIdentity.LinkIdentity(ctx, client)
+	if err != nil {
+		apierror.Handle(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, playerIdentity)
+}
+
+// getPlayerIdentities responds to the GET /players/:id/identities endpoint
+// Returns the identities linked to a player, oldest first
+func getPlayerIdentities(c *gin.Context) {
+	var playerGoogleId = c.Param("id")
+
+	ctx, client := getSpannerConnection(c)
 
-	c.IndentedJSON(http.StatusOK, rStats)
+	identities, err := models.GetPlayerIdentities(ctx, client, playerGoogleId)
+	if err != nil {
+		apierror.Handle(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, identities)
 }
 
 // main initializes the gin router and configures the endpoints
 func main() {
-	configuration, _ := config.NewConfig()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the profile service until it is told to shut down. Returns an error if it couldn't be served, once the
+// Spanner client is closed
+func run() error {
+	configuration, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+
+	// Shut down on SIGTERM, which Kubernetes sends before killing the pod, or on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	client, err := spanner.NewClient(context.Background(), configuration.Spanner.DB())
+	if err != nil {
+		return fmt.Errorf("could not create Spanner client: %w", err)
+	}
+	defer client.Close()
+
+	ratingStrategy, err := setRatingStrategy(configuration)
+	if err != nil {
+		return err
+	}
+	tiers, err := setTiers(configuration)
+	if err != nil {
+		return err
+	}
+
+	// The service is ready while Spanner can be queried
+	h := health.New()
+	h.AddCheck("spanner", spannerCheck(client))
 
 	router := gin.Default()
 	// TODO: Better configuration of trusted proxy
 	if err := router.SetTrustedProxies(nil); err != nil {
-		fmt.Printf("could not set trusted proxies: %s", err)
-		return
+		return fmt.Errorf("could not set trusted proxies: %w", err)
 	}
 
-	router.Use(setSpannerConnection(configuration))
+	h.Register(router)
+
+	router.Use(apierror.RequestID())
+	router.Use(setSpannerConnection(client))
+	router.Use(ratingStrategy)
+	router.Use(tiers)
 
 	router.POST("/players", createPlayer)
 	router.GET("/players/:id", getPlayerByID)
 	router.PUT("/players", updatePlayer)
 	router.GET("/players/:id/stats", getPlayerStats)
 	router.PUT("/players/:id/stats", updatePlayerStats)
-
-	if err := router.Run(configuration.Server.URL()); err != nil {
-		fmt.Printf("could not run gin router: %s", err)
-		return
+	router.GET("/players/:id/matches", getPlayerGames)
+	router.GET("/players/:id/identities", getPlayerIdentities)
+	router.GET("/identities/:identity", getIdentity)
+	router.POST("/identities", linkIdentity)
+
+	// Requests in flight, and their Spanner transactions, complete before the client is closed
+	srv := &http.Server{Addr: configuration.Server.URL(), Handler: router}
+	shutdown := health.ShutdownConfig{DrainDelay: configuration.Server.Drain_delay, Timeout: configuration.Server.Shutdown_timeout}
+	if err := health.ListenAndServe(ctx, srv, h, shutdown); err != nil {
+		return fmt.Errorf("could not run gin router: %w", err)
 	}
+	return nil
 }
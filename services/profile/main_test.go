@@ -26,6 +26,8 @@ import (
 	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
 	"embed"
 	"fmt"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/apierror"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/health"
 	"github.com/googleforgames/global-multiplayer-demo/profile-service/models"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -301,25 +303,53 @@ var test_player = models.Player{
 
 var test_stats = []models.SingleGameStats{
 	{
-		Won:    true,
-		Score:  500,
-		Kills:  1,
-		Deaths: 0,
+		Game_id: "game-1",
+		Region:  "amer",
+		Won:     true,
+		Score:   500,
+		Kills:   1,
+		Deaths:  0,
 	},
 	{
-		Won:    false,
-		Score:  100,
-		Kills:  5,
-		Deaths: 20,
+		Game_id: "game-2",
+		Region:  "eur",
+		Won:     false,
+		Score:   100,
+		Kills:   5,
+		Deaths:  20,
 	},
 	{
-		Won:    true,
-		Score:  1000,
-		Kills:  20,
-		Deaths: 1,
+		Game_id: "game-3",
+		Region:  "amer",
+		Won:     true,
+		Score:   1000,
+		Kills:   20,
+		Deaths:  1,
 	},
 }
 
+func TestHealth(t *testing.T) {
+	response, err := http.Get("http://localhost/healthz")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Ready once Spanner can be queried
+	response, err = http.Get("http://localhost/readyz")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var ready health.Response
+	if err = json.NewDecoder(response.Body).Decode(&ready); err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, health.StatusOK, ready.Status)
+	assert.Equal(t, health.StatusOK, ready.Checks["spanner"])
+}
+
 func TestAddPlayers(t *testing.T) {
 	pJson, err := json.Marshal(test_player)
 	if err != nil {
@@ -348,6 +378,18 @@ func TestAddPlayers(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 400, response.StatusCode)
 
+	// Test adding same player again with its payload, should be a conflict with a machine-readable code
+	response, err = http.Post("http://localhost/players", "application/json", bytes.NewBuffer(pJson))
+	assert.Nil(t, err)
+	assert.Equal(t, 409, response.StatusCode)
+
+	var errResponse apierror.Response
+	err = json.NewDecoder(response.Body).Decode(&errResponse)
+	assert.Nil(t, err)
+	assert.Equal(t, apierror.CodeConflict, errResponse.Code)
+	assert.NotEmpty(t, errResponse.Request_id)
+	assert.False(t, errResponse.Retryable)
+
 	// Test add player with same name. Should succeed with 201 code
 	newPlayer := test_player
 	newPlayer.Player_google_id = "654321"
@@ -377,7 +419,8 @@ func TestGetPlayers(t *testing.T) {
 	var pData models.Player
 	json.Unmarshal(body, &pData)
 
-	var skill_level int64 = 0
+	// New players start with the initial Glicko-2 rating
+	var skill_level int64 = 1500
 
 	assert.Equal(t, test_player.Player_google_id, pData.Player_google_id)
 	assert.Equal(t, test_player.Player_name, pData.Player_name)
@@ -427,6 +470,31 @@ func TestUpdatePlayerStats(t *testing.T) {
 	}
 }
 
+func TestUpdatePlayerStatsDuplicateGame(t *testing.T) {
+	// Resubmitting an already applied game should succeed, but not change the stats
+	for _, new_stats := range test_stats {
+		new_stats.Player_google_id = test_player.Player_google_id
+		statsJson, err := json.Marshal(new_stats)
+		assert.Nil(t, err)
+
+		response, err := httpPUT(fmt.Sprintf("http://localhost/players/%s/stats", test_player.Player_google_id), bytes.NewBuffer(statsJson))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 200, response.StatusCode)
+	}
+
+	// Stats without a game_id are rejected
+	statsJson, err := json.Marshal(models.SingleGameStats{Player_google_id: test_player.Player_google_id, Kills: 1})
+	assert.Nil(t, err)
+
+	response, err := httpPUT(fmt.Sprintf("http://localhost/players/%s/stats", test_player.Player_google_id), bytes.NewBuffer(statsJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 400, response.StatusCode)
+}
+
 func TestGetPlayerStats(t *testing.T) {
 	// Get the testPlayer's stats and validate response code (assuming the result was not empty)
 	response, err := http.Get(fmt.Sprintf("http://localhost/players/%s/stats", test_player.Player_google_id))
@@ -439,7 +507,7 @@ func TestGetPlayerStats(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	var pData models.Player
+	var pData ReturnPlayerStats
 	if err = json.Unmarshal(body, &pData); err != nil {
 		t.Fatal(err.Error())
 	}
@@ -455,6 +523,154 @@ func TestGetPlayerStats(t *testing.T) {
 	assert.Equal(t, int64(1600), pStats.Total_score)
 	assert.Equal(t, int64(26), pStats.Total_kills)
 	assert.Equal(t, int64(21), pStats.Total_deaths)
-	assert.Equal(t, int64(1), pData.Skill_level)
+	// Rated by Glicko-2 with a win, a loss and a win against unknown opponents
+	assert.Equal(t, int64(1600), pData.Skill_level)
+	assert.InDelta(t, 1599.84, pData.Rating.Float64, 0.01)
+	assert.InDelta(t, 230.02, pData.Rating_deviation.Float64, 0.01)
+	// Still unranked, as the placement games have not all been played
 	assert.Equal(t, "U", pData.Tier)
 }
+
+func TestGetPlayerMatches(t *testing.T) {
+	// Get the first page of the testPlayer's games, which should be the two most recent
+	response, err := http.Get(fmt.Sprintf("http://localhost/players/%s/matches?limit=2", test_player.Player_google_id))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var pGames ReturnPlayerGames
+	if err = json.Unmarshal(body, &pGames); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, test_player.Player_google_id, pGames.Player_google_id)
+	assert.Len(t, pGames.Games, 2)
+	assert.Equal(t, "game-3", pGames.Games[0].Game_id)
+	assert.Equal(t, "amer", pGames.Games[0].Region)
+	assert.Equal(t, int64(20), pGames.Games[0].Kills)
+	assert.Equal(t, "game-2", pGames.Games[1].Game_id)
+	if assert.NotNil(t, pGames.Next_offset) {
+		assert.Equal(t, int64(2), *pGames.Next_offset)
+	}
+
+	// Get the last page
+	response, err = http.Get(fmt.Sprintf("http://localhost/players/%s/matches?limit=2&offset=2", test_player.Player_google_id))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pGames = ReturnPlayerGames{}
+	if err = json.Unmarshal(body, &pGames); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Len(t, pGames.Games, 1)
+	assert.Equal(t, "game-1", pGames.Games[0].Game_id)
+	assert.Nil(t, pGames.Next_offset)
+
+	// Unknown players are not found
+	response, err = http.Get("http://localhost/players/unknown/matches")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestLinkIdentities(t *testing.T) {
+	identity := models.PlayerIdentity{Identity: "google:" + test_player.Player_google_id, Player_google_id: test_player.Player_google_id}
+	iJson, err := json.Marshal(identity)
+	assert.Nil(t, err)
+
+	// Link the identity, and link it again, which is a no-op
+	for i := 0; i < 2; i++ {
+		response, err := http.Post("http://localhost/identities", "application/json", bytes.NewBuffer(iJson))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 200, response.StatusCode)
+	}
+
+	// Link a second identity to the same player
+	iJson, err = json.Marshal(models.PlayerIdentity{Identity: "oidc:abcdef", Player_google_id: test_player.Player_google_id})
+	assert.Nil(t, err)
+	response, err := http.Post("http://localhost/identities", "application/json", bytes.NewBuffer(iJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// An identity can't be linked to another player
+	iJson, err = json.Marshal(models.PlayerIdentity{Identity: "oidc:abcdef", Player_google_id: "654321"})
+	assert.Nil(t, err)
+	response, err = http.Post("http://localhost/identities", "application/json", bytes.NewBuffer(iJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	// Identities can't be linked to unknown players
+	iJson, err = json.Marshal(models.PlayerIdentity{Identity: "local:tester", Player_google_id: "unknown"})
+	assert.Nil(t, err)
+	response, err = http.Post("http://localhost/identities", "application/json", bytes.NewBuffer(iJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestGetIdentities(t *testing.T) {
+	response, err := http.Get("http://localhost/identities/oidc:abcdef")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var iData models.PlayerIdentity
+	json.Unmarshal(body, &iData)
+	assert.Equal(t, test_player.Player_google_id, iData.Player_google_id)
+
+	// Unknown identities are not found
+	response, err = http.Get("http://localhost/identities/oidc:unknown")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	// List the player's identities, oldest first
+	response, err = http.Get(fmt.Sprintf("http://localhost/players/%s/identities", test_player.Player_google_id))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var identities []models.PlayerIdentity
+	if err = json.Unmarshal(body, &identities); err != nil {
+		t.Fatal(err.Error())
+	}
+	if assert.Len(t, identities, 2) {
+		assert.Equal(t, "google:"+test_player.Player_google_id, identities[0].Identity)
+		assert.Equal(t, "oidc:abcdef", identities[1].Identity)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrIdentityLinked is returned by LinkIdentity when the identity is already linked to another player
+var ErrIdentityLinked = errors.New("identity is linked to another player")
+
+// ErrPlayerNotFound is returned by LinkIdentity when the player to link the identity to does not exist
+var ErrPlayerNotFound = errors.New("player not found")
+
+// PlayerIdentity links an identity of an identity provider to a player. Identities are namespaced by their
+// provider, e.g. "google:1234", so a player can sign in with several providers.
+type PlayerIdentity struct {
+	Identity         string    `json:"identity" validate:"required"`
+	Player_google_id string    `json:"player_google_id" validate:"required"`
+	Linked_time      time.Time `json:"linked_time"`
+}
+
+// Validate that the identity has the required information based on the type's validation rules.
+func (i *PlayerIdentity) Validate() error {
+	return validator.New().Struct(i)
+}
+
+// GetPlayerIdentity returns the player an identity is linked to. In the event of an error retrieving the
+// identity, an empty PlayerIdentity is returned with the error.
+func GetPlayerIdentity(ctx context.Context, client spanner.Client, identity string) (PlayerIdentity, error) {
+	row, err := client.Single().ReadRow(ctx, "player_identities",
+		spanner.Key{identity}, []string{"identity", "player_google_id", "linked_time"})
+	if err != nil {
+		return PlayerIdentity{}, err
+	}
+
+	i := PlayerIdentity{}
+	if err := row.ToStruct(&i); err != nil {
+		return PlayerIdentity{}, err
+	}
+	return i, nil
+}
+
+// LinkIdentity links the identity to the player. Linking an identity to the player it is already linked to
+// is a no-op, so logins can safely retry. Returns ErrPlayerNotFound if the player does not exist, and
+// ErrIdentityLinked if the identity is linked to another player.
+func (i *PlayerIdentity) LinkIdentity(ctx context.Context, client spanner.Client) error {
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		_, err := txn.ReadRow(ctx, "players", spanner.Key{i.Player_google_id}, []string{"player_google_id"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		row, err := txn.ReadRow(ctx, "player_identities", spanner.Key{i.Identity}, []string{"identity", "player_google_id", "linked_time"})
+		if err == nil {
+			existing := PlayerIdentity{}
+			if err := row.ToStruct(&existing); err != nil {
+				return err
+			}
+			if existing.Player_google_id != i.Player_google_id {
+				return ErrIdentityLinked
+			}
+			*i = existing
+			return nil
+		}
+		if spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+
+		i.Linked_time = time.Now().UTC()
+		return txn.BufferWrite([]*spanner.Mutation{
+			spanner.Insert("player_identities", []string{"identity", "player_google_id", "linked_time"},
+				[]interface{}{i.Identity, i.Player_google_id, i.Linked_time}),
+		})
+	})
+	return err
+}
+
+// GetPlayerIdentities returns the identities linked to a player, oldest first. If the player does not exist,
+// an error is returned.
+func GetPlayerIdentities(ctx context.Context, client spanner.Client, google_id string) ([]PlayerIdentity, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	_, err := txn.ReadRow(ctx, "players", spanner.Key{google_id}, []string{"player_google_id"})
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := spanner.Statement{
+		SQL: `SELECT identity, player_google_id, linked_time
+				FROM player_identities
+				WHERE player_google_id = @playerGoogleId
+				ORDER BY linked_time, identity
+		`,
+		Params: map[string]interface{}{
+			"playerGoogleId": google_id,
+		},
+	}
+
+	identities := []PlayerIdentity{}
+	err = txn.Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		i := PlayerIdentity{}
+		if err := row.ToStruct(&i); err != nil {
+			return err
+		}
+
+		identities = append(identities, i)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,38 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidPlayerIdentity(t *testing.T) {
+	i := PlayerIdentity{Identity: "google:123456", Player_google_id: "123456"}
+
+	assert.Nil(t, i.Validate())
+}
+
+func TestInvalidPlayerIdentities(t *testing.T) {
+	for _, i := range []PlayerIdentity{
+		{Identity: "", Player_google_id: "123456"},
+		{Identity: "google:123456", Player_google_id: ""},
+	} {
+		assert.Error(t, i.Validate())
+	}
+}
@@ -20,20 +20,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	spanner "cloud.google.com/go/spanner"
 	"github.com/go-playground/validator/v10"
+	"github.com/googleforgames/global-multiplayer-demo/profile-service/rating"
+	"google.golang.org/grpc/codes"
 )
 
 var validate *validator.Validate
 
 // SingleGameStats provides a structure for updating a player's stats based on a single game outcome
 type SingleGameStats struct {
-	Player_google_id string `json:"player_google_id" uri:"id"`
-	Won              bool   `json:"won"`
-	Score            int64  `json:"score"`
-	Kills            int64  `json:"kills"`
-	Deaths           int64  `json:"deaths"`
+	Player_google_id string   `json:"player_google_id" uri:"id"`
+	Game_id          string   `json:"game_id" binding:"required"`
+	Region           string   `json:"region"`
+	Won              bool     `json:"won"`
+	Score            int64    `json:"score"`
+	Kills            int64    `json:"kills"`
+	Deaths           int64    `json:"deaths"`
+	Opponents        []string `json:"opponents"`
 }
 
 // PlayerStats provides various statistics for a player
@@ -45,15 +51,53 @@ type PlayerStats struct {
 	Total_deaths int64 `json:"total_deaths"`
 }
 
+// PlayerGame is a single game outcome that has been applied to a player's stats
+type PlayerGame struct {
+	Game_id      string    `json:"game_id"`
+	Applied_time time.Time `json:"timestamp"`
+	Region       string    `json:"region"`
+	Kills        int64     `json:"kills"`
+	Deaths       int64     `json:"deaths"`
+	Score        int64     `json:"score"`
+	Won          bool      `json:"won"`
+}
+
 // Player maps to the fields stored for the backend database
 type Player struct {
-	Player_google_id string           `json:"player_google_id" validate:"required" uri:"id"`
-	Player_name      string           `json:"player_name"`
-	Profile_image    string           `json:"profile_image"`
-	Region           string           `json:"region"`
-	Stats            spanner.NullJSON `json:"stats"`
-	Skill_level      int64            `json:"skill_level"`
-	Tier             string           `json:"tier"`
+	Player_google_id  string              `json:"player_google_id" validate:"required" uri:"id"`
+	Player_name       string              `json:"player_name"`
+	Profile_image     string              `json:"profile_image"`
+	Region            string              `json:"region"`
+	Stats             spanner.NullJSON    `json:"stats"`
+	Skill_level       int64               `json:"skill_level"`
+	Tier              string              `json:"tier"`
+	Rating            spanner.NullFloat64 `json:"rating"`
+	Rating_deviation  spanner.NullFloat64 `json:"rating_deviation"`
+	Rating_volatility spanner.NullFloat64 `json:"rating_volatility"`
+}
+
+// GetRating returns the player's rating. Players that have not been rated yet have the strategy's
+// initial rating.
+func (p *Player) GetRating(strategy rating.Strategy) rating.Rating {
+	r := strategy.Initial()
+	if p.Rating.Valid {
+		r.Rating = p.Rating.Float64
+	}
+	if p.Rating_deviation.Valid {
+		r.Deviation = p.Rating_deviation.Float64
+	}
+	if p.Rating_volatility.Valid {
+		r.Volatility = p.Rating_volatility.Float64
+	}
+	return r
+}
+
+// SetRating sets the player's rating, and the skill level derived from it
+func (p *Player) SetRating(r rating.Rating) {
+	p.Rating = spanner.NullFloat64{Float64: r.Rating, Valid: true}
+	p.Rating_deviation = spanner.NullFloat64{Float64: r.Deviation, Valid: true}
+	p.Rating_volatility = spanner.NullFloat64{Float64: r.Volatility, Valid: true}
+	p.Skill_level = r.SkillLevel()
 }
 
 // Validate that the player has the required information based on the type's validation rules.
@@ -92,8 +136,8 @@ func GetPlayerByGoogleId(ctx context.Context, client spanner.Client, google_id s
 
 // AddPlayer provides functionality to insert a player into the backend.
 // Provide with the required fields from the API call. This is then inserted, along with empty stats, into
-// the Spanner database.
-func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
+// the Spanner database, with the initial rating of the provided rating strategy.
+func (p *Player) AddPlayer(ctx context.Context, client spanner.Client, strategy rating.Strategy) error {
 	// Validate based on struct validation rules
 	err := p.Validate()
 	if err != nil {
@@ -109,20 +153,28 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 		Total_deaths: 0,
 	}, Valid: true}
 
+	// Initialize player rating
+	initialRating := strategy.Initial()
+
 	// insert into spanner.
 	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		stmt := spanner.Statement{
-			SQL: `INSERT players (player_google_id, player_name, profile_image, region, skill_level, tier, stats) VALUES
-					(@playerGoogleId, @playerName, @profileImage, @region, @skill, @tier, @pStats)
+			SQL: `INSERT players (player_google_id, player_name, profile_image, region, skill_level, tier, stats,
+						rating, rating_deviation, rating_volatility) VALUES
+					(@playerGoogleId, @playerName, @profileImage, @region, @skill, @tier, @pStats,
+						@rating, @ratingDeviation, @ratingVolatility)
 			`,
 			Params: map[string]interface{}{
-				"playerGoogleId": p.Player_google_id,
-				"playerName":     p.Player_name,
-				"profileImage":   p.Profile_image,
-				"region":         p.Region,
-				"skill":          0,   // Initial skill rating
-				"tier":           "U", // Initial tier is U=Unknown
-				"pStats":         emptyStats,
+				"playerGoogleId":   p.Player_google_id,
+				"playerName":       p.Player_name,
+				"profileImage":     p.Profile_image,
+				"region":           p.Region,
+				"skill":            initialRating.SkillLevel(), // Initial skill rating
+				"tier":             "U",                        // Initial tier is U=Unknown
+				"pStats":           emptyStats,
+				"rating":           initialRating.Rating,
+				"ratingDeviation":  initialRating.Deviation,
+				"ratingVolatility": initialRating.Volatility,
 			},
 		}
 
@@ -168,7 +220,7 @@ func (p *Player) UpdatePlayer(ctx context.Context, client spanner.Client) error
 func GetPlayerStats(ctx context.Context, client spanner.Client, google_id string) (Player, error) {
 	// Retrieve columns related to player stats.
 	row, err := client.Single().ReadRow(ctx, "players",
-		spanner.Key{google_id}, []string{"player_google_id", "stats", "skill_level", "tier"})
+		spanner.Key{google_id}, []string{"player_google_id", "stats", "skill_level", "tier", "rating", "rating_deviation", "rating_volatility"})
 	if err != nil {
 		return Player{}, err
 	}
@@ -182,8 +234,14 @@ func GetPlayerStats(ctx context.Context, client spanner.Client, google_id string
 	return player, nil
 }
 
-// UpdateStats updates a player's stats with statistics of a game's outcome
-func UpdateStats(ctx context.Context, client spanner.Client, gStats SingleGameStats) (Player, error) {
+// UpdateStats updates a player's stats with statistics of a game's outcome.
+// Each game is only applied once per player: submitting the same game_id again is a no-op that
+// returns the player's stats as they are, so game servers can safely retry.
+//
+// The player's rating is updated by the provided rating strategy, taking into account the ratings
+// of the game's opponents. Opponents that are not known players are ignored. The player's tier is
+// then reassigned based on their new skill level.
+func UpdateStats(ctx context.Context, client spanner.Client, strategy rating.Strategy, tiers rating.Tiers, gStats SingleGameStats) (Player, error) {
 	player := Player{}
 
 	// Transaction to request and update player's stats with the new stats
@@ -193,7 +251,8 @@ func UpdateStats(ctx context.Context, client spanner.Client, gStats SingleGameSt
 	_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		// Retrieve columns related to player stats.
 		row, err := txn.ReadRow(ctx, "players",
-			spanner.Key{gStats.Player_google_id}, []string{"player_google_id", "stats", "skill_level", "tier"})
+			spanner.Key{gStats.Player_google_id}, []string{"player_google_id", "stats", "skill_level", "tier",
+				"rating", "rating_deviation", "rating_volatility"})
 
 		if err != nil {
 			return err
@@ -205,6 +264,18 @@ func UpdateStats(ctx context.Context, client spanner.Client, gStats SingleGameSt
 			return err
 		}
 
+		// Check if this game has already been applied to the player's stats
+		_, err = txn.ReadRow(ctx, "player_games",
+			spanner.Key{gStats.Player_google_id, gStats.Game_id}, []string{"game_id"})
+
+		if err == nil {
+			return nil
+		}
+
+		if spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+
 		// Modify stat totals
 		var pStats PlayerStats
 		if err := json.Unmarshal([]byte(player.Stats.String()), &pStats); err != nil {
@@ -220,24 +291,35 @@ func UpdateStats(ctx context.Context, client spanner.Client, gStats SingleGameSt
 			pStats.Games_won = pStats.Games_won + 1
 		}
 
-		if pStats.Total_deaths != 0 {
-			player.Skill_level = pStats.Total_kills / pStats.Total_deaths
-		} else {
-			player.Skill_level = pStats.Total_kills
+		// Rate the player against their opponents
+		opponents, err := readOpponentRatings(ctx, txn, strategy, gStats.Opponents)
+		if err != nil {
+			return err
 		}
 
+		player.SetRating(strategy.Rate(player.GetRating(strategy), rating.Result{
+			Won:          gStats.Won,
+			Opponents:    opponents,
+			Total_kills:  pStats.Total_kills,
+			Total_deaths: pStats.Total_deaths,
+		}))
+
 		updatedStats, _ := json.Marshal(pStats)
 		if err := player.Stats.UnmarshalJSON(updatedStats); err != nil {
 			return fmt.Errorf("could not unmarshal json: %s", err)
 		}
 
-		// TODO: Modify tier
+		player.Tier = tiers.Assign(player.Tier, player.Skill_level, pStats.Games_played)
 
-		// Update player
-		cols := []string{"player_google_id", "stats", "skill_level"}
+		// Update player and record the game as applied
+		cols := []string{"player_google_id", "stats", "skill_level", "tier", "rating", "rating_deviation", "rating_volatility"}
+		gameCols := []string{"player_google_id", "game_id", "region", "won", "score", "kills", "deaths", "applied_time"}
 
 		err = txn.BufferWrite([]*spanner.Mutation{
-			spanner.Update("players", cols, []interface{}{player.Player_google_id, player.Stats, player.Skill_level}),
+			spanner.Update("players", cols, []interface{}{player.Player_google_id, player.Stats, player.Skill_level, player.Tier,
+				player.Rating, player.Rating_deviation, player.Rating_volatility}),
+			spanner.Insert("player_games", gameCols, []interface{}{gStats.Player_google_id, gStats.Game_id, gStats.Region, gStats.Won,
+				gStats.Score, gStats.Kills, gStats.Deaths, time.Now().UTC()}),
 		})
 
 		if err != nil {
@@ -253,3 +335,79 @@ func UpdateStats(ctx context.Context, client spanner.Client, gStats SingleGameSt
 
 	return player, nil
 }
+
+// readOpponentRatings returns the current ratings of the provided opponents within a transaction.
+// Opponents that are not known players are ignored.
+func readOpponentRatings(ctx context.Context, txn *spanner.ReadWriteTransaction, strategy rating.Strategy, opponentIds []string) ([]rating.Rating, error) {
+	if len(opponentIds) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]spanner.Key, 0, len(opponentIds))
+	for _, id := range opponentIds {
+		keys = append(keys, spanner.Key{id})
+	}
+
+	var opponents []rating.Rating
+	err := txn.Read(ctx, "players", spanner.KeySetFromKeys(keys...),
+		[]string{"player_google_id", "rating", "rating_deviation", "rating_volatility"}).Do(func(row *spanner.Row) error {
+		opponent := Player{}
+		if err := row.ToStruct(&opponent); err != nil {
+			return err
+		}
+
+		opponents = append(opponents, opponent.GetRating(strategy))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return opponents, nil
+}
+
+// GetPlayerGames returns a page of the games applied to a player's stats, most recent first.
+// Up to limit games are returned, skipping the first offset games. If the player does not exist,
+// an error is returned.
+func GetPlayerGames(ctx context.Context, client spanner.Client, google_id string, limit int64, offset int64) ([]PlayerGame, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	// Make sure the player exists, so an unknown player isn't mistaken for one with no games
+	_, err := txn.ReadRow(ctx, "players", spanner.Key{google_id}, []string{"player_google_id"})
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := spanner.Statement{
+		SQL: `SELECT game_id, applied_time, IFNULL(region, "") AS region, kills, deaths, score, won
+				FROM player_games
+				WHERE player_google_id = @playerGoogleId
+				ORDER BY applied_time DESC, game_id
+				LIMIT @limit OFFSET @offset
+		`,
+		Params: map[string]interface{}{
+			"playerGoogleId": google_id,
+			"limit":          limit,
+			"offset":         offset,
+		},
+	}
+
+	games := []PlayerGame{}
+	err = txn.Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		game := PlayerGame{}
+		if err := row.ToStruct(&game); err != nil {
+			return err
+		}
+
+		games = append(games, game)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return games, nil
+}
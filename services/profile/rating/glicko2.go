@@ -0,0 +1,144 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"math"
+)
+
+const (
+	glicko2InitialRating     = 1500.0
+	glicko2InitialDeviation  = 350.0
+	glicko2InitialVolatility = 0.06
+
+	// glicko2Scale converts between the Glicko and Glicko-2 scales
+	glicko2Scale = 173.7178
+	// glicko2Epsilon is the convergence tolerance of the volatility iteration
+	glicko2Epsilon = 0.000001
+)
+
+// Glicko2 rates players with the Glicko-2 rating system, where each game is treated as a
+// rating period. See http://www.glicko.net/glicko/glicko2.pdf for details.
+//
+// A winning player scores a win against every opponent, and a losing player a loss. Games
+// without any known opponents are rated against a single opponent with the initial rating.
+type Glicko2 struct {
+	// Tau is the system constant, which constrains the change in volatility over time.
+	// Reasonable values are between 0.3 and 1.2.
+	Tau float64
+}
+
+// Initial returns the Glicko-2 rating of a new player
+func (Glicko2) Initial() Rating {
+	return Rating{Rating: glicko2InitialRating, Deviation: glicko2InitialDeviation, Volatility: glicko2InitialVolatility}
+}
+
+// Rate returns the player's Glicko-2 rating after a game
+func (g Glicko2) Rate(current Rating, result Result) Rating {
+	opponents := result.Opponents
+	if len(opponents) == 0 {
+		opponents = []Rating{g.Initial()}
+	}
+
+	score := 0.0
+	if result.Won {
+		score = 1.0
+	}
+
+	scores := make([]float64, len(opponents))
+	for i := range scores {
+		scores[i] = score
+	}
+
+	return g.rate(current, opponents, scores)
+}
+
+// rate returns the player's Glicko-2 rating after a rating period, where scores[i] is the
+// player's score against opponents[i]: 1 for a win, 0.5 for a draw and 0 for a loss.
+func (g Glicko2) rate(current Rating, opponents []Rating, scores []float64) Rating {
+	// Step 2: convert to the Glicko-2 scale
+	mu := (current.Rating - glicko2InitialRating) / glicko2Scale
+	phi := current.Deviation / glicko2Scale
+	sigma := current.Volatility
+
+	// Steps 3 and 4: the estimated variance and improvement in rating based on game outcomes only
+	var vInv, sum float64
+	for i, o := range opponents {
+		muJ := (o.Rating - glicko2InitialRating) / glicko2Scale
+		gPhiJ := glicko2G(o.Deviation / glicko2Scale)
+		e := 1 / (1 + math.Exp(-gPhiJ*(mu-muJ)))
+
+		vInv += gPhiJ * gPhiJ * e * (1 - e)
+		sum += gPhiJ * (scores[i] - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// Steps 5 and 6: the new volatility, and pre-rating period deviation
+	sigma = g.volatility(phi, sigma, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+
+	// Step 7: the new deviation and rating
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu = mu + phi*phi*sum
+
+	// Step 8: convert back to the Glicko scale
+	return Rating{
+		Rating:     glicko2Scale*mu + glicko2InitialRating,
+		Deviation:  glicko2Scale * phi,
+		Volatility: sigma,
+	}
+}
+
+// volatility iterates on the new volatility using the Illinois algorithm
+func (g Glicko2) volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(g.Tau*g.Tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*g.Tau) < 0 {
+			k++
+		}
+		B = a - k*g.Tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glicko2Epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA = fA / 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
+
+// glicko2G reduces the impact of an opponent's rating based on its deviation
+func glicko2G(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
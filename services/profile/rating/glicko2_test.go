@@ -0,0 +1,60 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlicko2PaperExample(t *testing.T) {
+	// Worked example from http://www.glicko.net/glicko/glicko2.pdf
+	g := Glicko2{Tau: 0.5}
+	current := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	opponents := []Rating{
+		{Rating: 1400, Deviation: 30},
+		{Rating: 1550, Deviation: 100},
+		{Rating: 1700, Deviation: 300},
+	}
+
+	r := g.rate(current, opponents, []float64{1, 0, 0})
+
+	assert.InDelta(t, 1464.06, r.Rating, 0.01)
+	assert.InDelta(t, 151.52, r.Deviation, 0.01)
+	assert.InDelta(t, 0.05999, r.Volatility, 0.00001)
+}
+
+func TestGlicko2Rate(t *testing.T) {
+	g := Glicko2{Tau: 0.5}
+	opponents := []Rating{g.Initial(), g.Initial()}
+
+	won := g.Rate(g.Initial(), Result{Won: true, Opponents: opponents})
+	lost := g.Rate(g.Initial(), Result{Won: false, Opponents: opponents})
+
+	assert.Greater(t, won.Rating, g.Initial().Rating)
+	assert.Less(t, lost.Rating, g.Initial().Rating)
+	assert.Less(t, won.Deviation, g.Initial().Deviation)
+
+	// Beating a stronger opponent is worth more than beating a weaker one
+	stronger := g.Rate(g.Initial(), Result{Won: true, Opponents: []Rating{{Rating: 1800, Deviation: 50, Volatility: 0.06}}})
+	weaker := g.Rate(g.Initial(), Result{Won: true, Opponents: []Rating{{Rating: 1200, Deviation: 50, Volatility: 0.06}}})
+	assert.Greater(t, stronger.Rating, weaker.Rating)
+
+	// Without known opponents, the game is rated against an initial rating
+	assert.Equal(t, g.Rate(g.Initial(), Result{Won: true, Opponents: []Rating{g.Initial()}}), g.Rate(g.Initial(), Result{Won: true}))
+}
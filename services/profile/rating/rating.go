@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rating provides the skill rating strategies used to rate players
+// based on the outcomes of their games, and the tiers players are assigned to
+// based on their rating.
+package rating
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// Glicko2Strategy is the name of the Glicko-2 rating strategy
+	Glicko2Strategy = "glicko2"
+	// KillDeathStrategy is the name of the kill/death ratio rating strategy
+	KillDeathStrategy = "killdeath"
+)
+
+// Rating is a player's skill rating. Deviation and Volatility are only used by
+// strategies that track the uncertainty of a rating, such as Glicko-2.
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// SkillLevel returns the rating as the integer skill level stored for a player
+func (r Rating) SkillLevel() int64 {
+	return int64(math.Round(r.Rating))
+}
+
+// Result is the outcome of a single game for a player
+type Result struct {
+	// Won is whether the player won the game
+	Won bool
+	// Opponents are the ratings of the player's opponents before the game
+	Opponents []Rating
+	// Total_kills is the player's total kills, including this game
+	Total_kills int64
+	// Total_deaths is the player's total deaths, including this game
+	Total_deaths int64
+}
+
+// Strategy rates players based on the outcome of their games
+type Strategy interface {
+	// Initial returns the rating of a player that has not played any games
+	Initial() Rating
+	// Rate returns the player's new rating after a game
+	Rate(current Rating, result Result) Rating
+}
+
+// NewStrategy returns the Strategy with the provided name. tau is the Glicko-2
+// system constant, which constrains the change in volatility over time.
+func NewStrategy(name string, tau float64) (Strategy, error) {
+	switch name {
+	case Glicko2Strategy:
+		if tau <= 0 {
+			return nil, fmt.Errorf("glicko2 tau must be positive, got %v", tau)
+		}
+		return Glicko2{Tau: tau}, nil
+	case KillDeathStrategy:
+		return KillDeath{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rating strategy %q", name)
+}
+
+// KillDeath rates a player by their total kills divided by total deaths. This is
+// the original rating of the profile service, kept for comparison.
+type KillDeath struct{}
+
+// Initial returns a zero rating
+func (KillDeath) Initial() Rating {
+	return Rating{Rating: 0, Deviation: glicko2InitialDeviation, Volatility: glicko2InitialVolatility}
+}
+
+// Rate returns the player's integer kill/death ratio. Players without deaths are rated by their kills.
+func (KillDeath) Rate(current Rating, result Result) Rating {
+	current.Rating = float64(result.Total_kills)
+	if result.Total_deaths != 0 {
+		current.Rating = float64(result.Total_kills / result.Total_deaths)
+	}
+
+	return current
+}
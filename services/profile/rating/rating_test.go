@@ -0,0 +1,47 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStrategy(t *testing.T) {
+	s, err := NewStrategy(Glicko2Strategy, 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, Glicko2{Tau: 0.5}, s)
+
+	s, err = NewStrategy(KillDeathStrategy, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, KillDeath{}, s)
+
+	_, err = NewStrategy(Glicko2Strategy, 0)
+	assert.Error(t, err)
+
+	_, err = NewStrategy("elo", 0.5)
+	assert.Error(t, err)
+}
+
+func TestKillDeathRate(t *testing.T) {
+	kd := KillDeath{}
+
+	assert.Equal(t, int64(1), kd.Rate(kd.Initial(), Result{Total_kills: 26, Total_deaths: 21}).SkillLevel())
+	assert.Equal(t, int64(0), kd.Rate(kd.Initial(), Result{Total_kills: 5, Total_deaths: 20}).SkillLevel())
+	assert.Equal(t, int64(7), kd.Rate(kd.Initial(), Result{Total_kills: 7, Total_deaths: 0}).SkillLevel())
+}
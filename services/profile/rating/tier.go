@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"fmt"
+)
+
+// UnrankedTier is the tier of players that have not finished their placement games
+const UnrankedTier = "U"
+
+// Tier is a band of skill levels, starting at Min_skill up to the next tier's Min_skill
+type Tier struct {
+	Tier      string
+	Name      string
+	Min_skill int64
+}
+
+// Tiers assigns players to tiers based on their skill level
+type Tiers struct {
+	// Bands are the tiers, ordered by ascending Min_skill
+	Bands []Tier
+	// Placement_games is the number of games a player must play before being assigned a tier
+	Placement_games int64
+	// Hysteresis is how far below their tier's Min_skill a player's skill has to drop before
+	// they are demoted, so players near a threshold don't change tiers every game
+	Hysteresis int64
+}
+
+// NewTiers validates and returns Tiers. Bands must be ordered by ascending Min_skill, and
+// be identified by a unique single character other than the UnrankedTier.
+func NewTiers(bands []Tier, placementGames int64, hysteresis int64) (Tiers, error) {
+	if len(bands) == 0 {
+		return Tiers{}, fmt.Errorf("at least one tier is required")
+	}
+	if placementGames < 0 {
+		return Tiers{}, fmt.Errorf("placement games must not be negative, got %d", placementGames)
+	}
+	if hysteresis < 0 {
+		return Tiers{}, fmt.Errorf("tier hysteresis must not be negative, got %d", hysteresis)
+	}
+
+	seen := map[string]bool{}
+	for i, b := range bands {
+		if len(b.Tier) != 1 {
+			return Tiers{}, fmt.Errorf("tier %q must be a single character", b.Tier)
+		}
+		if b.Tier == UnrankedTier {
+			return Tiers{}, fmt.Errorf("tier %q is reserved for unranked players", b.Tier)
+		}
+		if seen[b.Tier] {
+			return Tiers{}, fmt.Errorf("tier %q is defined more than once", b.Tier)
+		}
+		if i > 0 && b.Min_skill <= bands[i-1].Min_skill {
+			return Tiers{}, fmt.Errorf("tier %q min skill %d must be greater than the previous tier's %d",
+				b.Tier, b.Min_skill, bands[i-1].Min_skill)
+		}
+		seen[b.Tier] = true
+	}
+
+	return Tiers{Bands: bands, Placement_games: placementGames, Hysteresis: hysteresis}, nil
+}
+
+// Assign returns the tier of a player with the provided skill level, that has played gamesPlayed
+// games and is currently in the current tier. Players are promoted as soon as their skill reaches
+// a higher tier, but are only demoted once their skill drops Hysteresis below their current tier.
+func (t Tiers) Assign(current string, skill int64, gamesPlayed int64) string {
+	if gamesPlayed < t.Placement_games {
+		return UnrankedTier
+	}
+
+	target := t.index(skill)
+	if c := t.find(current); c > target && skill >= t.Bands[c].Min_skill-t.Hysteresis {
+		return current
+	}
+
+	return t.Bands[target].Tier
+}
+
+// index returns the index of the highest tier the skill level reaches. Skill levels below the
+// lowest tier are in the lowest tier.
+func (t Tiers) index(skill int64) int {
+	result := 0
+	for i, b := range t.Bands {
+		if skill >= b.Min_skill {
+			result = i
+		}
+	}
+	return result
+}
+
+// find returns the index of the provided tier, or -1 if it is not a known tier
+func (t Tiers) find(tier string) int {
+	for i, b := range t.Bands {
+		if b.Tier == tier {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,74 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testBands = []Tier{
+	{Tier: "B", Name: "Bronze", Min_skill: 0},
+	{Tier: "S", Name: "Silver", Min_skill: 1400},
+	{Tier: "G", Name: "Gold", Min_skill: 1550},
+}
+
+func TestNewTiers(t *testing.T) {
+	_, err := NewTiers(testBands, 5, 50)
+	assert.Nil(t, err)
+
+	invalid := map[string][]Tier{
+		"empty":     {},
+		"long code": {{Tier: "BR", Min_skill: 0}},
+		"unranked":  {{Tier: UnrankedTier, Min_skill: 0}},
+		"duplicate": {{Tier: "B", Min_skill: 0}, {Tier: "B", Min_skill: 10}},
+		"unordered": {{Tier: "B", Min_skill: 10}, {Tier: "S", Min_skill: 0}},
+	}
+	for name, bands := range invalid {
+		_, err := NewTiers(bands, 5, 50)
+		assert.Error(t, err, name)
+	}
+
+	_, err = NewTiers(testBands, -1, 50)
+	assert.Error(t, err)
+	_, err = NewTiers(testBands, 5, -1)
+	assert.Error(t, err)
+}
+
+func TestTiersAssign(t *testing.T) {
+	tiers, err := NewTiers(testBands, 5, 50)
+	assert.Nil(t, err)
+
+	// Unranked until placement games are played
+	assert.Equal(t, UnrankedTier, tiers.Assign(UnrankedTier, 1600, 4))
+	assert.Equal(t, "G", tiers.Assign(UnrankedTier, 1600, 5))
+	assert.Equal(t, "B", tiers.Assign(UnrankedTier, -10, 5))
+
+	// Promoted as soon as the threshold is reached, possibly by several tiers
+	assert.Equal(t, "S", tiers.Assign("B", 1400, 10))
+	assert.Equal(t, "G", tiers.Assign("B", 1600, 10))
+
+	// Only demoted once below the threshold by more than the hysteresis
+	assert.Equal(t, "G", tiers.Assign("G", 1549, 10))
+	assert.Equal(t, "G", tiers.Assign("G", 1500, 10))
+	assert.Equal(t, "S", tiers.Assign("G", 1499, 10))
+	assert.Equal(t, "B", tiers.Assign("G", 1000, 10))
+
+	// Unknown tiers are reassigned
+	assert.Equal(t, "S", tiers.Assign("X", 1450, 10))
+}